@@ -0,0 +1,378 @@
+package gvm
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/x0y14/gvm/internal"
+)
+
+var mnemonics = func() map[string]Opcode {
+	m := map[string]Opcode{}
+	for op := Opcode(0); int(op) < len(operandKinds); op++ {
+		m[op.String()] = op
+	}
+	return m
+}()
+
+var registerNames = func() map[string]Register {
+	m := map[string]Register{
+		"acm1": ACM1,
+		"acm2": ACM2,
+	}
+	for _, reg := range []Register{PC, BP, SP, HP, ZF, SF, CF, OF} {
+		m[reg.String()] = reg
+	}
+	return m
+}()
+
+// register pc, sp, acm1などの名前かr0, r1, ...
+func register(name string) (Register, bool) {
+	if reg, ok := registerNames[name]; ok {
+		return reg, true
+	}
+	if len(name) < 2 || name[0] != 'r' || (name[1] == '0' && len(name) > 2) {
+		return nil, false
+	}
+	n, err := strconv.Atoi(name[1:])
+	if err != nil || n < 0 {
+		return nil, false
+	}
+	return GeneralPurposeRegister(n), true
+}
+
+var typeNames = func() map[string]PrimitiveType {
+	m := map[string]PrimitiveType{}
+	for _, t := range []PrimitiveType{TInteger, TChar, TBool, TFloat} {
+		m[t.String()] = t
+	}
+	return m
+}()
+
+// record .recordで宣言したレコード型
+type record struct {
+	fields map[string]Field
+	size   int
+}
+
+// fixup ラベルを参照しているProgram上の位置
+type fixup struct {
+	at  int
+	tok *internal.Token
+}
+
+type assembler struct {
+	tok     *internal.Token
+	program Program
+	labels  map[string]int
+	fixups  []fixup // 出てきた順. 未定義のラベルは最初のものを報告する
+	records map[string]record
+	arrays  map[string]Element
+}
+
+func (a *assembler) errorf(tok *internal.Token, format string, args ...any) error {
+	return fmt.Errorf("asm: %d: %s", tok.Position.Line+1, fmt.Sprintf(format, args...))
+}
+
+func (a *assembler) next() *internal.Token {
+	tok := a.tok
+	a.tok = a.tok.Next
+	for a.tok.Kind == internal.Comment {
+		a.tok = a.tok.Next
+	}
+	return tok
+}
+
+func (a *assembler) expect(kind internal.TokenKind) (*internal.Token, error) {
+	if a.tok.Kind != kind {
+		return nil, a.errorf(a.tok, "want=%s, got=%s", kind.String(), a.tok.Kind.String())
+	}
+	return a.next(), nil
+}
+
+// Assemble アセンブリのテキストをProgramにする.
+//
+//	loop:
+//	    add r1, 1      ; 即値: 1, -2, 1.5, true, false
+//	    mov [bp-1], r1 ; スタック: [bp+n], [sp+n]
+//	    store @0, r1   ; ヒープ: @n
+//	    lt r1, 10
+//	    je loop        ; ラベルはProgramAddressになる
+//
+// レコード型と配列型は宣言してから使う.
+//
+//	.record point(x: integer, y: float)
+//	.array buf: char[16]
+//	    alloc point        ; レコード名はサイズ(Integer)になる
+//	    stf r1, point.y, 1.5
+//	    alloc buf          ; 配列名はElementになる
+//	    ldx r2, r1, 3, buf
+func Assemble(src string) (Program, error) {
+	head, err := internal.Tokenize([]rune(src))
+	if err != nil {
+		return nil, fmt.Errorf("asm: %w", err)
+	}
+	a := &assembler{
+		tok:     &internal.Token{Next: head},
+		labels:  map[string]int{},
+		records: map[string]record{},
+		arrays:  map[string]Element{},
+	}
+	a.next()
+
+	for a.tok.Kind != internal.Eof {
+		if a.tok.Kind == internal.Dot {
+			if err := a.directive(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		tok, err := a.expect(internal.Identifier)
+		if err != nil {
+			return nil, err
+		}
+		name := string(tok.Raw)
+		if a.tok.Kind == internal.Colon {
+			a.next()
+			if _, ok := a.labels[name]; ok {
+				return nil, a.errorf(tok, "duplicate label: %s", name)
+			}
+			a.labels[name] = len(a.program)
+			continue
+		}
+		op, ok := mnemonics[name]
+		if !ok {
+			return nil, a.errorf(tok, "unknown mnemonic: %s", name)
+		}
+		a.program = append(a.program, op)
+		for i := 0; i < op.NumOperands(); i++ {
+			if i > 0 {
+				if _, err := a.expect(internal.Comma); err != nil {
+					return nil, err
+				}
+			}
+			operand, err := a.operand()
+			if err != nil {
+				return nil, err
+			}
+			a.program = append(a.program, operand)
+		}
+	}
+
+	for _, f := range a.fixups {
+		addr, ok := a.labels[string(f.tok.Raw)]
+		if !ok {
+			return nil, a.errorf(f.tok, "undefined label: %s", string(f.tok.Raw))
+		}
+		a.program[f.at] = ProgramAddress(addr)
+	}
+	return a.program, nil
+}
+
+func (a *assembler) number(negative bool) (Operand, error) {
+	sign := 1
+	if negative {
+		sign = -1
+	}
+	switch a.tok.Kind {
+	case internal.Integer:
+		i, err := a.next().GetValueAsInteger()
+		if err != nil {
+			return nil, err
+		}
+		return Integer(sign * i), nil
+	case internal.Float:
+		f, err := a.next().GetValueAsFloat()
+		if err != nil {
+			return nil, err
+		}
+		return Float(float64(sign) * f), nil
+	default:
+		return nil, a.errorf(a.tok, "want=number, got=%s", a.tok.Kind.String())
+	}
+}
+
+func (a *assembler) operand() (Word, error) {
+	switch tok := a.tok; tok.Kind {
+	case internal.Identifier:
+		a.next()
+		name := string(tok.Raw)
+		if reg, ok := register(name); ok {
+			return reg, nil
+		}
+		switch name {
+		case "true":
+			return Bool(true), nil
+		case "false":
+			return Bool(false), nil
+		}
+		if rec, ok := a.records[name]; ok {
+			if a.tok.Kind != internal.Dot {
+				return Integer(rec.size), nil
+			}
+			a.next()
+			tok, err := a.expect(internal.Identifier)
+			if err != nil {
+				return nil, err
+			}
+			field, ok := rec.fields[string(tok.Raw)]
+			if !ok {
+				return nil, a.errorf(tok, "undefined field: %s.%s", name, string(tok.Raw))
+			}
+			return field, nil
+		}
+		if elem, ok := a.arrays[name]; ok {
+			return elem, nil
+		}
+		a.fixups = append(a.fixups, fixup{at: len(a.program), tok: tok})
+		return ProgramAddress(-1), nil
+	case internal.Integer, internal.Float:
+		return a.number(false)
+	case internal.Sub:
+		a.next()
+		return a.number(true)
+	case internal.At:
+		a.next()
+		addr, err := a.expect(internal.Integer)
+		if err != nil {
+			return nil, err
+		}
+		v, err := addr.GetValueAsInteger()
+		if err != nil {
+			return nil, err
+		}
+		return HeapAddress(v), nil
+	case internal.Lcb:
+		return a.offset()
+	default:
+		return nil, a.errorf(tok, "unexpected token: %s", tok.Kind.String())
+	}
+}
+
+// offset [bp+n], [sp-n]
+func (a *assembler) offset() (Word, error) {
+	if _, err := a.expect(internal.Lcb); err != nil {
+		return nil, err
+	}
+	base, err := a.expect(internal.Identifier)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	if a.tok.Kind == internal.Add || a.tok.Kind == internal.Sub {
+		negative := a.next().Kind == internal.Sub
+		tok, err := a.expect(internal.Integer)
+		if err != nil {
+			return nil, err
+		}
+		if n, err = tok.GetValueAsInteger(); err != nil {
+			return nil, err
+		}
+		if negative {
+			n = -n
+		}
+	}
+	if _, err := a.expect(internal.Rcb); err != nil {
+		return nil, err
+	}
+	switch string(base.Raw) {
+	case BP.String():
+		return BpOffset(n), nil
+	case SP.String():
+		return SpOffset(n), nil
+	default:
+		return nil, a.errorf(base, "invalid offset base: %s", string(base.Raw))
+	}
+}
+
+func (a *assembler) primitiveType() (PrimitiveType, error) {
+	tok, err := a.expect(internal.Identifier)
+	if err != nil {
+		return 0, err
+	}
+	t, ok := typeNames[string(tok.Raw)]
+	if !ok {
+		return 0, a.errorf(tok, "unknown type: %s", string(tok.Raw))
+	}
+	return t, nil
+}
+
+// directive .record name(field: type, ...), .array name: type[n]
+func (a *assembler) directive() error {
+	if _, err := a.expect(internal.Dot); err != nil {
+		return err
+	}
+	kind, err := a.expect(internal.Identifier)
+	if err != nil {
+		return err
+	}
+	name, err := a.expect(internal.Identifier)
+	if err != nil {
+		return err
+	}
+	if _, ok := a.records[string(name.Raw)]; ok {
+		return a.errorf(name, "duplicate type: %s", string(name.Raw))
+	}
+	if _, ok := a.arrays[string(name.Raw)]; ok {
+		return a.errorf(name, "duplicate type: %s", string(name.Raw))
+	}
+
+	switch string(kind.Raw) {
+	case "record":
+		if _, err := a.expect(internal.Lrb); err != nil {
+			return err
+		}
+		rec := record{fields: map[string]Field{}}
+		for a.tok.Kind != internal.Rrb {
+			if rec.size > 0 {
+				if _, err := a.expect(internal.Comma); err != nil {
+					return err
+				}
+			}
+			field, err := a.expect(internal.Identifier)
+			if err != nil {
+				return err
+			}
+			if _, err := a.expect(internal.Colon); err != nil {
+				return err
+			}
+			t, err := a.primitiveType()
+			if err != nil {
+				return err
+			}
+			if _, ok := rec.fields[string(field.Raw)]; ok {
+				return a.errorf(field, "duplicate field: %s", string(field.Raw))
+			}
+			rec.fields[string(field.Raw)] = Field{Offset: rec.size, Type: t}
+			rec.size++
+		}
+		a.next()
+		a.records[string(name.Raw)] = rec
+	case "array":
+		if _, err := a.expect(internal.Colon); err != nil {
+			return err
+		}
+		t, err := a.primitiveType()
+		if err != nil {
+			return err
+		}
+		if _, err := a.expect(internal.Lcb); err != nil {
+			return err
+		}
+		tok, err := a.expect(internal.Integer)
+		if err != nil {
+			return err
+		}
+		n, err := tok.GetValueAsInteger()
+		if err != nil {
+			return err
+		}
+		if _, err := a.expect(internal.Rcb); err != nil {
+			return err
+		}
+		a.arrays[string(name.Raw)] = Element{Type: t, Len: n}
+	default:
+		return a.errorf(kind, "unknown directive: %s", string(kind.Raw))
+	}
+	return nil
+}
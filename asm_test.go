@@ -0,0 +1,107 @@
+package gvm
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAssemble(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect Program
+	}{
+		{
+			"empty",
+			"; nothing",
+			nil,
+		},
+		{
+			"operands",
+			`
+mov r1, 10
+mov r2, -3
+mov r3, 1.5
+mov acm1, -0.25
+mov acm2, true
+mov [bp-1], false
+push [sp+2]
+store @4, r1
+mov hp, @8
+`,
+			Program{
+				MOV, R1, Integer(10),
+				MOV, R2, Integer(-3),
+				MOV, R3, Float(1.5),
+				MOV, ACM1, Float(-0.25),
+				MOV, ACM2, Bool(true),
+				MOV, BpOffset(-1), Bool(false),
+				PUSH, SpOffset(2),
+				STORE, HeapAddress(4), R1,
+				MOV, HP, HeapAddress(8),
+			},
+		},
+		{
+			"registers",
+			"mov r0, r31\nmov acm1, r4\nmov acm2, r5",
+			Program{
+				MOV, R0, GeneralPurposeRegister(31),
+				MOV, ACM1, GeneralPurposeRegister(4),
+				MOV, ACM2, GeneralPurposeRegister(5),
+			},
+		},
+		{
+			"labels",
+			`
+    jmp start
+f:  ret
+start:
+    call f ; forward and backward
+    je start
+`,
+			Program{
+				JMP, ProgramAddress(3),
+				RET,
+				CALL, ProgramAddress(2),
+				JE, ProgramAddress(3),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Assemble(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expect, program); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestAssemble_Error(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect string
+	}{
+		{"mnemonic", "foo r1", "asm: 1: unknown mnemonic: foo"},
+		{"label", "nop\njmp end", "asm: 2: undefined label: end"},
+		{"labels", "jmp a\njmp b\njmp c\njmp d\njmp e\njmp f\njmp g\njmp h", "asm: 1: undefined label: a"},
+		{"duplicate", "a:\na:", "asm: 2: duplicate label: a"},
+		{"comma", "mov r1 r2", "asm: 1: want=,, got=Identifier"},
+		{"offset", "push [hp+1]", "asm: 1: invalid offset base: hp"},
+		{"register", "mov r01, 1", "asm: 1: undefined label: r01"},
+		{"truncated", "add r1,", "asm: 1: unexpected token: Eof"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Assemble(tt.src)
+			if err == nil || err.Error() != tt.expect {
+				t.Errorf("want=%q, got=%v", tt.expect, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package calc
+
+import (
+	"fmt"
+
+	"github.com/x0y14/gvm"
+	"github.com/x0y14/gvm/internal"
+)
+
+// Node 式の構文木
+type Node interface {
+	String() string
+}
+
+type (
+	// Number IntegerかFloatの定数
+	Number struct {
+		Value gvm.Immediate
+	}
+	// Var Compileで値を与える変数
+	Var struct {
+		Name string
+	}
+	// Neg -x
+	Neg struct {
+		X Node
+	}
+	// BinOp x op y. Opは+, -, *, /
+	BinOp struct {
+		Op   internal.TokenKind
+		X, Y Node
+	}
+)
+
+func (n *Number) String() string { return n.Value.String() }
+func (n *Var) String() string    { return n.Name }
+func (n *Neg) String() string    { return fmt.Sprintf("(-%s)", n.X) }
+func (n *BinOp) String() string  { return fmt.Sprintf("(%s %s %s)", n.X, n.Op, n.Y) }
+
+var opcodes = map[internal.TokenKind]gvm.Opcode{
+	internal.Add: gvm.ADD,
+	internal.Sub: gvm.SUB,
+	internal.Mul: gvm.MUL,
+	internal.Div: gvm.DIV,
+}
+
+type parser struct {
+	tok *internal.Token
+}
+
+func (p *parser) errorf(tok *internal.Token, format string, args ...any) error {
+	return fmt.Errorf("calc: %d: %s", tok.Position.StartedAt+1, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) next() *internal.Token {
+	tok := p.tok
+	p.tok = p.tok.Next
+	return tok
+}
+
+// Parse 式を構文木にする. *, /は+, -より強く, 同じ強さなら左から結びつく.
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/") unary }
+//	unary   = ("+" | "-") unary | primary
+//	primary = integer | float | identifier | "(" expr ")"
+func Parse(src string) (Node, error) {
+	head, err := internal.Tokenize([]rune(src))
+	if err != nil {
+		return nil, fmt.Errorf("calc: %w", err)
+	}
+	p := &parser{tok: head}
+	n, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.Kind != internal.Eof {
+		return nil, p.errorf(p.tok, "unexpected token: %s", p.tok.Kind)
+	}
+	return n, nil
+}
+
+func (p *parser) expr() (Node, error) {
+	return p.binary(p.term, internal.Add, internal.Sub)
+}
+
+func (p *parser) term() (Node, error) {
+	return p.binary(p.unary, internal.Mul, internal.Div)
+}
+
+func (p *parser) binary(operand func() (Node, error), ops ...internal.TokenKind) (Node, error) {
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		kind := p.tok.Kind
+		if kind != ops[0] && kind != ops[1] {
+			return x, nil
+		}
+		p.next()
+		y, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		x = &BinOp{Op: kind, X: x, Y: y}
+	}
+}
+
+func (p *parser) unary() (Node, error) {
+	switch p.tok.Kind {
+	case internal.Add:
+		p.next()
+		return p.unary()
+	case internal.Sub:
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &Neg{X: x}, nil
+	default:
+		return p.primary()
+	}
+}
+
+func (p *parser) primary() (Node, error) {
+	tok := p.next()
+	switch tok.Kind {
+	case internal.Integer:
+		v, err := tok.GetValueAsInteger()
+		if err != nil {
+			return nil, p.errorf(tok, "invalid integer: %s", string(tok.Raw))
+		}
+		return &Number{Value: gvm.Integer(v)}, nil
+	case internal.Float:
+		v, err := tok.GetValueAsFloat()
+		if err != nil {
+			return nil, p.errorf(tok, "invalid float: %s", string(tok.Raw))
+		}
+		return &Number{Value: gvm.Float(v)}, nil
+	case internal.Identifier:
+		return &Var{Name: string(tok.Raw)}, nil
+	case internal.Lrb:
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.Kind != internal.Rrb {
+			return nil, p.errorf(p.tok, "want=%s, got=%s", internal.Rrb, p.tok.Kind)
+		}
+		p.next()
+		return x, nil
+	default:
+		return nil, p.errorf(tok, "unexpected token: %s", tok.Kind)
+	}
+}
+
+type compiler struct {
+	program gvm.Program
+	vars    map[string]gvm.Immediate
+	depth   int
+	max     int
+}
+
+func (c *compiler) emit(words ...gvm.Word) {
+	c.program = append(c.program, words...)
+}
+
+func (c *compiler) push(v gvm.Operand) {
+	c.emit(gvm.PUSH, v)
+	c.depth++
+	c.max = max(c.max, c.depth)
+}
+
+func (c *compiler) pop(reg gvm.Register) {
+	c.emit(gvm.POP, reg)
+	c.depth--
+}
+
+// Compile 構文木をProgramにする. 変数はvarsの値(IntegerかFloat)に置き換える.
+// IntegerとFloatを混ぜた演算はIntegerをFloatに変換してから行う.
+// 戻り値の2つ目は実行に必要なスタックの深さ
+func Compile(n Node, vars map[string]gvm.Immediate) (gvm.Program, int, error) {
+	c := &compiler{vars: vars}
+	if _, err := c.node(n); err != nil {
+		return nil, 0, err
+	}
+	c.pop(gvm.R1)
+	return c.program, c.max, nil
+}
+
+// node 値を積むコードを出す. 戻り値は積んだ値の型
+func (c *compiler) node(n Node) (gvm.PrimitiveType, error) {
+	switch n := n.(type) {
+	case *Number:
+		c.push(n.Value)
+		return n.Value.Type(), nil
+	case *Var:
+		v, ok := c.vars[n.Name]
+		if !ok {
+			return 0, fmt.Errorf("calc: undefined variable: %s", n.Name)
+		}
+		if t := v.Type(); t != gvm.TInteger && t != gvm.TFloat {
+			return 0, fmt.Errorf("calc: %s: want=integer or float, got=%s", n.Name, t)
+		}
+		c.push(v)
+		return v.Type(), nil
+	case *Neg:
+		t, err := c.node(n.X)
+		if err != nil {
+			return 0, err
+		}
+		var zero gvm.Immediate = gvm.Integer(0)
+		if t == gvm.TFloat {
+			zero = gvm.Float(0)
+		}
+		c.pop(gvm.R2)
+		c.emit(gvm.MOV, gvm.R1, zero, gvm.SUB, gvm.R1, gvm.R2)
+		c.push(gvm.R1)
+		return t, nil
+	case *BinOp:
+		tx, err := c.node(n.X)
+		if err != nil {
+			return 0, err
+		}
+		ty, err := c.node(n.Y)
+		if err != nil {
+			return 0, err
+		}
+		c.pop(gvm.R2)
+		c.pop(gvm.R1)
+		t := tx
+		if tx != ty {
+			t = gvm.TFloat
+			if tx == gvm.TInteger {
+				c.emit(gvm.ITOF, gvm.R1, gvm.R1)
+			} else {
+				c.emit(gvm.ITOF, gvm.R2, gvm.R2)
+			}
+		}
+		c.emit(opcodes[n.Op], gvm.R1, gvm.R2)
+		c.push(gvm.R1)
+		return t, nil
+	default:
+		return 0, fmt.Errorf("calc: unsupported node: %T", n)
+	}
+}
+
+// Eval 式を計算する. 整数の0除算はgvm.ErrDivisionByZeroになる
+func Eval(src string, vars map[string]gvm.Immediate) (gvm.Operand, error) {
+	n, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	program, depth, err := Compile(n, vars)
+	if err != nil {
+		return nil, err
+	}
+	r := gvm.NewRuntime(program, &gvm.Config{StackSize: depth + 1})
+	if err := r.Run(); err != nil {
+		return nil, err
+	}
+	return r.Register(gvm.R1), nil
+}
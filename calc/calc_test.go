@@ -0,0 +1,135 @@
+package calc
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/x0y14/gvm"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect string
+	}{
+		{"precedence", "1+2*3", "(1 + (2 * 3))"},
+		{"left assoc", "8-4-2", "((8 - 4) - 2)"},
+		{"div", "8/4*2", "((8 / 4) * 2)"},
+		{"paren", "(1+2)*3", "((1 + 2) * 3)"},
+		{"unary", "+9-12098*898123", "(9 - (12098 * 898123))"},
+		{"neg", "--x*-(y)", "((-(-x)) * (-y))"},
+		{"float", "1.5 * 2", "(1.5 * 2)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := Parse(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expect, n.String()); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestEval(t *testing.T) {
+	vars := map[string]gvm.Immediate{
+		"x":    gvm.Integer(7),
+		"rate": gvm.Float(0.5),
+	}
+	tests := []struct {
+		name   string
+		src    string
+		expect gvm.Operand
+	}{
+		{"integer", "42", gvm.Integer(42)},
+		{"precedence", "1 + 2 * 3 - 4", gvm.Integer(3)},
+		{"paren", "(1 + 2) * (3 - 4)", gvm.Integer(-3)},
+		{"corpus", "+9-12098*898123", gvm.Integer(9 - 12098*898123)},
+		{"division truncates", "-7 / 2", gvm.Integer(-3)},
+		{"neg", "-(x - 10)", gvm.Integer(3)},
+		{"float", "1.5 * 4", gvm.Float(6)},
+		{"mixed", "x * rate + 1", gvm.Float(4.5)},
+		{"mixed right", "rate * 3 - 1 / 4", gvm.Float(1.5)},
+		{"float div", "1.0 / 0", gvm.Float(math.Inf(1))},
+		{"deep", "((((((1+2)*3)+4)*5)+6)*7)", gvm.Integer(497)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Eval(tt.src, vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expect, got); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestCompile(t *testing.T) {
+	n, err := Parse("1 + x * 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, depth, err := Compile(n, map[string]gvm.Immediate{"x": gvm.Integer(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := gvm.Program{
+		gvm.PUSH, gvm.Integer(1),
+		gvm.PUSH, gvm.Integer(3),
+		gvm.PUSH, gvm.Integer(2),
+		gvm.POP, gvm.R2,
+		gvm.POP, gvm.R1,
+		gvm.MUL, gvm.R1, gvm.R2,
+		gvm.PUSH, gvm.R1,
+		gvm.POP, gvm.R2,
+		gvm.POP, gvm.R1,
+		gvm.ADD, gvm.R1, gvm.R2,
+		gvm.PUSH, gvm.R1,
+		gvm.POP, gvm.R1,
+	}
+	if diff := cmp.Diff(expect, program); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if depth != 3 {
+		t.Errorf("depth: want=3, got=%d", depth)
+	}
+}
+
+func TestEval_Error(t *testing.T) {
+	vars := map[string]gvm.Immediate{"flag": gvm.Bool(true)}
+	tests := []struct {
+		name   string
+		src    string
+		expect string
+	}{
+		{"empty", "", "calc: 1: unexpected token: Eof"},
+		{"trailing", "1 2", "calc: 3: unexpected token: Integer"},
+		{"unclosed", "(1 + 2", "calc: 7: want=), got=Eof"},
+		{"operator", "1 + * 2", "calc: 5: unexpected token: *"},
+		{"lexer", "1 $ 2", "calc: unexpected rune: $"},
+		{"undefined", "y + 1", "calc: undefined variable: y"},
+		{"type", "flag * 2", "calc: flag: want=integer or float, got=bool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Eval(tt.src, vars)
+			if err == nil {
+				t.Fatal("want error")
+			}
+			if diff := cmp.Diff(tt.expect, err.Error()); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+
+	if _, err := Eval("1 / (2 - 2)", nil); !errors.Is(err, gvm.ErrDivisionByZero) {
+		t.Errorf("want=division by zero, got=%v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package cfg
+
+// Dominators 各ブロックの直接支配ブロックのID. 入口と, 入口から届かないブロックは-1.
+// 入口が複数あるので, すべての入口の上に仮想の根を置いて計算する(Cooper, Harvey, Kennedy)
+func (g *Graph) Dominators() []int {
+	n := len(g.Blocks)
+	root := n
+	idom := make([]int, n+1)
+	for i := range idom {
+		idom[i] = -1
+	}
+	idom[root] = root
+
+	// 仮想の根からの後順
+	order := make([]int, n+1) // ブロックの後順の番号
+	var post []int
+	visited := make([]bool, n)
+	var visit func(id int)
+	visit = func(id int) {
+		visited[id] = true
+		for _, s := range g.Blocks[id].Succs {
+			if !visited[s] {
+				visit(s)
+			}
+		}
+		order[id] = len(post)
+		post = append(post, id)
+	}
+	for _, e := range g.Entries {
+		if !visited[e] {
+			visit(e)
+		}
+	}
+	order[root] = len(post)
+
+	preds := func(id int) []int {
+		ps := g.Blocks[id].Preds
+		if contains(g.Entries, id) {
+			ps = append(ps[:len(ps):len(ps)], root)
+		}
+		return ps
+	}
+	intersect := func(a, b int) int {
+		for a != b {
+			for order[a] < order[b] {
+				a = idom[a]
+			}
+			for order[b] < order[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(post) - 1; 0 <= i; i-- {
+			id := post[i]
+			d := -1
+			for _, p := range preds(id) {
+				if p != root && (!visited[p] || idom[p] == -1) {
+					continue
+				}
+				if d == -1 {
+					d = p
+				} else {
+					d = intersect(p, d)
+				}
+			}
+			if d != idom[id] {
+				idom[id] = d
+				changed = true
+			}
+		}
+	}
+
+	result := idom[:n]
+	for i, d := range result {
+		if d == root {
+			result[i] = -1
+		}
+	}
+	return result
+}
+
+// Dominates aがbを支配するか. idomはDominatorsの結果
+func Dominates(idom []int, a, b int) bool {
+	for ; b != -1; b = idom[b] {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,180 @@
+package cfg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/x0y14/gvm"
+)
+
+// Inst Program上の命令
+type Inst = gvm.Instruction
+
+// Block 基本ブロック. 先頭以外に飛び込まれず, 最後の命令以外で制御が移らない
+type Block struct {
+	ID    int
+	Insts []Inst
+	Succs []int // 後続ブロックのID. 分岐はフォールスルー, 飛び先の順
+	Preds []int
+}
+
+// Start ブロック先頭の命令の位置
+func (b *Block) Start() int { return b.Insts[0].At }
+
+// End ブロックの次の命令の位置
+func (b *Block) End() int { return b.Insts[len(b.Insts)-1].Next() }
+
+// Graph 制御フローグラフ. 関数(CALL, SPAWNの飛び先)ごとに分かれ, 呼び出しは次の命令へ進むものとして扱う
+type Graph struct {
+	Program gvm.Program
+	Blocks  []*Block
+	Entries []int // Programの先頭と, 呼び出される関数の先頭ブロックのID
+	at      map[int]int
+}
+
+// Block 位置atから始まるブロック
+func (g *Graph) Block(at int) (*Block, bool) {
+	id, ok := g.at[at]
+	if !ok {
+		return nil, false
+	}
+	return g.Blocks[id], true
+}
+
+// conditional 条件付きジャンプ
+var conditional = map[gvm.Opcode]bool{
+	gvm.JE: true, gvm.JNE: true,
+	gvm.JL: true, gvm.JLE: true, gvm.JG: true, gvm.JGE: true,
+	gvm.JB: true, gvm.JBE: true, gvm.JA: true, gvm.JAE: true,
+	gvm.JO: true, gvm.JNO: true, gvm.JS: true, gvm.JNS: true,
+}
+
+// branches 分岐先. 2つ目の戻り値は次の命令へ進むかどうか
+func branches(inst Inst) ([]int, bool) {
+	switch op := inst.Op; {
+	case op == gvm.JMP:
+		return []int{gvm.Target(inst.At, inst.Operands[0])}, false
+	case conditional[op], op == gvm.TRY:
+		return []int{gvm.Target(inst.At, inst.Operands[0])}, true
+	case op == gvm.RET, op == gvm.THROW:
+		return nil, false
+	default:
+		return nil, true
+	}
+}
+
+// endsBlock 直後から新しいブロックを始める命令
+func endsBlock(op gvm.Opcode) bool {
+	switch op {
+	case gvm.JMP, gvm.TRY, gvm.RET, gvm.THROW, gvm.CALL, gvm.SPAWN:
+		return true
+	default:
+		return conditional[op]
+	}
+}
+
+// Build Programを基本ブロックに分けてグラフを作る. 先にgvm.Verifyで検査する
+func Build(program gvm.Program) (*Graph, error) {
+	if err := gvm.Verify(program); err != nil {
+		return nil, err
+	}
+	insts := gvm.Instructions(program)
+
+	leaders := map[int]bool{}
+	entries := []int{}
+	if len(insts) > 0 {
+		leaders[0] = true
+		entries = append(entries, 0)
+	}
+	for _, inst := range insts {
+		if endsBlock(inst.Op) {
+			leaders[inst.Next()] = true
+		}
+		if inst.Op == gvm.CALL || inst.Op == gvm.SPAWN {
+			entries = append(entries, gvm.Target(inst.At, inst.Operands[0]))
+		}
+		dsts, _ := branches(inst)
+		for _, dst := range dsts {
+			leaders[dst] = true
+		}
+	}
+	for _, at := range entries {
+		leaders[at] = true
+	}
+
+	g := &Graph{Program: program, at: map[int]int{}}
+	for _, inst := range insts {
+		if leaders[inst.At] {
+			g.at[inst.At] = len(g.Blocks)
+			g.Blocks = append(g.Blocks, &Block{ID: len(g.Blocks)})
+		}
+		b := g.Blocks[len(g.Blocks)-1]
+		b.Insts = append(b.Insts, inst)
+	}
+
+	seen := map[int]bool{}
+	for _, at := range entries {
+		id, ok := g.at[at]
+		if !ok || seen[id] {
+			continue // Programの終わりを呼び出している
+		}
+		seen[id] = true
+		g.Entries = append(g.Entries, id)
+	}
+	for _, b := range g.Blocks {
+		last := b.Insts[len(b.Insts)-1]
+		dsts, falls := branches(last)
+		if falls {
+			dsts = append([]int{last.Next()}, dsts...)
+		}
+		for _, dst := range dsts {
+			id, ok := g.at[dst]
+			if !ok || contains(b.Succs, id) {
+				continue // Programの終わり
+			}
+			b.Succs = append(b.Succs, id)
+			g.Blocks[id].Preds = append(g.Blocks[id].Preds, b.ID)
+		}
+	}
+	for _, b := range g.Blocks {
+		sort.Ints(b.Preds)
+	}
+	return g, nil
+}
+
+func contains(ids []int, id int) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Dot Graphvizのdot形式で書き出す. 入口のブロックは二重枠にする
+func (g *Graph) Dot() string {
+	var sb strings.Builder
+	sb.WriteString("digraph cfg {\n")
+	sb.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	for _, b := range g.Blocks {
+		var label strings.Builder
+		fmt.Fprintf(&label, "b%d @%d\\l", b.ID, b.Start())
+		for _, inst := range b.Insts {
+			label.WriteString(inst.String())
+			label.WriteString("\\l")
+		}
+		peripheries := ""
+		if contains(g.Entries, b.ID) {
+			peripheries = ", peripheries=2"
+		}
+		fmt.Fprintf(&sb, "\tb%d [label=\"%s\"%s];\n", b.ID, label.String(), peripheries)
+	}
+	for _, b := range g.Blocks {
+		for _, s := range b.Succs {
+			fmt.Fprintf(&sb, "\tb%d -> b%d;\n", b.ID, s)
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
@@ -0,0 +1,317 @@
+package cfg
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/x0y14/gvm"
+)
+
+const loopSrc = `
+    mov r1, 0
+loop:
+    cmp r1, 10
+    jge done
+    add r1, 1
+    jmp loop
+done:
+    call f
+    jmp end
+f:
+    mov r2, [bp+2]
+    ret
+end:
+`
+
+func build(t *testing.T, src string) *Graph {
+	t.Helper()
+	program, err := gvm.Assemble(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := Build(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// shape ブロックごとの[先頭, 終わり), 後続, 先行
+type shape struct {
+	Start, End   int
+	Succs, Preds []int
+}
+
+func shapes(g *Graph) []shape {
+	var s []shape
+	for _, b := range g.Blocks {
+		s = append(s, shape{b.Start(), b.End(), b.Succs, b.Preds})
+	}
+	return s
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		blocks  []shape
+		entries []int
+	}{
+		{"straight", "mov r1, 1\nadd r1, 2", []shape{{0, 6, nil, nil}}, []int{0}},
+		{"loop", loopSrc, []shape{
+			{0, 3, []int{1}, nil},
+			{3, 8, []int{2, 3}, []int{0, 2}},
+			{8, 13, []int{1}, []int{1}},
+			{13, 15, []int{4}, []int{1}},
+			{15, 17, nil, []int{3}},
+			{17, 21, nil, nil},
+		}, []int{0, 5}},
+		{"try", `
+    try catch
+    div r1, r2
+    endtry
+    jmp end
+catch:
+    mov r2, 0
+end:
+`, []shape{
+			{0, 2, []int{1, 2}, nil},
+			{2, 8, nil, []int{0}},
+			{8, 11, nil, []int{0}},
+		}, []int{0}},
+		{"spawn and throw", `
+    spawn worker, 1
+    join r1
+    throw r1
+worker:
+    ret
+`, []shape{
+			{0, 3, []int{1}, nil},
+			{3, 7, nil, []int{0}},
+			{7, 8, nil, nil},
+		}, []int{0, 2}},
+		{"empty", "", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := build(t, tt.src)
+			if diff := cmp.Diff(tt.blocks, shapes(g)); diff != "" {
+				t.Errorf("blocks: %s", diff)
+			}
+			if diff := cmp.Diff(tt.entries, g.Entries); diff != "" {
+				t.Errorf("entries: %s", diff)
+			}
+			for _, b := range g.Blocks {
+				if got, ok := g.Block(b.Start()); !ok || got != b {
+					t.Errorf("block @%d: want=b%d", b.Start(), b.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestBuild_Error(t *testing.T) {
+	if _, err := Build(gvm.Program{gvm.JMP, gvm.ProgramAddress(5)}); err == nil {
+		t.Error("want verify error")
+	}
+}
+
+func TestDominators(t *testing.T) {
+	g := build(t, loopSrc)
+	idom := g.Dominators()
+	if diff := cmp.Diff([]int{-1, 0, 1, 1, 3, -1}, idom); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if !Dominates(idom, 1, 4) || Dominates(idom, 2, 3) || Dominates(idom, 0, 5) || !Dominates(idom, 5, 5) {
+		t.Error("dominates")
+	}
+
+	// 合流点の支配ブロックは分岐元
+	g = build(t, `
+    cmp r1, 0
+    je else
+    mov r2, 1
+    jmp end
+else:
+    mov r2, 2
+end:
+    mov r1, r2
+`)
+	if diff := cmp.Diff([]int{-1, 0, 0, 0}, g.Dominators()); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func names(s Set) []string {
+	var ns []string
+	for _, v := range s.Sorted() {
+		ns = append(ns, v.String())
+	}
+	return ns
+}
+
+func TestLiveness(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		conv    *Convention // nilならLiveness
+		in, out [][]string
+	}{
+		{"loop", loopSrc, &Frontend,
+			[][]string{nil, {"r1"}, {"r1"}, nil, {"r1"}, {"[bp+2]", "r1"}},
+			[][]string{{"r1"}, {"r1"}, {"r1"}, {"r1"}, {"r1"}, nil},
+		},
+		// fがr2を読むかもしれないので, callまでr2が生きている
+		{"loop conservative", loopSrc, nil,
+			[][]string{{"r2"}, {"r1", "r2"}, {"r1", "r2"}, {"r1", "r2"}, {"r1"}, {"[bp+2]", "r1"}},
+			[][]string{{"r1", "r2"}, {"r1", "r2"}, {"r1", "r2"}, {"r1"}, {"r1"}, nil},
+		},
+		{"call reads registers", `
+    mov r2, 1
+    call f
+    jmp end
+f:
+    mov r1, r2
+    ret
+end:
+`, nil,
+			[][]string{{"r1"}, {"r1"}, {"r2"}},
+			[][]string{{"r1"}, {"r1"}, nil},
+		},
+		{"flags", `
+    cmp r1, r2
+    mov r3, 1
+    jl end
+    mov r1, r3
+end:
+`,
+			nil,
+			[][]string{{"r1", "r2"}, {"r3"}},
+			[][]string{{"r1", "r3"}, {"r1"}},
+		},
+		{"dead store", `
+    mov [bp-1], 1
+    mov r2, [bp-2]
+    mov r1, r2
+`,
+			nil,
+			[][]string{{"[bp-2]"}},
+			[][]string{{"r1"}},
+		},
+		{"escaped", `
+    mov [bp-1], 1
+    lea r2, [bp-1]
+    jmp next
+next:
+    load r1, r2
+`,
+			nil,
+			[][]string{nil, {"[bp-1]", "r2"}},
+			[][]string{{"[bp-1]", "r2"}, {"r1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := build(t, tt.src)
+			l := g.Liveness()
+			if tt.conv != nil {
+				l = g.LivenessWith(*tt.conv)
+			}
+			var in, out [][]string
+			for i := range g.Blocks {
+				in = append(in, names(l.In[i]))
+				out = append(out, names(l.Out[i]))
+			}
+			if diff := cmp.Diff(tt.in, in); diff != "" {
+				t.Errorf("in: %s", diff)
+			}
+			if diff := cmp.Diff(tt.out, out); diff != "" {
+				t.Errorf("out: %s", diff)
+			}
+		})
+	}
+}
+
+func TestUseDef(t *testing.T) {
+	tests := []struct {
+		inst     Inst
+		use, def []gvm.Operand
+	}{
+		{Inst{Op: gvm.MOV, Operands: []gvm.Word{gvm.BpOffset(-1), gvm.R2}}, []gvm.Operand{gvm.R2}, []gvm.Operand{gvm.BpOffset(-1)}},
+		{Inst{Op: gvm.ADD, Operands: []gvm.Word{gvm.R1, gvm.Integer(1)}}, []gvm.Operand{gvm.R1}, []gvm.Operand{gvm.R1, gvm.ZF, gvm.SF, gvm.CF, gvm.OF}},
+		{Inst{Op: gvm.STORE, Operands: []gvm.Word{gvm.R1, gvm.R2}}, []gvm.Operand{gvm.R1, gvm.R2}, nil},
+		{Inst{Op: gvm.LDX, Operands: []gvm.Word{gvm.R1, gvm.R2, gvm.R3, gvm.Element{Type: gvm.TInteger, Len: 4}}}, []gvm.Operand{gvm.R2, gvm.R3}, []gvm.Operand{gvm.R1}},
+		{Inst{Op: gvm.JG, Operands: []gvm.Word{gvm.ProgramAddress(0)}}, []gvm.Operand{gvm.ZF, gvm.SF, gvm.OF}, nil},
+		{Inst{Op: gvm.JE, Operands: []gvm.Word{gvm.ProgramAddress(0)}}, []gvm.Operand{gvm.ZF, gvm.SF}, nil},
+		{Inst{Op: gvm.JB, Operands: []gvm.Word{gvm.ProgramAddress(0)}}, []gvm.Operand{gvm.ZF, gvm.SF, gvm.CF}, nil},
+		{Inst{Op: gvm.JS, Operands: []gvm.Word{gvm.ProgramAddress(0)}}, []gvm.Operand{gvm.SF}, nil},
+		{Inst{Op: gvm.EQ, Operands: []gvm.Word{gvm.R1, gvm.Integer(0)}}, []gvm.Operand{gvm.R1}, []gvm.Operand{gvm.ZF, gvm.SF, gvm.CF, gvm.OF}},
+		{Inst{Op: gvm.RECV, Operands: []gvm.Word{gvm.R1, gvm.R2}}, []gvm.Operand{gvm.R2}, []gvm.Operand{gvm.R1, gvm.ZF, gvm.SF, gvm.CF, gvm.OF}},
+		// 規約が分からないので, 汎用レジスタは全て読まれ, 何も書き換えられないとする
+		{Inst{Op: gvm.CALL, Operands: []gvm.Word{gvm.ProgramAddress(0)}}, []gvm.Operand{gvm.R0, gvm.R1, gvm.R2, gvm.R3, gvm.ACM1, gvm.ACM2}, nil},
+		{Inst{Op: gvm.NCALL, Operands: []gvm.Word{gvm.Integer(0)}}, []gvm.Operand{gvm.R0, gvm.R1, gvm.R2, gvm.R3, gvm.ACM1, gvm.ACM2}, nil},
+		{Inst{Op: gvm.RET}, []gvm.Operand{gvm.R0, gvm.R1, gvm.R2, gvm.R3, gvm.ACM1, gvm.ACM2}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.inst.String(), func(t *testing.T) {
+			use, def := UseDef(tt.inst)
+			if diff := cmp.Diff(tt.use, use); diff != "" {
+				t.Errorf("use: %s", diff)
+			}
+			if diff := cmp.Diff(tt.def, def); diff != "" {
+				t.Errorf("def: %s", diff)
+			}
+		})
+	}
+}
+
+func TestConvention_UseDef(t *testing.T) {
+	tests := []struct {
+		inst     Inst
+		use, def []gvm.Operand
+	}{
+		{Inst{Op: gvm.CALL, Operands: []gvm.Word{gvm.ProgramAddress(0)}}, nil, []gvm.Operand{gvm.R1}},
+		{Inst{Op: gvm.NCALL, Operands: []gvm.Word{gvm.Integer(0)}}, nil, []gvm.Operand{gvm.R1}},
+		{Inst{Op: gvm.RET}, []gvm.Operand{gvm.R1}, nil},
+		{Inst{Op: gvm.MOV, Operands: []gvm.Word{gvm.R1, gvm.R2}}, []gvm.Operand{gvm.R2}, []gvm.Operand{gvm.R1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.inst.String(), func(t *testing.T) {
+			use, def := Frontend.UseDef(tt.inst)
+			if diff := cmp.Diff(tt.use, use); diff != "" {
+				t.Errorf("use: %s", diff)
+			}
+			if diff := cmp.Diff(tt.def, def); diff != "" {
+				t.Errorf("def: %s", diff)
+			}
+		})
+	}
+}
+
+func TestGraph_Dot(t *testing.T) {
+	g := build(t, `
+loop:
+    add r1, 1
+    cmp r1, 3
+    jl loop
+    call f
+    mov r3, 1
+f:
+    ret
+`)
+	expect := `digraph cfg {
+	node [shape=box, fontname="monospace"];
+	b0 [label="b0 @0\ladd r1, 1\lcmp r1, 3\ljl @0\l", peripheries=2];
+	b1 [label="b1 @8\lcall @13\l"];
+	b2 [label="b2 @10\lmov r3, 1\l"];
+	b3 [label="b3 @13\lret\l", peripheries=2];
+	b0 -> b1;
+	b0 -> b0;
+	b1 -> b2;
+	b2 -> b3;
+}
+`
+	if diff := cmp.Diff(expect, g.Dot()); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
@@ -0,0 +1,275 @@
+package cfg
+
+import (
+	"sort"
+
+	"github.com/x0y14/gvm"
+)
+
+// Var 生存解析の対象. gvm.Registerか, フレーム内のスタックの位置gvm.BpOffset
+type Var = gvm.Operand
+
+// Set Varの集合
+type Set map[Var]struct{}
+
+func (s Set) Has(v Var) bool {
+	_, ok := s[v]
+	return ok
+}
+
+// Sorted 文字列表現の順に並べる
+func (s Set) Sorted() []Var {
+	vars := make([]Var, 0, len(s))
+	for v := range s {
+		vars = append(vars, v)
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].String() < vars[j].String() })
+	return vars
+}
+
+func (s Set) equal(t Set) bool {
+	if len(s) != len(t) {
+		return false
+	}
+	for v := range s {
+		if !t.Has(v) {
+			return false
+		}
+	}
+	return true
+}
+
+var flags = []Var{gvm.ZF, gvm.SF, gvm.CF, gvm.OF}
+
+// reads 条件付きジャンプが読むフラグ.
+// JO, JNO, JS, JNS以外はNaNとの比較(ZFとSFが両方立つ)を見分けるので, ZFとSFも読む
+var reads = map[gvm.Opcode][]Var{
+	gvm.JE:  {gvm.ZF, gvm.SF},
+	gvm.JNE: {gvm.ZF, gvm.SF},
+	gvm.JL:  {gvm.ZF, gvm.SF, gvm.OF},
+	gvm.JLE: {gvm.ZF, gvm.SF, gvm.OF},
+	gvm.JG:  {gvm.ZF, gvm.SF, gvm.OF},
+	gvm.JGE: {gvm.ZF, gvm.SF, gvm.OF},
+	gvm.JB:  {gvm.ZF, gvm.SF, gvm.CF},
+	gvm.JBE: {gvm.ZF, gvm.SF, gvm.CF},
+	gvm.JA:  {gvm.ZF, gvm.SF, gvm.CF},
+	gvm.JAE: {gvm.ZF, gvm.SF, gvm.CF},
+	gvm.JO:  {gvm.OF},
+	gvm.JNO: {gvm.OF},
+	gvm.JS:  {gvm.SF},
+	gvm.JNS: {gvm.SF},
+}
+
+// variable 追跡できるオペランドならVar
+func variable(w gvm.Word) (Var, bool) {
+	switch w := w.(type) {
+	case gvm.Register:
+		return w, true
+	case gvm.BpOffset:
+		return w, true
+	default:
+		return nil, false
+	}
+}
+
+// Convention CALL, NCALL, RETでのレジスタの受け渡し
+type Convention struct {
+	Args    []Var // 呼び出し先が読むかもしれないレジスタ. CALL, NCALLが読む
+	Results []Var // 呼び出し先が必ず書き換えるレジスタ. CALL, NCALLが書き換える
+	Returns []Var // 戻ったあと呼び出し元が読むかもしれないレジスタ. RETが読む
+}
+
+// Frontend このリポジトリのフロントエンドの規約. 引数はスタック, 戻り値はR1
+var Frontend = Convention{Results: []Var{gvm.R1}, Returns: []Var{gvm.R1}}
+
+// Conservative 呼び出し先について何も仮定しない規約.
+// registersはCALL, NCALLで全て読まれ, RETで全て呼び出し元へ返り, どれも書き換えられるとは見なさない
+func Conservative(registers []Var) Convention {
+	return Convention{Args: registers, Returns: registers}
+}
+
+// generalPurpose R0からn個の汎用レジスタ
+func generalPurpose(n int) []Var {
+	regs := make([]Var, n)
+	for i := range regs {
+		regs[i] = gvm.GeneralPurposeRegister(i)
+	}
+	return regs
+}
+
+// UseDef 命令が読むVarと, 必ず書き換えるVar.
+// 呼び出し規約はgvm.DefaultRegisters個の汎用レジスタでのConservativeとする.
+// 規約が分かっているならConvention.UseDefを使う
+func UseDef(inst Inst) (use, def []Var) {
+	return Conservative(generalPurpose(gvm.DefaultRegisters)).UseDef(inst)
+}
+
+// UseDef 規約cでの, 命令が読むVarと必ず書き換えるVar.
+// LEAで位置を取られたスタックはLOAD, STOREでいつ読み書きされるか分からないので, Livenessが別に扱う
+func (c Convention) UseDef(inst Inst) (use, def []Var) {
+	ops := inst.Operands
+	uses := func(ws ...gvm.Word) {
+		for _, w := range ws {
+			if v, ok := variable(w); ok {
+				use = append(use, v)
+			}
+		}
+	}
+	defs := func(ws ...gvm.Word) {
+		for _, w := range ws {
+			if v, ok := variable(w); ok {
+				def = append(def, v)
+			}
+		}
+	}
+	switch op := inst.Op; op {
+	case gvm.MOV, gvm.LOAD, gvm.LEA, gvm.TYPEOF,
+		gvm.ITOF, gvm.FTOI, gvm.CTOI, gvm.ITOC, gvm.ITOB, gvm.BTOI,
+		gvm.CTOB, gvm.BTOC, gvm.FTOB, gvm.BTOF, gvm.CTOF, gvm.FTOC:
+		if op != gvm.LEA {
+			uses(ops[1])
+		}
+		defs(ops[0])
+	case gvm.ADD, gvm.SUB, gvm.MUL, gvm.DIV:
+		uses(ops...)
+		defs(ops[0])
+		def = append(def, flags...)
+	case gvm.CMP:
+		uses(ops...)
+		def = append(def, flags...)
+	case gvm.EQ, gvm.NE, gvm.LT, gvm.LE:
+		// 結果はZFに入り, 他のフラグは下ろされる
+		uses(ops...)
+		def = append(def, flags...)
+	case gvm.POP, gvm.IN:
+		defs(ops[0])
+	case gvm.LDF, gvm.LDX:
+		uses(ops[1:]...)
+		defs(ops[0])
+	case gvm.CHAN:
+		uses(ops[1])
+		defs(ops[0])
+	case gvm.RECV:
+		uses(ops[1])
+		defs(ops[0])
+		def = append(def, flags...)
+	case gvm.CALL, gvm.NCALL:
+		use = append(use, c.Args...)
+		def = append(def, c.Results...)
+	case gvm.SPAWN, gvm.JOIN:
+		uses(ops[len(ops)-1])
+		def = append(def, gvm.R1)
+	case gvm.RET:
+		use = append(use, c.Returns...)
+	default:
+		if r, ok := reads[op]; ok {
+			use = append(use, r...)
+			break
+		}
+		uses(ops...) // PUSH, ALLOC, STORE, STF, STX, THROW, SEND, CLOSE
+	}
+	return use, def
+}
+
+// Liveness ブロックの入口と出口で生きているVar
+type Liveness struct {
+	In, Out []Set // ブロックのIDごと
+}
+
+// Liveness Programに出てくる汎用レジスタでのConservativeな規約で生存解析をする
+func (g *Graph) Liveness() *Liveness {
+	var regs []Var
+	seen := Set{}
+	for _, w := range g.Program {
+		if r, ok := w.(gvm.GeneralPurposeRegister); ok && !seen.Has(r) {
+			seen[r] = struct{}{}
+			regs = append(regs, r)
+		}
+	}
+	sort.Slice(regs, func(i, j int) bool {
+		return regs[i].(gvm.GeneralPurposeRegister) < regs[j].(gvm.GeneralPurposeRegister)
+	})
+	return g.LivenessWith(Conservative(regs))
+}
+
+// LivenessWith 規約cでの生存解析. Programの終わりに着いたときはR1が生きているとする.
+// LEAで位置を取ったスタックは, LOAD, STOREのたびに読まれるものとして扱う
+func (g *Graph) LivenessWith(c Convention) *Liveness {
+	escaped := Set{}
+	for _, b := range g.Blocks {
+		for _, inst := range b.Insts {
+			if inst.Op != gvm.LEA {
+				continue
+			}
+			if v, ok := variable(inst.Operands[1]); ok {
+				escaped[v] = struct{}{}
+			}
+		}
+	}
+	// ブロックごとのuse(先に読むもの)とdef
+	use := make([]Set, len(g.Blocks))
+	def := make([]Set, len(g.Blocks))
+	for _, b := range g.Blocks {
+		use[b.ID], def[b.ID] = Set{}, Set{}
+		for _, inst := range b.Insts {
+			u, d := c.UseDef(inst)
+			if inst.Op == gvm.LOAD || inst.Op == gvm.STORE {
+				for v := range escaped {
+					u = append(u, v)
+				}
+			}
+			for _, v := range u {
+				if !def[b.ID].Has(v) {
+					use[b.ID][v] = struct{}{}
+				}
+			}
+			for _, v := range d {
+				def[b.ID][v] = struct{}{}
+			}
+		}
+	}
+
+	l := &Liveness{In: make([]Set, len(g.Blocks)), Out: make([]Set, len(g.Blocks))}
+	for i := range g.Blocks {
+		l.In[i], l.Out[i] = Set{}, Set{}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(g.Blocks) - 1; 0 <= i; i-- {
+			b := g.Blocks[i]
+			out := Set{}
+			if g.exits(b) {
+				out[gvm.R1] = struct{}{}
+			}
+			for _, s := range b.Succs {
+				for v := range l.In[s] {
+					out[v] = struct{}{}
+				}
+			}
+			in := Set{}
+			for v := range use[i] {
+				in[v] = struct{}{}
+			}
+			for v := range out {
+				if !def[i].Has(v) {
+					in[v] = struct{}{}
+				}
+			}
+			if !in.equal(l.In[i]) || !out.equal(l.Out[i]) {
+				l.In[i], l.Out[i] = in, out
+				changed = true
+			}
+		}
+	}
+	return l
+}
+
+// exits ブロックの終わりからProgramの終わりへ進むことがあるか
+func (g *Graph) exits(b *Block) bool {
+	last := b.Insts[len(b.Insts)-1]
+	dsts, falls := branches(last)
+	if falls {
+		dsts = append(dsts, last.Next())
+	}
+	return contains(dsts, len(g.Program))
+}
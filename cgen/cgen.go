@@ -0,0 +1,328 @@
+package cgen
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/x0y14/gvm"
+)
+
+// Options 生成するCの関数名
+type Options struct {
+	Func string
+}
+
+// conditions 条件付きジャンプの条件をCの式にしたもの. 大小を見る条件は順序なしでは飛ばない
+var conditions = map[gvm.Opcode]string{
+	gvm.JE:  "!gvm_unordered(s) && s->zf",
+	gvm.JNE: "!s->zf || gvm_unordered(s)",
+	gvm.JL:  "!gvm_unordered(s) && s->sf != s->of",
+	gvm.JLE: "!gvm_unordered(s) && (s->zf || s->sf != s->of)",
+	gvm.JG:  "!gvm_unordered(s) && !s->zf && s->sf == s->of",
+	gvm.JGE: "!gvm_unordered(s) && s->sf == s->of",
+	gvm.JB:  "!gvm_unordered(s) && s->cf",
+	gvm.JBE: "!gvm_unordered(s) && (s->cf || s->zf)",
+	gvm.JA:  "!gvm_unordered(s) && !s->cf && !s->zf",
+	gvm.JAE: "!gvm_unordered(s) && !s->cf",
+	gvm.JO:  "s->of",
+	gvm.JNO: "!s->of",
+	gvm.JS:  "s->sf",
+	gvm.JNS: "!s->sf",
+}
+
+type generator struct {
+	buf    bytes.Buffer
+	indent int
+}
+
+func (g *generator) line(format string, args ...any) {
+	g.buf.WriteString(strings.Repeat("\t", g.indent))
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteString("\n")
+}
+
+// Generate Programと同じ動きをするC99のファイルを書き出す.
+//
+//	int Func(gvm_state *s);
+//
+// gvm_stateはスタック, ヒープの配列(ConfigのStackSize, HeapSizeの大きさ)とレジスタを持つ.
+// Funcはsを初期化して実行し, 終わったら0を, エラーなら-1を返してs->errにメッセージを,
+// s->faultにTRYで捕捉できるフォールトならその種類(gvm.Fault*と同じ値)を書く.
+// エラーのメッセージと止まったときのPCはgvm.Runtime.Runと同じ. スタックあふれやヒープの確保失敗もエラーになる.
+// 対応する命令とオペランドはgogenと同じ.
+func Generate(program gvm.Program, config *gvm.Config, opts Options) ([]byte, error) {
+	if err := gvm.Verify(program); err != nil {
+		return nil, err
+	}
+	insts := gvm.Instructions(program)
+
+	registers := config.Registers
+	if registers <= 0 {
+		registers = gvm.DefaultRegisters
+	}
+	// 飛び込まれる位置. 関数の戻り先も含む
+	labels := map[int]bool{0: true, len(program): true}
+	for _, inst := range insts {
+		switch inst.Op {
+		case gvm.NOP, gvm.MOV, gvm.PUSH, gvm.POP, gvm.ALLOC, gvm.STORE, gvm.LOAD, gvm.RET,
+			gvm.ADD, gvm.SUB, gvm.MUL, gvm.DIV, gvm.CMP, gvm.EQ, gvm.NE, gvm.LT, gvm.LE:
+		case gvm.JMP, gvm.CALL:
+			labels[gvm.Target(inst.At, inst.Operands[0])] = true
+			labels[inst.Next()] = true
+		default:
+			if _, ok := conditions[inst.Op]; !ok {
+				return nil, fmt.Errorf("cgen: %w", inst.Errorf("unsupported opcode: %s", inst.Op))
+			}
+			labels[gvm.Target(inst.At, inst.Operands[0])] = true
+		}
+		for _, w := range inst.Operands {
+			switch w := w.(type) {
+			case gvm.GeneralPurposeRegister:
+				if registers <= int(w) {
+					return nil, fmt.Errorf("cgen: %w", inst.Errorf("unknown register: %s", w))
+				}
+			case gvm.Register:
+				return nil, fmt.Errorf("cgen: %w", inst.Errorf("unsupported operand: %s", w))
+			}
+		}
+	}
+
+	g := &generator{}
+	fmt.Fprintf(&g.buf, prelude, config.StackSize, config.HeapSize, registers,
+		gvm.FaultDivisionByZero, gvm.FaultOutOfBounds, gvm.FaultTypeMismatch)
+	g.line("")
+	g.line("int %s(gvm_state *s) {", opts.Func)
+	g.indent++
+	g.line("gvm_reset(s);")
+	g.line("for (;;) {")
+	g.indent++
+	g.line("switch (s->pc) {")
+	terminal := false
+	for _, inst := range insts {
+		if labels[inst.At] {
+			if inst.At != 0 && !terminal {
+				g.line("\t/* fallthrough */")
+			}
+			g.line("case %d:", inst.At)
+		}
+		g.indent++
+		g.line("/* %d: %s */", inst.At, cComment(inst.String()))
+		terminal = g.insn(inst)
+		g.indent--
+	}
+	if !terminal && len(insts) > 0 {
+		g.line("\t/* fallthrough */")
+	}
+	g.line("case %d:", len(program))
+	g.line("\ts->pc = %d;", len(program))
+	g.line("\treturn 0;")
+	// Programの終わりより先へ戻ったら止まる
+	g.line("default:")
+	g.line("\tif (%d < s->pc) {", len(program))
+	g.line("\t\treturn 0;")
+	g.line("\t}")
+	g.line("\treturn gvm_fail(s, s->pc, 0, \"invalid program address: %%\" PRId64, s->pc);")
+	g.line("}")
+	g.indent--
+	g.line("}")
+	g.indent--
+	g.line("}")
+	return g.buf.Bytes(), nil
+}
+
+// cComment コメントを閉じてしまわないようにする
+func cComment(s string) string {
+	return strings.ReplaceAll(s, "*/", "* /")
+}
+
+// cString Cの文字列リテラル. ASCIIの表示できる文字以外は8進数にする
+func cString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 0x20 || 0x7f <= c:
+			fmt.Fprintf(&sb, "\\%03o", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// literal 即値とアドレスのCの式
+func literal(w gvm.Word) string {
+	switch w := w.(type) {
+	case gvm.Integer:
+		if w == math.MinInt64 {
+			return "gvm_make(GVM_INTEGER, INT64_MIN)"
+		}
+		return fmt.Sprintf("gvm_make(GVM_INTEGER, %d)", w)
+	case gvm.Float:
+		f := float64(w)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Sprintf("gvm_float_bits(%#xu)", math.Float64bits(f))
+		}
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return fmt.Sprintf("gvm_float(%s)", s)
+	case gvm.Char:
+		return fmt.Sprintf("gvm_make(GVM_CHAR, %d)", w)
+	case gvm.Bool:
+		return fmt.Sprintf("gvm_make(GVM_BOOL, %d)", w.Value())
+	case gvm.ProgramAddress:
+		return fmt.Sprintf("gvm_make(GVM_PROGRAM_ADDRESS, %d)", w)
+	case gvm.HeapAddress:
+		return fmt.Sprintf("gvm_make(GVM_HEAP_ADDRESS, %d)", w)
+	default:
+		panic(fmt.Sprintf("cgen: unsupported literal: %s", w))
+	}
+}
+
+// index スタック上の位置(添字)の式
+func index(w gvm.Word) string {
+	switch w := w.(type) {
+	case gvm.BpOffset:
+		return fmt.Sprintf("s->bp%+d", w)
+	case gvm.SpOffset:
+		return fmt.Sprintf("s->sp%+d", w)
+	default:
+		panic(fmt.Sprintf("cgen: unsupported offset: %s", w))
+	}
+}
+
+// value オペランドの値の式. オフセットの範囲はgvm_slotで確かめておく
+func value(w gvm.Word) string {
+	switch w := w.(type) {
+	case gvm.GeneralPurposeRegister:
+		return fmt.Sprintf("s->r[%d]", w)
+	case gvm.Offset:
+		return "s->stack[" + index(w) + "]"
+	default:
+		return literal(w)
+	}
+}
+
+// address ALLOCの大きさ, LOAD, STOREのアドレスの式
+func address(w gvm.Word) string {
+	if _, ok := w.(gvm.Register); ok {
+		return "gvm_value_of(" + value(w) + ")"
+	}
+	return strconv.Itoa(w.(gvm.Operand).Value())
+}
+
+// opName 生成するCでの演算の定数名
+func opName(op gvm.Opcode) string {
+	return "GVM_" + strings.ToUpper(op.String())
+}
+
+// insn 命令1つ分のコードを書く. 次の命令へ進まないならtrue
+func (g *generator) insn(inst gvm.Instruction) bool {
+	ops := inst.Operands
+	// オペランドを使う前にRuntimeと同じ順で確かめる. レジスタはstockならnilでないことも確かめる
+	check := func(w gvm.Word, stock bool) {
+		switch w.(type) {
+		case gvm.Offset:
+			g.line("if (gvm_slot(s, %s, %s, %d)) {", index(w), cString(w.String()), inst.Next())
+		case gvm.Register:
+			if !stock {
+				return
+			}
+			g.line("if (gvm_stockable(s, %s, %s, %s, %d)) {", value(w), cString(inst.Op.String()), cString(w.String()), inst.Next())
+		default:
+			return
+		}
+		g.line("\treturn -1;")
+		g.line("}")
+	}
+	switch op := inst.Op; op {
+	case gvm.NOP:
+	case gvm.MOV:
+		if _, ok := ops[0].(gvm.Register); ok {
+			check(ops[1], false)
+		} else {
+			check(ops[0], false)
+			check(ops[1], true)
+		}
+		g.line("%s = %s;", value(ops[0]), value(ops[1]))
+	case gvm.PUSH:
+		check(ops[0], true)
+		g.line("if (gvm_push(s, %s, %d)) {", value(ops[0]), inst.Next())
+		g.line("\treturn -1;")
+		g.line("}")
+	case gvm.POP:
+		g.line("if (gvm_pop(s, &%s, %d)) {", value(ops[0]), inst.Next())
+		g.line("\treturn -1;")
+		g.line("}")
+	case gvm.ALLOC:
+		check(ops[0], true)
+		g.line("if (gvm_alloc(s, %s, %d)) {", address(ops[0]), inst.Next())
+		g.line("\treturn -1;")
+		g.line("}")
+	case gvm.LOAD:
+		check(ops[1], true)
+		g.line("{")
+		g.line("\tint64_t at = %s;", address(ops[1]))
+		g.line("\tif (!gvm_heap(at)) {")
+		g.line("\t\treturn gvm_fail(s, %d, GVM_FAULT_OUT_OF_BOUNDS, \"heap: %s: %%\" PRId64, at);", inst.Next(), gvm.ErrOutOfBounds)
+		g.line("\t}")
+		g.line("\t%s = s->heap[at];", value(ops[0]))
+		g.line("}")
+	case gvm.STORE:
+		check(ops[0], true)
+		g.line("{")
+		g.line("\tint64_t at = %s;", address(ops[0]))
+		g.line("\tif (!gvm_heap(at)) {")
+		g.line("\t\treturn gvm_fail(s, %d, GVM_FAULT_OUT_OF_BOUNDS, \"heap: %s: %%\" PRId64, at);", inst.Next(), gvm.ErrOutOfBounds)
+		g.line("\t}")
+		g.indent++
+		check(ops[1], true)
+		g.indent--
+		g.line("\ts->heap[at] = %s;", value(ops[1]))
+		g.line("}")
+	case gvm.ADD, gvm.SUB, gvm.MUL, gvm.DIV:
+		g.line("if (gvm_arith(s, %s, &%s, %s, %s, %d)) {", opName(op), value(ops[0]), value(ops[1]), cString(ops[0].String()), inst.Next())
+		g.line("\treturn -1;")
+		g.line("}")
+	case gvm.CMP:
+		g.line("if (gvm_cmp(s, %s, %s, %s, %s, %d)) {", value(ops[0]), value(ops[1]), cString(ops[0].String()), cString(ops[1].String()), inst.Next())
+		g.line("\treturn -1;")
+		g.line("}")
+	case gvm.EQ, gvm.NE, gvm.LT, gvm.LE:
+		g.line("if (gvm_compare(s, %s, %s, %s, %s, %s, %d)) {", opName(op), value(ops[0]), value(ops[1]), cString(ops[0].String()), cString(ops[1].String()), inst.Next())
+		g.line("\treturn -1;")
+		g.line("}")
+	case gvm.JMP:
+		g.line("s->pc = %d;", gvm.Target(inst.At, ops[0]))
+		g.line("continue;")
+		return true
+	case gvm.CALL:
+		g.line("if (gvm_call(s, %d, %d)) {", inst.Next(), inst.At)
+		g.line("\treturn -1;")
+		g.line("}")
+		g.line("s->pc = %d;", gvm.Target(inst.At, ops[0]))
+		g.line("continue;")
+		return true
+	case gvm.RET:
+		g.line("if (gvm_ret(s, %d)) {", inst.At)
+		g.line("\treturn -1;")
+		g.line("}")
+		g.line("continue;")
+		return true
+	default:
+		g.line("if (%s) {", conditions[op])
+		g.line("\ts->pc = %d;", gvm.Target(inst.At, ops[0]))
+		g.line("\tcontinue;")
+		g.line("}")
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package cgen
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/x0y14/gvm"
+	"github.com/x0y14/gvm/internal/sample"
+)
+
+// programs 生成したCとインタプリタで結果を比べるProgram. 共有のものに, Cでの値の表現とフォールトを確かめるものを足す
+var programs = slices.Concat(sample.Integers, sample.Floats, sample.Faults, []sample.Program{
+	{Name: "overflow", Src: `
+mov r1, -9223372036854775807
+sub r1, 1
+mov r2, r1
+div r2, -1
+mov r3, r1
+mul r3, -1
+mov r0, 4611686018427387904
+mul r0, 2
+`},
+	{Name: "floats", Src: `
+mov r0, 1000000.0
+mov r1, 0.0001
+div r1, 10.0
+mov r2, 123456789.0
+mov r3, 0.0
+div r3, 0.0
+mov r4, -1.0
+div r4, 0.0
+mov r5, 0.0
+mul r5, -1.0
+`},
+	{Name: "nan", Src: "mov r1, 0.0\ndiv r1, 0.0\ncmp r1, r1\nmov r2, 1.0\nadd r2, r1\nmov r3, 1\nadd r3, r1"},
+	{Name: "chars", Direct: gvm.Program{
+		gvm.MOV, gvm.R1, gvm.Char('a'),
+		gvm.MOV, gvm.R2, gvm.Char('\n'),
+		gvm.MOV, gvm.R3, gvm.Char('あ'),
+		gvm.MOV, gvm.R0, gvm.Char(0x85),
+		gvm.LT, gvm.R1, gvm.Char('b'),
+		gvm.ADD, gvm.R1, gvm.R3,
+	}},
+	{Name: "bool", Direct: gvm.Program{gvm.MOV, gvm.R1, gvm.Bool(true), gvm.EQ, gvm.R1, gvm.Bool(true)}},
+	{Name: "compare typemismatch", Src: "alloc 1\npop r1\nmov r2, r1\neq r1, r2"},
+	{Name: "nil", Src: "mov r2, 1\nadd r2, r1"},
+	{Name: "store out of bounds", Src: "mov r1, -1\nstore r1, 1"},
+})
+
+// mainSrc 生成したファイルの後ろに付けて, 終わったときのレジスタとエラー, フォールトの種類を書く
+const mainSrc = `
+static const char *gvm_bool(int b) {
+	return b ? "true" : "false";
+}
+
+int main(void) {
+	static gvm_state s;
+	char buf[64];
+	int i, err = Run(&s);
+	printf("@%" PRId64 " @%" PRId64 " @%" PRId64 " @%" PRId64 " ", s.pc, s.bp, s.sp, s.hp);
+	printf("%s %s %s %s ", gvm_bool(s.zf), gvm_bool(s.sf), gvm_bool(s.cf), gvm_bool(s.of));
+	for (i = 0; i < GVM_REGISTERS; i++) {
+		gvm_format(buf, sizeof buf, s.r[i]);
+		printf("%s ", buf);
+	}
+	printf("%s\n%" PRId64 "\n", err ? s.err : "<nil>", s.fault);
+	return 0;
+}
+`
+
+// fault Runtimeのエラーの種類. TRYで捕捉したときにR1へ入る値で, フォールトでなければ0
+func fault(err error) gvm.Integer {
+	switch {
+	case errors.Is(err, gvm.ErrDivisionByZero):
+		return gvm.FaultDivisionByZero
+	case errors.Is(err, gvm.ErrOutOfBounds):
+		return gvm.FaultOutOfBounds
+	case errors.Is(err, gvm.ErrTypeMismatch):
+		return gvm.FaultTypeMismatch
+	default:
+		return 0
+	}
+}
+
+// build 生成したCをコンパイルし, 実行ファイルのパスを返す
+func build(t *testing.T, program gvm.Program) string {
+	t.Helper()
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("cc not found")
+	}
+	src, err := Generate(program, sample.Config, Options{Func: "Run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.c")
+	if err := os.WriteFile(path, append(src, mainSrc...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, "main")
+	out, err := exec.Command(cc, "-std=c99", "-pedantic", "-Wall", "-Wextra", "-Werror", "-O2", "-o", bin, path, "-lm").CombinedOutput()
+	if err != nil {
+		t.Fatalf("cc: %v\n%s", err, out)
+	}
+	return bin
+}
+
+func TestGenerate_Run(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated code")
+	}
+	for _, p := range programs {
+		t.Run(p.Name, func(t *testing.T) {
+			t.Parallel()
+			program := p.Compile(t)
+			bin := build(t, program)
+
+			r := gvm.NewRuntime(program, sample.Config)
+			err := r.Run()
+			var expect strings.Builder
+			for _, reg := range sample.Order {
+				fmt.Fprint(&expect, r.Register(reg), " ")
+			}
+			fmt.Fprintln(&expect, err)
+			fmt.Fprintln(&expect, fault(err))
+
+			out, err := exec.Command(bin).CombinedOutput()
+			if err != nil {
+				t.Fatalf("%v\n%s", err, out)
+			}
+			if diff := cmp.Diff(expect.String(), string(out)); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+// TestGenerate testdata/<name>.cと比べる
+func TestGenerate(t *testing.T) {
+	for _, name := range []string{"loop", "heap", "fib"} {
+		t.Run(name, func(t *testing.T) {
+			src, err := Generate(sample.Find(t, programs, name).Compile(t), sample.Config, Options{Func: "Run"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", name+".c")
+			if *sample.Update {
+				if err := os.WriteFile(golden, src, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expect, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(expect), string(src)); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestGenerate_Error(t *testing.T) {
+	tests := []struct {
+		name    string
+		program gvm.Program
+		expect  string
+	}{
+		{"opcode", gvm.Program{gvm.NOP, gvm.YIELD}, "cgen: 1: unsupported opcode: yield"},
+		{"special register", gvm.Program{gvm.MOV, gvm.R1, gvm.SP}, "cgen: 0: unsupported operand: sp"},
+		{"flag register", gvm.Program{gvm.MOV, gvm.ZF, gvm.Bool(true)}, "cgen: 0: unsupported operand: zf"},
+		{"register", gvm.Program{gvm.MOV, gvm.GeneralPurposeRegister(6), gvm.Integer(1)}, "cgen: 0: unknown register: r6"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Generate(tt.program, sample.Config, Options{Func: "Run"})
+			if err == nil || err.Error() != tt.expect {
+				t.Errorf("want=%s, got=%v", tt.expect, err)
+			}
+		})
+	}
+	if _, err := Generate(gvm.Program{gvm.MOV, gvm.R1}, sample.Config, Options{Func: "Run"}); err == nil {
+		t.Error("want verify error")
+	}
+}
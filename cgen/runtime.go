@@ -0,0 +1,453 @@
+package cgen
+
+// prelude 生成するCの先頭に置く値の表現と実行時の関数.
+// %[1]d: スタックの大きさ, %[2]d: ヒープの大きさ, %[3]d: 汎用レジスタの数,
+// %[4]d~%[6]d: gvm.FaultDivisionByZero, FaultOutOfBounds, FaultTypeMismatch
+const prelude = `/* Code generated by cgen. DO NOT EDIT. */
+
+#include <inttypes.h>
+#include <math.h>
+#include <stdarg.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#define GVM_STACK_SIZE %[1]d
+#define GVM_HEAP_SIZE %[2]d
+#define GVM_REGISTERS %[3]d
+
+/* 捕捉できるフォールトの種類. gvm.Fault*と同じ値 */
+#define GVM_FAULT_DIVISION_BY_ZERO %[4]d
+#define GVM_FAULT_OUT_OF_BOUNDS %[5]d
+#define GVM_FAULT_TYPE_MISMATCH %[6]d
+
+/* GVM_PANIC Goのpanicにあたる. 既定ではstderrに書いてabortする */
+#ifndef GVM_PANIC
+#define GVM_PANIC(msg) fprintf(stderr, "panic: %%s\n", (msg))
+#endif
+
+typedef enum {
+	GVM_NIL,
+	GVM_INTEGER,
+	GVM_FLOAT,
+	GVM_CHAR,
+	GVM_BOOL,
+	GVM_PROGRAM_ADDRESS,
+	GVM_HEAP_ADDRESS,
+	GVM_BASE_POINTER
+} gvm_tag;
+
+typedef struct {
+	gvm_tag tag;
+	union {
+		int64_t i;
+		double f;
+	} as;
+} gvm_value;
+
+typedef struct {
+	gvm_value stack[GVM_STACK_SIZE];
+	gvm_value heap[GVM_HEAP_SIZE];
+	gvm_value r[GVM_REGISTERS];
+	int zf, sf, cf, of;
+	int64_t pc, bp, sp, hp;
+	char err[128];
+	int64_t fault; /* エラーがフォールトならGVM_FAULT_*, それ以外は0 */
+} gvm_state;
+
+enum { GVM_ADD, GVM_SUB, GVM_MUL, GVM_DIV, GVM_EQ, GVM_NE, GVM_LT, GVM_LE };
+
+static const char *const gvm_operators[] = {"+", "-", "*", "/", "==", "!=", "<", "<="};
+static const char *const gvm_names[] = {"add", "sub", "mul", "div"};
+
+static inline gvm_value gvm_make(gvm_tag tag, int64_t i) {
+	gvm_value v;
+	v.tag = tag;
+	v.as.i = i;
+	return v;
+}
+
+static inline gvm_value gvm_float(double f) {
+	gvm_value v;
+	v.tag = GVM_FLOAT;
+	v.as.f = f;
+	return v;
+}
+
+/* gvm_float_bits NaNと無限大の定数 */
+static inline gvm_value gvm_float_bits(uint64_t bits) {
+	double f;
+	memcpy(&f, &bits, sizeof f);
+	return gvm_float(f);
+}
+
+static inline void gvm_panic(const char *msg) {
+	GVM_PANIC(msg);
+	abort();
+}
+
+/* gvm_unordered NaNとの比較の結果か. gvmと同じくZFとSFが同時に立っていれば順序なし.
+   gvm_compareは他のフラグを下ろすので, 前の演算のSFは残らない */
+static inline int gvm_unordered(const gvm_state *s) {
+	return s->zf && s->sf;
+}
+
+/* gvm_immediate Integer, Float, Char, Bool */
+static inline int gvm_immediate(gvm_value v) {
+	return v.tag == GVM_INTEGER || v.tag == GVM_FLOAT || v.tag == GVM_CHAR || v.tag == GVM_BOOL;
+}
+
+/* gvm_value_of Operand.Value */
+static inline int64_t gvm_value_of(gvm_value v) {
+	switch (v.tag) {
+	case GVM_NIL:
+		gvm_panic("value: int of nil");
+		return 0;
+	case GVM_FLOAT:
+		if (!(-9223372036854775808.0 <= v.as.f && v.as.f < 9223372036854775808.0)) {
+			return INT64_MIN;
+		}
+		return (int64_t)v.as.f;
+	default:
+		return v.as.i;
+	}
+}
+
+static inline void gvm_format_float(char *buf, size_t n, double f) {
+	char e[32];
+	int p, x;
+	if (isnan(f)) {
+		snprintf(buf, n, "NaN");
+		return;
+	}
+	if (isinf(f)) {
+		snprintf(buf, n, f > 0 ? "+Inf" : "-Inf");
+		return;
+	}
+	/* 元に戻る最短の桁数. strconv.FormatFloat(f, 'g', -1, 64)と同じ表記にする */
+	for (p = 1; p < 17; p++) {
+		snprintf(e, sizeof e, "%%.*e", p - 1, f);
+		if (strtod(e, NULL) == f) {
+			break;
+		}
+	}
+	snprintf(e, sizeof e, "%%.*e", p - 1, f);
+	x = atoi(strchr(e, 'e') + 1);
+	if (x < -4 || 6 <= x) {
+		snprintf(buf, n, "%%s", e);
+	} else {
+		snprintf(buf, n, "%%.*f", p - 1 - x < 0 ? 0 : p - 1 - x, f);
+	}
+}
+
+/* gvm_format_char strconv.QuoteRune. U+00A0以上は表示できるものとしてUTF-8のまま書く */
+static inline void gvm_format_char(char *buf, size_t n, int64_t v) {
+	static const char escapes[] = "\a\b\f\n\r\t\v";
+	static const char letters[] = "abfnrtv";
+	int32_t c = (int32_t)(uint32_t)v; /* runeへの変換 */
+	const char *esc = c != 0 ? strchr(escapes, (int)c) : NULL;
+	if (c == '\'' || c == '\\') {
+		snprintf(buf, n, "'\\%%c'", (int)c);
+	} else if (esc != NULL && 0 < c && c < 0x80) {
+		snprintf(buf, n, "'\\%%c'", letters[esc - escapes]);
+	} else if (0x20 <= c && c < 0x7f) {
+		snprintf(buf, n, "'%%c'", (int)c);
+	} else if (c < 0 || 0x10ffff < c || (0xd800 <= c && c < 0xe000)) {
+		snprintf(buf, n, "'\xef\xbf\xbd'");
+	} else if (c < 0x80) {
+		snprintf(buf, n, "'\\x%%02x'", (int)c);
+	} else if (c < 0xa0) {
+		snprintf(buf, n, "'\\u%%04x'", (int)c);
+	} else if (c < 0x800) {
+		snprintf(buf, n, "'%%c%%c'", (int)(0xc0 | c >> 6), (int)(0x80 | (c & 0x3f)));
+	} else if (c < 0x10000) {
+		snprintf(buf, n, "'%%c%%c%%c'", (int)(0xe0 | c >> 12), (int)(0x80 | (c >> 6 & 0x3f)), (int)(0x80 | (c & 0x3f)));
+	} else {
+		snprintf(buf, n, "'%%c%%c%%c%%c'", (int)(0xf0 | c >> 18), (int)(0x80 | (c >> 12 & 0x3f)),
+			(int)(0x80 | (c >> 6 & 0x3f)), (int)(0x80 | (c & 0x3f)));
+	}
+}
+
+/* gvm_format fmtの%%vと同じ表記 */
+static inline void gvm_format(char *buf, size_t n, gvm_value v) {
+	switch (v.tag) {
+	case GVM_NIL:
+		snprintf(buf, n, "<nil>");
+		break;
+	case GVM_INTEGER:
+		snprintf(buf, n, "%%" PRId64, v.as.i);
+		break;
+	case GVM_FLOAT:
+		gvm_format_float(buf, n, v.as.f);
+		break;
+	case GVM_CHAR:
+		gvm_format_char(buf, n, v.as.i);
+		break;
+	case GVM_BOOL:
+		snprintf(buf, n, v.as.i ? "true" : "false");
+		break;
+	default:
+		snprintf(buf, n, "@%%" PRId64, v.as.i);
+		break;
+	}
+}
+
+/* gvm_fail エラーで止まる. pcは止まったときのPC, faultはフォールトの種類(なければ0) */
+static inline int gvm_fail(gvm_state *s, int64_t pc, int64_t fault, const char *format, ...) {
+	va_list args;
+	va_start(args, format);
+	vsnprintf(s->err, sizeof s->err, format, args);
+	va_end(args);
+	s->pc = pc;
+	s->fault = fault;
+	return -1;
+}
+
+/* gvm_slot BP, SPからのオフセットがスタックの中か. atは位置, nameはオフセットの表記 */
+static inline int gvm_slot(gvm_state *s, int64_t at, const char *name, int64_t next) {
+	if (at < 0 || GVM_STACK_SIZE <= at) {
+		return gvm_fail(s, next, GVM_FAULT_OUT_OF_BOUNDS, "stack: memory access out of bounds: %%s, at=%%" PRId64, name, at);
+	}
+	return 0;
+}
+
+/* gvm_stockable レジスタの値をスタック, ヒープに置けるか. ALLOC, LOAD, STOREのアドレスにも使う.
+   汎用レジスタに入るのはnilか置ける値だけなので, nilでないことを確かめればよい */
+static inline int gvm_stockable(gvm_state *s, gvm_value v, const char *op, const char *name, int64_t next) {
+	if (v.tag == GVM_NIL) {
+		return gvm_fail(s, next, GVM_FAULT_TYPE_MISMATCH, "typemismatch: %%s %%s: <nil>", op, name);
+	}
+	return 0;
+}
+
+static inline int gvm_push(gvm_state *s, gvm_value v, int64_t next) {
+	if (s->sp <= 0) {
+		return gvm_fail(s, next, GVM_FAULT_OUT_OF_BOUNDS, "stack: memory access out of bounds: overflow");
+	}
+	s->sp--;
+	s->stack[s->sp] = v;
+	return 0;
+}
+
+/* gvm_take SPの位置の値を取り出して空にする. スタックの外なら0 */
+static inline int gvm_take(gvm_state *s, gvm_value *v) {
+	if (s->sp < 0 || GVM_STACK_SIZE <= s->sp) {
+		return 0;
+	}
+	*v = s->stack[s->sp];
+	s->stack[s->sp] = gvm_make(GVM_NIL, 0);
+	s->sp++;
+	return 1;
+}
+
+static inline int gvm_pop(gvm_state *s, gvm_value *dst, int64_t next) {
+	if (!gvm_take(s, dst)) {
+		return gvm_fail(s, next, GVM_FAULT_OUT_OF_BOUNDS, "stack: memory access out of bounds: underflow");
+	}
+	return 0;
+}
+
+static inline int gvm_alloc(gvm_state *s, int64_t size, int64_t next) {
+	if (GVM_HEAP_SIZE <= s->hp + size) {
+		return gvm_fail(s, next, GVM_FAULT_OUT_OF_BOUNDS, "heap: memory access out of bounds: out of memory");
+	}
+	if (gvm_push(s, gvm_make(GVM_HEAP_ADDRESS, s->hp), next)) {
+		return -1;
+	}
+	s->hp += size;
+	return 0;
+}
+
+/* gvm_call 戻り先とBPを積んで, BPを新しいフレームの先頭にする. atはCALLの位置 */
+static inline int gvm_call(gvm_state *s, int64_t ret, int64_t at) {
+	if (s->sp < 2) {
+		return gvm_fail(s, at, GVM_FAULT_OUT_OF_BOUNDS, "stack: memory access out of bounds: overflow");
+	}
+	s->stack[s->sp - 1] = gvm_make(GVM_PROGRAM_ADDRESS, ret);
+	s->stack[s->sp - 2] = gvm_make(GVM_BASE_POINTER, s->bp);
+	s->sp -= 2;
+	s->bp = s->sp;
+	return 0;
+}
+
+/* gvm_heap ヒープの範囲内か */
+static inline int gvm_heap(int64_t at) {
+	return 0 <= at && at < GVM_HEAP_SIZE;
+}
+
+static inline void gvm_int_flags(gvm_state *s, int op, int64_t x, int64_t y, int64_t z) {
+	s->zf = z == 0;
+	s->sf = z < 0;
+	s->cf = 0;
+	s->of = 0;
+	switch (op) {
+	case GVM_ADD:
+		s->cf = (uint64_t)z < (uint64_t)x;
+		s->of = (x < 0) == (y < 0) && (z < 0) != (x < 0);
+		break;
+	case GVM_SUB:
+		s->cf = (uint64_t)x < (uint64_t)y;
+		s->of = (x < 0) != (y < 0) && (z < 0) != (x < 0);
+		break;
+	case GVM_MUL:
+		s->cf = x != 0 && (x == -1 ? y == INT64_MIN : z / x != y);
+		s->of = s->cf;
+		break;
+	case GVM_DIV:
+		s->of = x == INT64_MIN && y == -1;
+		break;
+	}
+}
+
+/* gvm_arith ADD, SUB, MUL, DIV dst, src. yはsrcの値, nameはdstの表記 */
+static inline int gvm_arith(gvm_state *s, int op, gvm_value *dst, gvm_value y, const char *name, int64_t next) {
+	char buf[64];
+	gvm_value x = *dst;
+	if (!gvm_immediate(x) || !gvm_immediate(y) || x.tag != y.tag) {
+		gvm_format(buf, sizeof buf, y);
+		return gvm_fail(s, next, GVM_FAULT_TYPE_MISMATCH, "typemismatch: %%s %%s %%s", name, gvm_operators[op], buf);
+	}
+	if (x.tag == GVM_FLOAT) {
+		double z = 0;
+		switch (op) {
+		case GVM_ADD:
+			z = x.as.f + y.as.f;
+			break;
+		case GVM_SUB:
+			z = x.as.f - y.as.f;
+			break;
+		case GVM_MUL:
+			z = x.as.f * y.as.f;
+			break;
+		case GVM_DIV:
+			z = x.as.f / y.as.f;
+			break;
+		}
+		*dst = gvm_float(z);
+		s->zf = z == 0;
+		s->sf = z < 0;
+		s->cf = 0;
+		s->of = 0;
+		return 0;
+	}
+	if (x.tag != GVM_INTEGER) {
+		return gvm_fail(s, next, GVM_FAULT_TYPE_MISMATCH, "typemismatch: invalid %%s value: %%s", gvm_names[op], name);
+	}
+	{
+		/* 桁あふれはGoと同じく2の補数で折り返す */
+		uint64_t a = (uint64_t)x.as.i, b = (uint64_t)y.as.i, c = 0;
+		int64_t z;
+		switch (op) {
+		case GVM_ADD:
+			c = a + b;
+			break;
+		case GVM_SUB:
+			c = a - b;
+			break;
+		case GVM_MUL:
+			c = a * b;
+			break;
+		case GVM_DIV:
+			if (y.as.i == 0) {
+				gvm_format(buf, sizeof buf, y);
+				return gvm_fail(s, next, GVM_FAULT_DIVISION_BY_ZERO, "division by zero: %%s / %%s", name, buf);
+			}
+			c = x.as.i == INT64_MIN && y.as.i == -1 ? a : (uint64_t)(x.as.i / y.as.i);
+			break;
+		}
+		memcpy(&z, &c, sizeof z);
+		*dst = gvm_make(GVM_INTEGER, z);
+		gvm_int_flags(s, op, x.as.i, y.as.i, z);
+	}
+	return 0;
+}
+
+/* gvm_cmp CMP o1, o2. n1, n2はオペランドの表記 */
+static inline int gvm_cmp(gvm_state *s, gvm_value x, gvm_value y, const char *n1, const char *n2, int64_t next) {
+	if (!gvm_immediate(x) || !gvm_immediate(y) || x.tag != y.tag) {
+		return gvm_fail(s, next, GVM_FAULT_TYPE_MISMATCH, "typemismatch: cmp %%s, %%s", n1, n2);
+	}
+	if (x.tag == GVM_FLOAT) {
+		if (isnan(x.as.f) || isnan(y.as.f)) {
+			s->zf = 1;
+			s->sf = 1;
+			s->cf = 1;
+			s->of = 1;
+			return 0;
+		}
+		s->zf = x.as.f == y.as.f;
+		s->sf = x.as.f < y.as.f;
+		s->cf = s->sf;
+		s->of = 0;
+		return 0;
+	}
+	{
+		uint64_t c = (uint64_t)x.as.i - (uint64_t)y.as.i;
+		int64_t z;
+		memcpy(&z, &c, sizeof z);
+		gvm_int_flags(s, GVM_SUB, x.as.i, y.as.i, z);
+	}
+	return 0;
+}
+
+/* gvm_compare EQ, NE, LT, LE o1, o2. 結果はZFに入り, SF, CF, OFは下ろす */
+static inline int gvm_compare(gvm_state *s, int op, gvm_value x, gvm_value y, const char *n1, const char *n2, int64_t next) {
+	if (!gvm_immediate(x) || !gvm_immediate(y) || x.tag != y.tag) {
+		return gvm_fail(s, next, GVM_FAULT_TYPE_MISMATCH, "typemismatch: %%s %%s %%s", n1, gvm_operators[op], n2);
+	}
+	s->sf = s->cf = s->of = 0;
+	if (x.tag == GVM_FLOAT) {
+		switch (op) {
+		case GVM_EQ:
+			s->zf = x.as.f == y.as.f;
+			break;
+		case GVM_NE:
+			s->zf = x.as.f != y.as.f;
+			break;
+		case GVM_LT:
+			s->zf = x.as.f < y.as.f;
+			break;
+		default:
+			s->zf = x.as.f <= y.as.f;
+			break;
+		}
+		return 0;
+	}
+	switch (op) {
+	case GVM_EQ:
+		s->zf = x.as.i == y.as.i;
+		break;
+	case GVM_NE:
+		s->zf = x.as.i != y.as.i;
+		break;
+	case GVM_LT:
+		s->zf = x.as.i < y.as.i;
+		break;
+	default:
+		s->zf = x.as.i <= y.as.i;
+		break;
+	}
+	return 0;
+}
+
+/* gvm_ret RETでフレームを戻す. atはRETの位置 */
+static inline int gvm_ret(gvm_state *s, int64_t at) {
+	gvm_value prev, ret;
+	s->sp = s->bp;
+	if (!gvm_take(s, &prev) || prev.tag != GVM_BASE_POINTER) {
+		return gvm_fail(s, at, 0, "ret: broken frame: @%%" PRId64, s->bp);
+	}
+	if (!gvm_take(s, &ret) || ret.tag != GVM_PROGRAM_ADDRESS) {
+		return gvm_fail(s, at, 0, "ret: broken frame: @%%" PRId64, s->bp);
+	}
+	s->bp = prev.as.i;
+	s->pc = ret.as.i;
+	return 0;
+}
+
+static inline void gvm_reset(gvm_state *s) {
+	memset(s, 0, sizeof *s);
+	s->sp = GVM_STACK_SIZE - 1;
+}
+`
@@ -0,0 +1,134 @@
+package gvm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrClosedChannel 閉じたチャネルへのSEND, CLOSE
+var ErrClosedChannel = errors.New("closed channel")
+
+// Channel ヒープ上に置くチャネルの実体への参照. CHANで作る
+type Channel int
+
+func (c Channel) String() string { return fmt.Sprintf("chan#%d", c) }
+func (c Channel) Value() int     { return int(c) }
+func (c Channel) isOperand()     {}
+func (c Channel) isStockable()   {}
+
+// pending 受け取られるまで送り手を待たせている値
+type pending struct {
+	thread int
+	value  Stockable
+}
+
+type channel struct {
+	id     int
+	at     HeapAddress // Channelを置いたヒープ上の位置
+	cap    int
+	buf    []Stockable
+	sendq  []pending // バッファに入らなかった値. cap=0なら全て
+	closed bool
+}
+
+// ready RECVが待たずに終わるかどうか
+func (c *channel) ready() bool {
+	return len(c.buf) > 0 || len(c.sendq) > 0 || c.closed
+}
+
+// channel チャネルを指すオペランド(HeapAddressかそれを持つレジスタ)から実体を引く
+func (r *Runtime) channel(operand Operand) (*channel, error) {
+	addr, ok := r.value(operand).(HeapAddress)
+	if !ok || addr < 0 || len(r.heap) <= addr.Value() {
+		return nil, fmt.Errorf("chan: not a channel: %v", r.value(operand))
+	}
+	v := r.heap[addr]
+	id := int(v.bits)
+	if v.kind != kindChannel || id < 0 || len(r.channels) <= id {
+		return nil, fmt.Errorf("chan: not a channel: %v", v.operand())
+	}
+	return r.channels[id], nil
+}
+
+// makeChannel ヒープに1セル確保してChannelを置く
+func (r *Runtime) makeChannel(capacity Operand) (HeapAddress, error) {
+	n, ok := r.value(capacity).(Integer)
+	if !ok || n < 0 {
+		return 0, fmt.Errorf("chan: invalid capacity: %v", r.value(capacity))
+	}
+	at := r.hp()
+	if len(r.heap) <= at.Value() {
+		return 0, fmt.Errorf("heap: %w: out of memory", ErrOutOfBounds)
+	}
+	r.heap[at] = makeValue(kindChannel, len(r.channels))
+	r.channels = append(r.channels, &channel{id: len(r.channels), at: at, cap: int(n)})
+	r.setHP(at + 1)
+	return at, nil
+}
+
+// send バッファに空きがあれば入れて進む. なければ受け取られるまで送り手を止める
+func (r *Runtime) send(c *channel, v Stockable) error {
+	if c.closed {
+		return fmt.Errorf("send: %w: %v", ErrClosedChannel, c.at)
+	}
+	if len(c.buf) < c.cap && len(c.sendq) == 0 {
+		c.buf = append(c.buf, v)
+		return nil
+	}
+	r.ensureThreads()
+	c.sendq = append(c.sendq, pending{thread: r.current, value: v})
+	r.threads[r.current].wait = wait{op: SEND, target: c.id}
+	return r.schedule()
+}
+
+// recv 値があれば受け取る. 閉じていて空ならokはfalse. どちらでもなければ待つ
+func (r *Runtime) recv(c *channel) (v Stockable, ok bool, blocked bool) {
+	if r.threads != nil {
+		r.threads[r.current].wait = wait{}
+	}
+	switch {
+	case len(c.buf) > 0:
+		v, c.buf = c.buf[0], c.buf[1:]
+		if len(c.buf) == 0 {
+			c.buf = nil
+		}
+		if len(c.sendq) > 0 {
+			// 待っている送り手の値をバッファへ移す
+			c.buf = append(c.buf, c.sendq[0].value)
+			r.release(c)
+		}
+		return v, true, false
+	case len(c.sendq) > 0:
+		v = c.sendq[0].value
+		r.release(c)
+		return v, true, false
+	case c.closed:
+		return nil, false, false
+	}
+	r.ensureThreads()
+	r.threads[r.current].wait = wait{op: RECV, target: c.id}
+	return nil, false, true
+}
+
+// release sendqの先頭の送り手を再開できるようにする
+func (r *Runtime) release(c *channel) {
+	r.threads[c.sendq[0].thread].wait = wait{}
+	c.sendq = c.sendq[1:]
+	if len(c.sendq) == 0 {
+		c.sendq = nil
+	}
+}
+
+// closeChannel 閉じる. 待っている送り手は再開し, 送った値は受け取れるまま残る
+func (r *Runtime) closeChannel(c *channel) error {
+	if c.closed {
+		return fmt.Errorf("close: %w: %v", ErrClosedChannel, c.at)
+	}
+	c.closed = true
+	for _, p := range c.sendq {
+		r.threads[p.thread].wait = wait{}
+		c.buf = append(c.buf, p.value)
+	}
+	c.sendq = nil
+	return nil
+}
@@ -0,0 +1,119 @@
+package gvm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// pipeProgram producerが1, 2, 3を送って閉じ, mainが閉じられるまで受け取って合計する
+const pipeProgram = `
+    chan r2, %d
+    spawn producer, r2
+    mov r3, 0
+loop:
+    recv r4, r2
+    jne done
+    add r3, r4
+    jmp loop
+done:
+    mov r1, r3
+    jmp end
+producer:
+    mov r2, [bp+2]
+    mov r3, 1
+ploop:
+    send r2, r3
+    add r3, 1
+    le r3, 3
+    je ploop
+    close r2
+    ret
+end:`
+
+func pipeWith(capacity int) string {
+	return fmt.Sprintf(pipeProgram, capacity)
+}
+
+func TestRuntime_Channel(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect Operand
+	}{
+		{"unbuffered", pipeWith(0), Integer(6)},
+		{"buffered", pipeWith(2), Integer(6)},
+		{"single thread", "chan r2, 2\nsend r2, 1\nsend r2, 2.5\nrecv r3, r2\nrecv r1, r2", Float(2.5)},
+		{"closed", "chan r2, 1\nsend r2, 1\nclose r2\nrecv r3, r2\nrecv r1, r2\nmov r4, zf", nil},
+		{"recv with stale sf", "mov r1, 0\nsub r1, 5\nchan r2, 1\nsend r2, 1\nrecv r3, r2\nje ok\njmp end\nok:\nmov r1, 1\nend:", Integer(1)},
+		{"catch closed", "chan r2, 1\nclose r2\ntry catch\nsend r2, 1\nendtry\ncatch:", FaultClosedChannel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := assemble(t, tt.src)
+			expect := NewRuntime(program, &Config{StackSize: 8, HeapSize: 4})
+			if err := expect.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expect, expect.Register(R1)); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+
+			r := NewRuntime(program, &Config{StackSize: 8, HeapSize: 4})
+			if err := r.RunDecoded(); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(expect, r, cmp.AllowUnexported(Runtime{}, value{}, thread{}, wait{}, channel{}, pending{})); diff != "" {
+				t.Errorf("decoded: %s", diff)
+			}
+		})
+	}
+}
+
+func TestRuntime_Channel_Error(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect string
+	}{
+		{"full", "chan r2, 1\nsend r2, 1\nsend r2, 2", "deadlock: 1 threads blocked: 0: send @0"},
+		{"empty", "chan r2, 0\nrecv r1, r2", "deadlock: 1 threads blocked: 0: recv @0"},
+		{
+			"crossed",
+			"chan r2, 0\nchan r3, 0\nspawn w, r3\nrecv r1, r2\njmp end\nw:\nmov r3, [bp+2]\nrecv r1, r3\nret\nend:",
+			"deadlock: 2 threads blocked: 0: recv @0, 1: recv @1",
+		},
+		{"send closed", "chan r2, 0\nclose r2\nsend r2, 1", "send: closed channel: @0"},
+		{"close closed", "chan r2, 0\nclose r2\nclose r2", "close: closed channel: @0"},
+		{"not a channel", "send @1, 1", "chan: not a channel: <nil>"},
+		{"capacity", "chan r2, -1", "chan: invalid capacity: -1"},
+		{"out of memory", "chan r2, 0\nchan r2, 0\nchan r2, 0\nchan r2, 0\nchan r2, 0", "heap: memory access out of bounds: out of memory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := assemble(t, tt.src)
+			for _, name := range []string{"run", "decoded"} {
+				r := NewRuntime(program, &Config{StackSize: 8, HeapSize: 4})
+				run := r.Run
+				if name == "decoded" {
+					run = r.RunDecoded
+				}
+				err := run()
+				if err == nil || err.Error() != tt.expect {
+					t.Errorf("%s: want=%q, got=%v", name, tt.expect, err)
+				}
+			}
+		})
+	}
+
+	r := NewRuntime(assemble(t, "chan r2, 0\nrecv r1, r2"), &Config{StackSize: 8, HeapSize: 4})
+	var de *DeadlockError
+	if err := r.Run(); !errors.Is(err, ErrDeadlock) || !errors.As(err, &de) {
+		t.Fatalf("want=DeadlockError, got=%v", err)
+	}
+	if diff := cmp.Diff([]Blocked{{Thread: 0, Op: RECV, Target: HeapAddress(0)}}, de.Blocked); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
@@ -0,0 +1,96 @@
+package gvm
+
+import (
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// conversions 変換命令ごとの変換元と変換先の型
+var conversions = map[Opcode][2]PrimitiveType{
+	ITOF: {TInteger, TFloat},
+	FTOI: {TFloat, TInteger},
+	CTOI: {TChar, TInteger},
+	ITOC: {TInteger, TChar},
+	ITOB: {TInteger, TBool},
+	BTOI: {TBool, TInteger},
+	CTOB: {TChar, TBool},
+	BTOC: {TBool, TChar},
+	FTOB: {TFloat, TBool},
+	BTOF: {TBool, TFloat},
+	CTOF: {TChar, TFloat},
+	FTOC: {TFloat, TChar},
+}
+
+func (r *Runtime) convert(op Opcode, dst Register, src Operand) error {
+	v, ok := r.value(src).(Immediate)
+	if !ok || v.Type() != conversions[op][0] {
+		return fmt.Errorf("%w: %s %v", ErrTypeMismatch, op.String(), src)
+	}
+	r.set(dst, convert(v, conversions[op][1]))
+	return nil
+}
+
+// convert 値を別の型に変換する. 変換先で表せない値は表せる値に丸める
+//   - Float -> Integer, Char: 0方向に切り捨て, NaNは0, Integerの範囲外は最大値/最小値
+//   - Integer, Float -> Char: Unicodeのコードポイントでない値はU+FFFD
+//   - -> Bool: 0以外はtrue (NaNもtrue)
+//   - Bool ->: trueは1, falseは0
+func convert(v Immediate, to PrimitiveType) Immediate {
+	switch to {
+	case TInteger:
+		return Integer(integerOf(v))
+	case TFloat:
+		switch v := v.(type) {
+		case Float:
+			return v
+		default:
+			return Float(v.Value())
+		}
+	case TChar:
+		i := integerOf(v)
+		if !utf8.ValidRune(rune(i)) || i != int(rune(i)) {
+			return Char(utf8.RuneError)
+		}
+		return Char(i)
+	case TBool:
+		switch v := v.(type) {
+		case Float:
+			return Bool(v != 0)
+		default:
+			return Bool(v.Value() != 0)
+		}
+	default:
+		panic("unknown primitive type")
+	}
+}
+
+func integerOf(v Immediate) int {
+	switch v := v.(type) {
+	case Float:
+		return ftoi(float64(v))
+	default:
+		return v.Value()
+	}
+}
+
+func ftoi(f float64) int {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f >= math.MaxInt:
+		return math.MaxInt
+	case f <= math.MinInt:
+		return math.MinInt
+	default:
+		return int(f)
+	}
+}
+
+// typeOf 値のPrimitiveTypeをIntegerで返す. Immediateでない値(アドレスやnil)は0
+func typeOf(v Operand) Integer {
+	if i, ok := v.(Immediate); ok {
+		return Integer(i.Type())
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+package gvm
+
+import (
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name   string
+		v      Immediate
+		to     PrimitiveType
+		expect Immediate
+	}{
+		{"itof", Integer(-3), TFloat, Float(-3)},
+		{"ftoi", Float(2.9), TInteger, Integer(2)},
+		{"ftoi negative", Float(-2.9), TInteger, Integer(-2)},
+		{"ftoi nan", Float(math.NaN()), TInteger, Integer(0)},
+		{"ftoi +inf", Float(math.Inf(1)), TInteger, Integer(math.MaxInt)},
+		{"ftoi -inf", Float(math.Inf(-1)), TInteger, Integer(math.MinInt)},
+		{"ctoi", Char('7'), TInteger, Integer(55)},
+		{"itoc", Integer(0x3042), TChar, Char('あ')},
+		{"itoc negative", Integer(-1), TChar, Char(0xfffd)},
+		{"itoc surrogate", Integer(0xd800), TChar, Char(0xfffd)},
+		{"itoc too large", Integer(0x110000), TChar, Char(0xfffd)},
+		{"itoc overflow rune", Integer(1<<32 + 'a'), TChar, Char(0xfffd)},
+		{"itob", Integer(-5), TBool, Bool(true)},
+		{"itob zero", Integer(0), TBool, Bool(false)},
+		{"btoi", Bool(true), TInteger, Integer(1)},
+		{"ctob", Char(0), TBool, Bool(false)},
+		{"btoc", Bool(true), TChar, Char(1)},
+		{"ftob", Float(-0.0), TBool, Bool(false)},
+		{"ftob nan", Float(math.NaN()), TBool, Bool(true)},
+		{"btof", Bool(false), TFloat, Float(0)},
+		{"ctof", Char('a'), TFloat, Float(97)},
+		{"ftoc", Float(97.9), TChar, Char('a')},
+		{"ftoc nan", Float(math.NaN()), TChar, Char(0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.expect, convert(tt.v, tt.to)); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestRuntime_Typeof(t *testing.T) {
+	// 数字のCharなら数値に, それ以外は-1にする
+	prog := Program{
+		TYPEOF, R2, R1,
+		EQ, R2, Integer(TChar),
+		JNE, ProgramAddress(16),
+		CTOI, R1, R1,
+		SUB, R1, Integer('0'),
+		JMP, ProgramAddress(19),
+		MOV, R1, Integer(-1), // 16
+	}
+	tests := []struct {
+		name   string
+		input  Operand
+		expect Operand
+	}{
+		{"digit", Char('7'), Integer(7)},
+		{"integer", Integer(7), Integer(-1)},
+		{"address", HeapAddress(0), Integer(-1)},
+		{"nil", nil, Integer(-1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRuntime(prog, &Config{StackSize: 2})
+			r.set(R1, tt.input)
+			if err := r.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expect, r.Register(R1)); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
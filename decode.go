@@ -0,0 +1,424 @@
+package gvm
+
+// レジスタ配列上の位置. 汎用レジスタはiR0から数だけ並ぶ
+const (
+	iPC = iota
+	iBP
+	iSP
+	iHP
+	iZF
+	iSF
+	iCF
+	iOF
+	iR0
+)
+
+func regIndex(reg Register) int {
+	switch reg := reg.(type) {
+	case SpecialRegister:
+		return iPC + int(reg-PC)
+	case GeneralPurposeRegister:
+		return iR0 + int(reg)
+	case FlagRegister:
+		return iZF + int(reg-ZF)
+	default:
+		panic("unknown register")
+	}
+}
+
+// registerAt regIndexの逆
+func registerAt(i int) Register {
+	switch {
+	case i < iZF:
+		return PC + SpecialRegister(i-iPC)
+	case i < iR0:
+		return ZF + FlagRegister(i-iZF)
+	default:
+		return GeneralPurposeRegister(i - iR0)
+	}
+}
+
+type arg struct {
+	kind operandKind
+	word Operand
+	v    value // wordをvalueにしたもの
+	reg  int   // kRegister: レジスタ配列上の位置
+	bp   bool  // kStackOffset: BP相対かどうか
+	n    int   // kStackOffset: オフセット, kTarget: ジャンプ先の命令番号
+}
+
+type instruction struct {
+	op      Opcode
+	at      int // Program上の位置
+	next    int // 次の命令のProgram上の位置
+	special bool
+	args    [4]arg
+}
+
+// decoded デコード済みのProgram
+type decoded struct {
+	insts []instruction
+	index []int // Program上の位置から命令番号へ. オペランドの位置は-1
+}
+
+func decode(program Program) (*decoded, error) {
+	if err := Verify(program); err != nil {
+		return nil, err
+	}
+	d := &decoded{index: make([]int, len(program)+1)}
+	for i := range d.index {
+		d.index[i] = -1
+	}
+	for _, inst := range Instructions(program) {
+		ins := instruction{op: inst.Op, at: inst.At, next: inst.Next()}
+		for i, w := range inst.Operands {
+			w := w.(Operand)
+			a := arg{kind: kindOf(w), word: w, v: valueOf(w)}
+			switch w := w.(type) {
+			case Register:
+				a.reg = regIndex(w)
+				// PC, BP, SPはmachineが別に持っていて, HPへの書き込みは型を確かめるので従来の経路に任せる
+				if a.reg < iZF {
+					ins.special = true
+				}
+			case BpOffset:
+				a.bp, a.n = true, w.Value()
+			case SpOffset:
+				a.n = w.Value()
+			}
+			ins.args[i] = a
+		}
+		d.index[inst.At] = len(d.insts)
+		d.insts = append(d.insts, ins)
+	}
+	d.index[len(program)] = len(d.insts)
+	for i := range d.insts {
+		ins := &d.insts[i]
+		if isJump(ins.op) || isCall(ins.op) {
+			ins.args[0].n = d.index[Target(ins.at, ins.args[0].word)]
+		}
+	}
+	return d, nil
+}
+
+// machine デコード済みの命令をRuntimeのレジスタ配列の上で直接実行する.
+// 型の不一致や範囲外アクセスなど, 例外的な状況はRuntime.doに任せて同じ結果を得る.
+type machine struct {
+	r    *Runtime
+	code *decoded
+	regs []value // 実行中のスレッドのr.registers
+	i    int     // 次に実行する命令
+	sp   int
+	bp   int
+}
+
+// RunDecoded Programをデコードしてから実行する. 結果はRunと同じ
+func (r *Runtime) RunDecoded() error {
+	// 記録, 再生, プロファイルでは一命令ずつ観測する必要があるので従来の経路で実行する
+	if r.host != nil && (r.host.record != nil || r.host.replay != nil) || r.profiler != nil {
+		return r.Run()
+	}
+	code, err := decode(r.program)
+	if err != nil {
+		return err
+	}
+	if err := r.checkRegisters(); err != nil {
+		return err
+	}
+	return r.runDecoded(code)
+}
+
+// runDecoded 検査済みのcodeを実行する. codeは書き換えないので複数のRuntimeで共有できる
+func (r *Runtime) runDecoded(code *decoded) error {
+	m := &machine{r: r, code: code}
+	return m.run()
+}
+
+// load PC, SP, BPを取り出す. スレッドが切り替わっていればレジスタ配列も取り直す
+func (m *machine) load() bool {
+	m.regs = m.r.registers
+	pc, sp, bp := m.regs[iPC], m.regs[iSP], m.regs[iBP]
+	if pc.kind != kindProgramAddress || sp.kind != kindStackPointer || bp.kind != kindBasePointer {
+		return false
+	}
+	at := int(pc.bits)
+	if at < 0 || len(m.code.index) <= at || m.code.index[at] < 0 {
+		return false
+	}
+	m.i, m.sp, m.bp = m.code.index[at], int(sp.bits), int(bp.bits)
+	return true
+}
+
+// store PC, SP, BPをレジスタ配列に書き戻す
+func (m *machine) store() {
+	at := len(m.r.program)
+	if m.i < len(m.code.insts) {
+		at = m.code.insts[m.i].at
+	}
+	m.r.setPC(ProgramAddress(at))
+	m.r.setSP(StackPointer(m.sp))
+	m.r.setBP(BasePointer(m.bp))
+}
+
+func (m *machine) run() error {
+	for {
+		if !m.load() {
+			return m.r.loop()
+		}
+		for m.i < len(m.code.insts) {
+			if m.step(&m.code.insts[m.i]) {
+				continue
+			}
+			m.store()
+			if err := m.r.catch(m.r.do()); err != nil {
+				return err
+			}
+			if err := m.r.reschedule(); err != nil {
+				return err
+			}
+			if !m.load() {
+				return m.r.loop()
+			}
+		}
+		m.store()
+		// 実行中のスレッドが終わっても, 他のスレッドが残っていれば切り替えて続ける
+		if err := m.r.reschedule(); err != nil {
+			return err
+		}
+		if m.r.Halted() {
+			return nil
+		}
+	}
+}
+
+func (m *machine) offset(a *arg) (int, bool) {
+	at := m.sp + a.n
+	if a.bp {
+		at = m.bp + a.n
+	}
+	return at, 0 <= at && at < len(m.r.stack)
+}
+
+// value オペランドの値を読む. 読めない場合はfalse
+func (m *machine) value(a *arg) (value, bool) {
+	switch a.kind {
+	case kRegister:
+		return m.regs[a.reg], true
+	case kStackOffset:
+		at, ok := m.offset(a)
+		if !ok {
+			return value{}, false
+		}
+		return m.r.stack[at], true
+	default:
+		return a.v, true
+	}
+}
+
+func (m *machine) stockable(a *arg) (value, bool) {
+	v, ok := m.value(a)
+	if !ok {
+		return value{}, false
+	}
+	return v, v.stockable() || v.kind == kindNil && a.kind == kStackOffset
+}
+
+func (m *machine) integer(a *arg) (Integer, bool) {
+	v, ok := m.value(a)
+	return Integer(v.bits), ok && v.kind == kindInteger
+}
+
+func (m *machine) address(a *arg) (int, bool) {
+	v, ok := m.value(a)
+	// スタックへの間接参照は従来の経路に任せる
+	if !ok || v.kind == kindNil || v.kind == kindStackAddress {
+		return 0, false
+	}
+	at := v.int()
+	return at, 0 <= at && at < len(m.r.heap)
+}
+
+// step 一命令を実行する. 従来の経路に任せる場合は何もせずfalseを返す
+func (m *machine) step(ins *instruction) bool {
+	if ins.special {
+		return false
+	}
+	stack := m.r.stack
+	dst, src := &ins.args[0], &ins.args[1]
+	switch ins.op {
+	case NOP:
+	case MOV:
+		if dst.kind == kRegister {
+			v, ok := m.value(src)
+			if !ok {
+				return false
+			}
+			m.regs[dst.reg] = v
+			break
+		}
+		at, ok := m.offset(dst)
+		if !ok {
+			return false
+		}
+		v, ok := m.stockable(src)
+		if !ok {
+			return false
+		}
+		stack[at] = v
+	case PUSH:
+		v, ok := m.stockable(dst)
+		if !ok || m.sp-1 < 0 {
+			return false
+		}
+		m.sp--
+		stack[m.sp] = v
+	case POP:
+		if m.sp < 0 || len(stack) <= m.sp {
+			return false
+		}
+		m.regs[dst.reg] = stack[m.sp]
+		stack[m.sp] = value{}
+		m.sp++
+	case ALLOC:
+		size, ok := m.value(dst)
+		if !ok || size.kind == kindNil {
+			return false
+		}
+		hp := m.regs[iHP]
+		if hp.kind != kindHeapAddress || len(m.r.heap) <= int(hp.bits)+size.int() || m.sp-1 < 0 {
+			return false
+		}
+		m.regs[iHP] = makeValue(kindHeapAddress, int(hp.bits)+size.int())
+		m.sp--
+		stack[m.sp] = hp
+	case STORE:
+		at, ok := m.address(dst)
+		if !ok {
+			return false
+		}
+		v, ok := m.value(src)
+		if !ok || !v.stockable() {
+			return false
+		}
+		m.r.heap[at] = v
+	case LOAD:
+		at, ok := m.address(src)
+		if !ok {
+			return false
+		}
+		m.regs[dst.reg] = m.r.heap[at]
+	case ADD, SUB, MUL, DIV:
+		x, ok := m.integer(dst)
+		if !ok {
+			return false
+		}
+		y, ok := m.integer(src)
+		if !ok {
+			return false
+		}
+		var z Integer
+		switch ins.op {
+		case ADD:
+			z = x + y
+		case SUB:
+			z = x - y
+		case MUL:
+			z = x * y
+		case DIV:
+			if y == 0 {
+				return false
+			}
+			z = x / y
+		}
+		m.regs[dst.reg] = makeValue(kindInteger, int(z))
+		m.setFlags(intFlags(ins.op, x, y, z))
+	case CMP:
+		x, ok := m.integer(dst)
+		if !ok {
+			return false
+		}
+		y, ok := m.integer(src)
+		if !ok {
+			return false
+		}
+		m.setFlags(intFlags(CMP, x, y, x-y))
+	case EQ, NE, LT, LE:
+		v1, ok := m.value(dst)
+		if !ok {
+			return false
+		}
+		v2, ok := m.value(src)
+		if !ok {
+			return false
+		}
+		if !sameType(v1, v2) || v1.kind == kindFloat {
+			return false
+		}
+		var result bool
+		switch ins.op {
+		case EQ:
+			result = v1.int() == v2.int()
+		case NE:
+			result = v1.int() != v2.int()
+		case LT:
+			result = v1.int() < v2.int()
+		case LE:
+			result = v1.int() <= v2.int()
+		}
+		m.setFlags(flags{zf: result})
+	case JMP:
+		m.i = dst.n
+		return true
+	case JE, JNE, JL, JLE, JG, JGE, JB, JBE, JA, JAE, JO, JNO, JS, JNS:
+		var bs [len(flagRegisters)]bool
+		for i := range bs {
+			b := m.regs[iZF+i]
+			if b.kind != kindBool {
+				return false
+			}
+			bs[i] = b.bits != 0
+		}
+		if conditions[ins.op](flags{bs[0], bs[1], bs[2], bs[3]}) {
+			m.i = dst.n
+			return true
+		}
+	case CALL:
+		if m.sp-2 < 0 {
+			return false
+		}
+		stack[m.sp-1] = makeValue(kindProgramAddress, ins.next)
+		stack[m.sp-2] = makeValue(kindBasePointer, m.bp)
+		m.sp -= 2
+		m.bp = m.sp
+		m.i = dst.n
+		return true
+	case RET:
+		if m.bp < 0 || len(stack) <= m.bp+1 {
+			return false
+		}
+		bp, ret := stack[m.bp], stack[m.bp+1]
+		if bp.kind != kindBasePointer || ret.kind != kindProgramAddress {
+			return false
+		}
+		at := int(ret.bits)
+		if at < 0 || len(m.code.index) <= at || m.code.index[at] < 0 {
+			return false
+		}
+		stack[m.bp], stack[m.bp+1] = value{}, value{}
+		m.sp = m.bp + 2
+		m.bp = int(bp.bits)
+		m.i = m.code.index[at]
+		return true
+	default:
+		return false
+	}
+	m.i++
+	return true
+}
+
+func (m *machine) setFlags(f flags) {
+	m.regs[iZF] = boolValue(f.zf)
+	m.regs[iSF] = boolValue(f.sf)
+	m.regs[iCF] = boolValue(f.cf)
+	m.regs[iOF] = boolValue(f.of)
+}
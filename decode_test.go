@@ -0,0 +1,167 @@
+package gvm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// arithProgram 0からn-1までの和をR1に求める
+func arithProgram(n int) Program {
+	return Program{
+		MOV, R1, Integer(0),
+		MOV, R2, Integer(0),
+		ADD, R1, R2, // 6
+		ADD, R2, Integer(1),
+		LT, R2, Integer(n),
+		JE, ProgramAddress(6),
+	}
+}
+
+// callProgram 引数に1を足して返す関数をn回呼ぶ
+func callProgram(n int) Program {
+	return Program{
+		MOV, R1, Integer(0),
+		MOV, R2, Integer(0),
+		PUSH, R1, // 6
+		CALL, ProgramAddress(22),
+		POP, R3,
+		ADD, R2, Integer(1),
+		LT, R2, Integer(n),
+		JE, ProgramAddress(6),
+		JMP, ProgramAddress(29),
+		MOV, R1, BpOffset(2), // 22
+		ADD, R1, Integer(1),
+		RET,
+	}
+}
+
+func TestRuntime_RunDecoded(t *testing.T) {
+	tests := []struct {
+		name   string
+		prog   Program
+		config *Config
+	}{
+		{"empty", Program{}, &Config{StackSize: 2}},
+		{"arith", arithProgram(10), &Config{StackSize: 2}},
+		{"call", callProgram(10), &Config{StackSize: 8}},
+		{"push in loop", pushLoop, &Config{StackSize: 8}},
+		{
+			"alloc, store, load",
+			Program{
+				ALLOC, Integer(2),
+				POP, R1,
+				STORE, R1, Integer(42),
+				LOAD, R2, R1,
+				MOV, HP, HeapAddress(3),
+			},
+			&Config{StackSize: 2, HeapSize: 4},
+		},
+		{
+			"mov stack",
+			Program{
+				PUSH, Integer(1),
+				PUSH, Integer(2),
+				MOV, SpOffset(1), SpOffset(0),
+				MOV, R1, SP,
+				MOV, BpOffset(0), Bool(true),
+			},
+			&Config{StackSize: 4},
+		},
+		{
+			"typemismatch",
+			Program{
+				MOV, R1, Integer(1),
+				ADD, R1, Bool(true),
+			},
+			&Config{StackSize: 2},
+		},
+		{
+			"compare typemismatch",
+			Program{
+				MOV, R1, Char('a'),
+				EQ, R1, Integer(97),
+			},
+			&Config{StackSize: 2},
+		},
+		{
+			"broken frame",
+			Program{
+				RET,
+			},
+			&Config{StackSize: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expect := NewRuntime(tt.prog, tt.config)
+			expectErr := expect.Run()
+			r := NewRuntime(tt.prog, tt.config)
+			err := r.RunDecoded()
+			if fmt.Sprint(expectErr) != fmt.Sprint(err) {
+				t.Errorf("want=%v, got=%v", expectErr, err)
+			}
+			if diff := cmp.Diff(expect, r, cmp.AllowUnexported(Runtime{}, value{})); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestRuntime_RunDecoded_Result(t *testing.T) {
+	r := NewRuntime(arithProgram(10), &Config{StackSize: 2})
+	if err := r.RunDecoded(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Integer(45), r.Register(R1)); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+
+	r = NewRuntime(callProgram(10), &Config{StackSize: 8})
+	if err := r.RunDecoded(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Integer(10), r.Register(R1)); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+
+	// 合流点でスタックの深さが違っても実行できる
+	r = NewRuntime(pushLoop, &Config{StackSize: 8})
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Integer(3), r.Register(R1)); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	benchmarks := []struct {
+		name   string
+		prog   Program
+		config *Config
+	}{
+		{"arith", arithProgram(1000), &Config{StackSize: 2}},
+		{"call", callProgram(1000), &Config{StackSize: 8}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := NewRuntime(bm.prog, bm.config).Run(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(bm.name+"/decoded", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := NewRuntime(bm.prog, bm.config).RunDecoded(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package gvm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Disassemble ProgramをAssembleで読めるテキストにする.
+// ジャンプ先はL<位置>というラベルになり, ProgramOffsetはProgramAddressとして読み直される.
+func Disassemble(program Program) (string, error) {
+	labels := map[int]bool{}
+	for at := 0; at < len(program); {
+		op, ok := program[at].(Opcode)
+		if !ok {
+			return "", fmt.Errorf("disasm: %d: want=opcode, got=%v", at, program[at])
+		}
+		if len(program) <= at+op.NumOperands() {
+			return "", fmt.Errorf("disasm: %d: %s: want %d operands, got %d", at, op, op.NumOperands(), len(program)-at-1)
+		}
+		if isJump(op) || isCall(op) {
+			switch w := program[at+1].(type) {
+			case ProgramAddress, ProgramOffset:
+				labels[Target(at, w)] = true
+			}
+		}
+		at += 1 + op.NumOperands()
+	}
+
+	var sb strings.Builder
+	for _, inst := range Instructions(program) {
+		if labels[inst.At] {
+			fmt.Fprintf(&sb, "L%d:\n", inst.At)
+		}
+		sb.WriteString("    " + inst.Op.String())
+		for i, w := range inst.Operands {
+			if i == 0 {
+				sb.WriteString(" ")
+			} else {
+				sb.WriteString(", ")
+			}
+			switch w := w.(type) {
+			case ProgramAddress, ProgramOffset:
+				if isJump(inst.Op) || isCall(inst.Op) {
+					fmt.Fprintf(&sb, "L%d", Target(inst.At, w.(Operand)))
+					continue
+				}
+			case Float:
+				// 1.0が整数として読まれないように小数点を付ける
+				s := w.String()
+				if !strings.ContainsAny(s, ".eIN") {
+					s += ".0"
+				}
+				sb.WriteString(s)
+				continue
+			}
+			sb.WriteString(w.String())
+		}
+		sb.WriteString("\n")
+	}
+	// プログラムの終わりを指すラベル
+	if labels[len(program)] {
+		fmt.Fprintf(&sb, "L%d:\n", len(program))
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,86 @@
+package gvm
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDisassemble(t *testing.T) {
+	program := Program{
+		MOV, R0, Float(1),
+		MOV, GeneralPurposeRegister(12), Integer(-3),
+		PUSH, BpOffset(-1), // 6
+		STORE, HeapAddress(2), Bool(true),
+		JNE, ProgramOffset(-5),
+		CALL, ProgramAddress(16),
+		RET, // 15
+		JMP, ProgramAddress(18),
+	}
+	src, err := Disassemble(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(`    mov r0, 1.0
+    mov r12, -3
+L6:
+    push [bp-1]
+    store @2, true
+    jne L6
+    call L16
+    ret
+L16:
+    jmp L18
+L18:
+`, src); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+
+	// 読み直すとProgramOffsetはProgramAddressになる
+	program[12] = ProgramAddress(6)
+	got, err := Assemble(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(program, got); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func TestDisassemble_Alias(t *testing.T) {
+	// r4, r5はacm1, acm2と書く
+	program := Program{MOV, ACM1, ACM2, MOV, GeneralPurposeRegister(6), ACM1}
+	src, err := Disassemble(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("    mov acm1, acm2\n    mov r6, acm1\n", src); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	got, err := Assemble(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(program, got); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func TestDisassemble_Error(t *testing.T) {
+	tests := []struct {
+		name    string
+		program Program
+		expect  string
+	}{
+		{"opcode", Program{R1}, "disasm: 0: want=opcode, got=r1"},
+		{"operands", Program{NOP, MOV, R1}, "disasm: 1: mov: want 2 operands, got 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Disassemble(tt.program)
+			if err == nil || err.Error() != tt.expect {
+				t.Errorf("want=%q, got=%v", tt.expect, err)
+			}
+		})
+	}
+}
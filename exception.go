@@ -0,0 +1,94 @@
+package gvm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 実行時のフォールト. TRYの中で起きたものはプログラムが捕捉できる
+var (
+	ErrDivisionByZero  = errors.New("division by zero")
+	ErrOutOfBounds     = errors.New("memory access out of bounds")
+	ErrIndexOutOfRange = errors.New("index out of range")
+	ErrTypeMismatch    = errors.New("typemismatch")
+)
+
+// フォールトを捕捉したときにR1へ入る値
+const (
+	FaultDivisionByZero Integer = -(iota + 1)
+	FaultOutOfBounds
+	FaultTypeMismatch
+	FaultClosedChannel
+)
+
+// faultCode 捕捉できるフォールトならその値
+func faultCode(err error) (Integer, bool) {
+	switch {
+	case errors.Is(err, ErrDivisionByZero):
+		return FaultDivisionByZero, true
+	case errors.Is(err, ErrOutOfBounds), errors.Is(err, ErrIndexOutOfRange):
+		return FaultOutOfBounds, true
+	case errors.Is(err, ErrTypeMismatch):
+		return FaultTypeMismatch, true
+	case errors.Is(err, ErrClosedChannel):
+		return FaultClosedChannel, true
+	default:
+		return 0, false
+	}
+}
+
+// ThrowError ハンドラのないTHROW
+type ThrowError struct {
+	Value Stockable
+}
+
+func (e *ThrowError) Error() string {
+	return fmt.Sprintf("uncaught throw: %v", e.Value)
+}
+
+// handler TRYで設置したハンドラ. 捕捉したらSP, BPを設置時に戻してcatchへ飛ぶ
+type handler struct {
+	catch ProgramAddress
+	sp    StackPointer
+	bp    BasePointer
+}
+
+// popHandler 直近のハンドラを外す. 空になったらnilに戻す
+func (r *Runtime) popHandler() (handler, bool) {
+	if len(r.handlers) == 0 {
+		return handler{}, false
+	}
+	h := r.handlers[len(r.handlers)-1]
+	r.handlers = r.handlers[:len(r.handlers)-1]
+	if len(r.handlers) == 0 {
+		r.handlers = nil
+	}
+	return h, true
+}
+
+// throw 直近のハンドラへ値を投げる. ハンドラがなければfalse
+func (r *Runtime) throw(v Stockable) bool {
+	h, ok := r.popHandler()
+	if !ok {
+		return false
+	}
+	for sp := r.sp(); sp < h.sp; sp++ {
+		r.stack[sp] = value{}
+	}
+	r.setSP(h.sp)
+	r.setBP(h.bp)
+	r.set(R1, v)
+	r.setPC(h.catch)
+	return true
+}
+
+// catch 命令の実行結果が捕捉できるフォールトなら, ハンドラへ投げてnilにする
+func (r *Runtime) catch(err error) error {
+	if err == nil {
+		return nil
+	}
+	if code, ok := faultCode(err); ok && r.throw(code) {
+		return nil
+	}
+	return err
+}
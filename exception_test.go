@@ -0,0 +1,134 @@
+package gvm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRuntime_Try(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect Operand
+	}{
+		{
+			"throw through call",
+			`
+    try catch
+    push 1
+    push 2
+    call f
+    pop r2
+    pop r2
+    endtry
+    jmp end
+catch:
+    jmp end
+f:
+    push 3
+    throw 7
+    ret
+end:`,
+			Integer(7),
+		},
+		{"division by zero", "try catch\nmov r2, 1\ndiv r2, 0\nendtry\ncatch:", FaultDivisionByZero},
+		{"heap", "try catch\nstore @5, 1\nendtry\ncatch:", FaultOutOfBounds},
+		{"typemismatch", "try catch\nmov r2, 1\nadd r2, 1.0\nendtry\ncatch:", FaultTypeMismatch},
+		{"out of memory", "try catch\nalloc 8\npop r3\nendtry\ncatch:", FaultOutOfBounds},
+		{"stack overflow", "try catch\ncall f\nendtry\ncatch:\njmp end\nf:\ncall f\nend:", FaultOutOfBounds},
+		{"offset", "try catch\nmov r2, [bp-5]\nendtry\ncatch:", FaultOutOfBounds},
+		{"nil push", "try catch\npush r2\npop r3\nendtry\ncatch:", FaultTypeMismatch},
+		{"nil alloc", "try catch\nalloc r2\npop r3\nendtry\ncatch:", FaultTypeMismatch},
+		{"invalid value", "try catch\nmov r2, true\nmov r3, false\nadd r2, r3\nendtry\ncatch:", FaultTypeMismatch},
+		{"invalid flag", "try catch\nmov zf, 1\nje l\nl:\nendtry\ncatch:", FaultTypeMismatch},
+		{"mov sp", "try catch\nmov r2, 3\nmov sp, r2\nendtry\ncatch:", FaultTypeMismatch},
+		{"mov hp", "try catch\nmov hp, r2\nendtry\ncatch:", FaultTypeMismatch},
+		{"pop pc", "try catch\npush 3\npop pc\nendtry\ncatch:", FaultTypeMismatch},
+		{
+			"nested",
+			`
+    try outer
+    try inner
+    endtry
+    throw true
+inner:
+    mov r1, 1
+outer:`,
+			Bool(true),
+		},
+		{
+			"rethrow",
+			`
+    try outer
+    try inner
+    throw 1
+inner:
+    add r1, 1
+    throw r1
+outer:`,
+			Integer(2),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Assemble(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"run", "decoded"} {
+				r := NewRuntime(program, &Config{StackSize: 8, HeapSize: 2})
+				run := r.Run
+				if name == "decoded" {
+					run = r.RunDecoded
+				}
+				if err := run(); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if diff := cmp.Diff(tt.expect, r.Register(R1)); diff != "" {
+					t.Errorf("%s: diff: %s", name, diff)
+				}
+				// ハンドラを設置したときのフレームに戻っている
+				if r.sp() != 7 || r.bp() != 0 || r.handlers != nil {
+					t.Errorf("%s: sp=%d, bp=%d, handlers=%v", name, r.sp(), r.bp(), r.handlers)
+				}
+			}
+		})
+	}
+}
+
+func TestRuntime_Throw_Uncaught(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		check func(err error) bool
+	}{
+		{"throw", "push 1.5\nthrow [sp+0]", func(err error) bool {
+			var te *ThrowError
+			return errors.As(err, &te) && te.Value == Float(1.5) && err.Error() == "uncaught throw: 1.5"
+		}},
+		{"fault", "mov r1, 1\ndiv r1, 0", func(err error) bool {
+			return errors.Is(err, ErrDivisionByZero) && err.Error() == "division by zero: r1 / 0"
+		}},
+		{"after endtry", "try end\nendtry\nthrow 1\nend:", func(err error) bool {
+			var te *ThrowError
+			return errors.As(err, &te) && te.Value == Integer(1)
+		}},
+		{"endtry", "endtry", func(err error) bool {
+			return err.Error() == "endtry: no handler"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Assemble(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := NewRuntime(program, &Config{StackSize: 8, HeapSize: 2})
+			if err := r.Run(); err == nil || !tt.check(err) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
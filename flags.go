@@ -0,0 +1,110 @@
+package gvm
+
+import (
+	"fmt"
+	"math"
+)
+
+// flags ZF, SF, CF, OFの組
+type flags struct {
+	zf, sf, cf, of bool
+}
+
+var flagRegisters = [...]FlagRegister{ZF, SF, CF, OF}
+
+// unordered NaNとの比較の結果か. CMPはZF, SF, CF, OFを全て立てる.
+// 演算ではZFとSFが同時に立つことはないので, この組で見分ける.
+// ZFだけを結果にする命令(EQ, NE, LT, LE, RECV)は, 前のSFが残らないよう他のフラグを下ろす
+func (f flags) unordered() bool {
+	return f.zf && f.sf
+}
+
+// ordered 大小, 等しいかを見る条件. 順序なしでは飛ばない
+func ordered(cond func(f flags) bool) func(f flags) bool {
+	return func(f flags) bool { return !f.unordered() && cond(f) }
+}
+
+// conditions 条件付きジャンプと, 飛ぶ条件. JNEはJEの否定なので順序なしで飛ぶ
+var conditions = map[Opcode]func(f flags) bool{
+	JE:  ordered(func(f flags) bool { return f.zf }),
+	JNE: func(f flags) bool { return !f.zf || f.unordered() },
+	JL:  ordered(func(f flags) bool { return f.sf != f.of }),
+	JLE: ordered(func(f flags) bool { return f.zf || f.sf != f.of }),
+	JG:  ordered(func(f flags) bool { return !f.zf && f.sf == f.of }),
+	JGE: ordered(func(f flags) bool { return f.sf == f.of }),
+	JB:  ordered(func(f flags) bool { return f.cf }),
+	JBE: ordered(func(f flags) bool { return f.cf || f.zf }),
+	JA:  ordered(func(f flags) bool { return !f.cf && !f.zf }),
+	JAE: ordered(func(f flags) bool { return !f.cf }),
+	JO:  func(f flags) bool { return f.of },
+	JNO: func(f flags) bool { return !f.of },
+	JS:  func(f flags) bool { return f.sf },
+	JNS: func(f flags) bool { return !f.sf },
+}
+
+// intFlags 整数演算x op y = zのフラグ. CMPはSUBと同じ
+func intFlags(op Opcode, x, y, z Integer) flags {
+	f := flags{zf: z == 0, sf: z < 0}
+	switch op {
+	case ADD:
+		f.cf = uint64(z) < uint64(x)
+		f.of = (x < 0) == (y < 0) && (z < 0) != (x < 0)
+	case SUB, CMP:
+		f.cf = uint64(x) < uint64(y)
+		f.of = (x < 0) != (y < 0) && (z < 0) != (x < 0)
+	case MUL:
+		overflow := x != 0 && (z/x != y || x == -1 && y == math.MinInt)
+		f.cf, f.of = overflow, overflow
+	case DIV:
+		f.of = x == math.MinInt && y == -1
+	}
+	return f
+}
+
+// floatFlags 浮動小数点数の演算結果zのフラグ. 桁あふれは無限大になるのでCF, OFは立てない
+func floatFlags(z Float) flags {
+	return flags{zf: z == 0, sf: z < 0}
+}
+
+// cmpFlags CMP x, yのフラグ. 浮動小数点数は大小をSF, CFに入れ, NaNとの比較(順序なし)では全て立てる
+func cmpFlags(x, y value) flags {
+	if x.kind == kindFloat {
+		fx, fy := x.float(), y.float()
+		if math.IsNaN(fx) || math.IsNaN(fy) {
+			return flags{zf: true, sf: true, cf: true, of: true}
+		}
+		return flags{zf: fx == fy, sf: fx < fy, cf: fx < fy}
+	}
+	a, b := Integer(x.int()), Integer(y.int())
+	return intFlags(CMP, a, b, a-b)
+}
+
+func (r *Runtime) setFlags(f flags) {
+	r.registers[iZF] = boolValue(f.zf)
+	r.registers[iSF] = boolValue(f.sf)
+	r.registers[iCF] = boolValue(f.cf)
+	r.registers[iOF] = boolValue(f.of)
+}
+
+// flags フラグレジスタを読む. MOVなどでBool以外が入っていればfalse
+func (r *Runtime) flags() (flags, bool) {
+	var bs [len(flagRegisters)]bool
+	for i, reg := range flagRegisters {
+		v := r.get(reg)
+		if v.kind != kindBool {
+			return flags{}, false
+		}
+		bs[i] = v.bits != 0
+	}
+	return flags{bs[0], bs[1], bs[2], bs[3]}, true
+}
+
+// cmp CMP o1, o2. 同じ型の値を比べてフラグだけを更新する
+func (r *Runtime) cmp(o1, o2 Operand) error {
+	x, y := r.read(o1), r.read(o2)
+	if !sameType(x, y) {
+		return fmt.Errorf("%w: cmp %v, %v", ErrTypeMismatch, o1, o2)
+	}
+	r.setFlags(cmpFlags(x, y))
+	return nil
+}
@@ -0,0 +1,129 @@
+package gvm
+
+import (
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIntFlags(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     Opcode
+		x, y   Integer
+		expect flags
+	}{
+		{"add zero", ADD, 1, -1, flags{zf: true, cf: true}},
+		{"add sign", ADD, 1, -2, flags{sf: true}},
+		{"add overflow", ADD, math.MaxInt, 1, flags{sf: true, of: true}},
+		{"add carry", ADD, -1, 2, flags{cf: true}},
+		{"sub borrow", SUB, 1, 2, flags{sf: true, cf: true}},
+		{"sub overflow", SUB, math.MinInt, 1, flags{of: true}},
+		{"cmp equal", CMP, 3, 3, flags{zf: true}},
+		{"cmp unsigned", CMP, -1, 1, flags{sf: true}},
+		{"mul overflow", MUL, math.MaxInt, 2, flags{sf: true, cf: true, of: true}},
+		{"mul min", MUL, -1, math.MinInt, flags{sf: true, cf: true, of: true}},
+		{"div overflow", DIV, math.MinInt, -1, flags{sf: true, of: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var z Integer
+			switch tt.op {
+			case ADD:
+				z = tt.x + tt.y
+			case SUB, CMP:
+				z = tt.x - tt.y
+			case MUL:
+				z = tt.x * tt.y
+			case DIV:
+				z = tt.x / tt.y
+			}
+			if diff := cmp.Diff(tt.expect, intFlags(tt.op, tt.x, tt.y, z), cmp.AllowUnexported(flags{})); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestRuntime_Flags(t *testing.T) {
+	// 飛んだらr1を1にする
+	jump := func(setup, j string) string {
+		return setup + "\n" + j + " taken\nmov r1, 0\njmp end\ntaken:\nmov r1, 1\nend:"
+	}
+	type test struct {
+		name   string
+		src    string
+		expect Integer
+	}
+	tests := []test{
+		{"jl", jump("mov r2, -1\ncmp r2, 1", "jl"), 1},
+		{"jl equal", jump("cmp 1, 1", "jl"), 0},
+		{"jle", jump("cmp 1, 1", "jle"), 1},
+		{"jg", jump("cmp 2, -5", "jg"), 1},
+		{"jg equal", jump("cmp 2, 2", "jg"), 0},
+		{"jge", jump("cmp -5, -5", "jge"), 1},
+		{"jb unsigned", jump("cmp -1, 1", "jb"), 0},
+		{"ja unsigned", jump("cmp -1, 1", "ja"), 1},
+		{"jbe", jump("cmp 0, 0", "jbe"), 1},
+		{"jae", jump("cmp 0, 1", "jae"), 0},
+		{"jo", jump("mov r2, 9223372036854775807\nadd r2, 1", "jo"), 1},
+		{"jno", jump("mov r2, 1\nadd r2, 1", "jno"), 1},
+		{"js", jump("mov r2, 1\nsub r2, 2", "js"), 1},
+		{"jns", jump("mov r2, 1\nsub r2, 2", "jns"), 0},
+		{"jne after sub", jump("mov r2, 1\nsub r2, 1", "jne"), 0},
+		{"jl float", jump("cmp -0.5, 0.25", "jl"), 1},
+		{"jo nan", jump("mov r2, 0.0\ndiv r2, 0.0\ncmp r2, 1.0", "jo"), 1},
+		{"je after eq with stale sf", jump("mov r2, 0\nsub r2, 5\neq r2, r2", "je"), 1},
+		{"jne after eq with stale sf", jump("mov r2, 0\nsub r2, 5\neq r2, r2", "jne"), 0},
+		{"je after lt float with stale sf", jump("mov r2, 0\nsub r2, 5\nlt 0.5, 1.5", "je"), 1},
+		{"jl char", jump("mov r2, 97\nitoc r2, r2\nmov r3, 98\nitoc r3, r3\ncmp r2, r3", "jl"), 1},
+	}
+	// NaNとの比較は順序なしで, 大小を見る条件では飛ばない
+	nan := map[string]Integer{
+		"je": 0, "jne": 1, "jl": 0, "jle": 0, "jg": 0, "jge": 0, "jb": 0,
+		"jbe": 0, "ja": 0, "jae": 0, "jo": 1, "jno": 0, "js": 1, "jns": 0,
+	}
+	for _, j := range []string{"je", "jne", "jl", "jle", "jg", "jge", "jb", "jbe", "ja", "jae", "jo", "jno", "js", "jns"} {
+		tests = append(tests,
+			test{j + " unordered", jump("mov r2, 0.0\ndiv r2, 0.0\ncmp r2, 1.0", j), nan[j]},
+			test{j + " unordered rhs", jump("mov r2, 0.0\ndiv r2, 0.0\ncmp 1.0, r2", j), nan[j]},
+		)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Assemble(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := NewRuntime(program, &Config{StackSize: 2})
+			if err := r.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expect, r.Register(R1)); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+
+			d := NewRuntime(program, &Config{StackSize: 2})
+			if err := d.RunDecoded(); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(r, d, cmp.AllowUnexported(Runtime{}, value{}), cmp.Comparer(func(x, y Float) bool {
+				return x == y || math.IsNaN(float64(x)) && math.IsNaN(float64(y))
+			})); diff != "" {
+				t.Errorf("decoded: %s", diff)
+			}
+		})
+	}
+}
+
+func TestRuntime_CmpError(t *testing.T) {
+	r := NewRuntime(Program{CMP, Integer(1), Float(1)}, &Config{StackSize: 2})
+	if err := r.Run(); err == nil || err.Error() != "typemismatch: cmp 1, 1" {
+		t.Errorf("want=typemismatch, got=%v", err)
+	}
+	r = NewRuntime(Program{MOV, SF, Integer(1), JL, ProgramAddress(0)}, &Config{StackSize: 2})
+	if err := r.Run(); err == nil || err.Error() != "typemismatch: invalid jl flag: false, 1, false, false" {
+		t.Errorf("want=invalid flag, got=%v", err)
+	}
+}
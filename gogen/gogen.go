@@ -0,0 +1,283 @@
+package gogen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/x0y14/gvm"
+)
+
+// Options 生成するGoのパッケージ名と関数名
+type Options struct {
+	Package string
+	Func    string
+}
+
+// conditions 条件付きジャンプの条件をGoの式にしたもの. 大小を見る条件は順序なしでは飛ばない
+var conditions = map[gvm.Opcode]string{
+	gvm.JE:  "!flags.Unordered() && flags.ZF",
+	gvm.JNE: "!flags.ZF || flags.Unordered()",
+	gvm.JL:  "!flags.Unordered() && flags.SF != flags.OF",
+	gvm.JLE: "!flags.Unordered() && (flags.ZF || flags.SF != flags.OF)",
+	gvm.JG:  "!flags.Unordered() && !flags.ZF && flags.SF == flags.OF",
+	gvm.JGE: "!flags.Unordered() && flags.SF == flags.OF",
+	gvm.JB:  "!flags.Unordered() && flags.CF",
+	gvm.JBE: "!flags.Unordered() && (flags.CF || flags.ZF)",
+	gvm.JA:  "!flags.Unordered() && !flags.CF && !flags.ZF",
+	gvm.JAE: "!flags.Unordered() && !flags.CF",
+	gvm.JO:  "flags.OF",
+	gvm.JNO: "!flags.OF",
+	gvm.JS:  "flags.SF",
+	gvm.JNS: "!flags.SF",
+}
+
+type generator struct {
+	buf     bytes.Buffer
+	program gvm.Program
+}
+
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// Generate Programと同じ動きをするGoの関数を書き出す.
+//
+//	func Func(stack, heap []gvm.Stockable) (map[gvm.Register]gvm.Operand, error)
+//
+// stack, heapはConfigのStackSize, HeapSizeの長さで渡し, 終わったときのレジスタを返す.
+// 汎用レジスタとフラグはローカル変数, ジャンプはPCでのswitchになる.
+// エラーとpanicはgvm.Runtime.Runと同じものを返す. ただし命令の先頭でない位置へRETするとrt.InvalidTargetになる.
+// 対応する命令はNOP, MOV, PUSH, POP, ALLOC, STORE, LOAD, CALL, RET, 算術, 比較, CMP, ジャンプで,
+// オペランドに特殊レジスタとフラグレジスタは使えない.
+func Generate(program gvm.Program, opts Options) ([]byte, error) {
+	if err := gvm.Verify(program); err != nil {
+		return nil, err
+	}
+	insts := gvm.Instructions(program)
+
+	// 飛び込まれる位置. 関数の戻り先も含む
+	labels := map[int]bool{0: true, len(program): true}
+	registers := gvm.DefaultRegisters
+	for _, inst := range insts {
+		switch inst.Op {
+		case gvm.NOP, gvm.MOV, gvm.PUSH, gvm.POP, gvm.ALLOC, gvm.STORE, gvm.LOAD, gvm.RET,
+			gvm.ADD, gvm.SUB, gvm.MUL, gvm.DIV, gvm.CMP, gvm.EQ, gvm.NE, gvm.LT, gvm.LE:
+		case gvm.JMP, gvm.CALL:
+			labels[gvm.Target(inst.At, inst.Operands[0])] = true
+			labels[inst.Next()] = true
+		default:
+			if _, ok := conditions[inst.Op]; !ok {
+				return nil, fmt.Errorf("gogen: %w", inst.Errorf("unsupported opcode: %s", inst.Op))
+			}
+			labels[gvm.Target(inst.At, inst.Operands[0])] = true
+		}
+		for _, w := range inst.Operands {
+			switch w := w.(type) {
+			case gvm.GeneralPurposeRegister:
+				registers = max(registers, int(w)+1)
+			case gvm.Register:
+				return nil, fmt.Errorf("gogen: %w", inst.Errorf("unsupported operand: %s", w))
+			}
+		}
+	}
+
+	g := &generator{program: program}
+	g.printf("// Code generated by gogen. DO NOT EDIT.\n\n")
+	g.printf("package %s\n\n", opts.Package)
+	g.printf("import (\n\"github.com/x0y14/gvm\"\n\"github.com/x0y14/gvm/gogen/rt\"\n)\n\n")
+	g.printf("func %s(stack, heap []gvm.Stockable) (map[gvm.Register]gvm.Operand, error) {\n", opts.Func)
+	for i := range registers {
+		g.printf("var r%d gvm.Operand\n", i)
+	}
+	g.printf("var flags rt.Flags\n")
+	g.printf("pc, bp, sp, hp := 0, 0, len(stack)-1, 0\n")
+	g.printf("regs := func(pc int) map[gvm.Register]gvm.Operand {\n")
+	g.printf("return map[gvm.Register]gvm.Operand{\n")
+	g.printf("gvm.PC: gvm.ProgramAddress(pc), gvm.BP: gvm.BasePointer(bp), gvm.SP: gvm.StackPointer(sp), gvm.HP: gvm.HeapAddress(hp),\n")
+	g.printf("gvm.ZF: gvm.Bool(flags.ZF), gvm.SF: gvm.Bool(flags.SF), gvm.CF: gvm.Bool(flags.CF), gvm.OF: gvm.Bool(flags.OF),\n")
+	for i := range registers {
+		g.printf("gvm.GeneralPurposeRegister(%d): r%d,\n", i, i)
+	}
+	g.printf("}\n}\n")
+	g.printf("for {\nswitch pc {\n")
+
+	terminal := false
+	for _, inst := range insts {
+		if labels[inst.At] {
+			if inst.At != 0 && !terminal {
+				g.printf("fallthrough\n")
+			}
+			g.printf("case %d:\n", inst.At)
+		}
+		terminal = g.insn(inst)
+	}
+	if !terminal && len(insts) > 0 {
+		g.printf("fallthrough\n")
+	}
+	g.printf("case %d:\nreturn regs(%d), nil\n", len(program), len(program))
+	// Programの終わりより先へ戻ったら止まる
+	g.printf("default:\nif %d < pc {\nreturn regs(pc), nil\n}\n", len(program))
+	g.printf("return regs(pc), rt.InvalidTarget(pc)\n")
+	g.printf("}\n}\n}\n")
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("gogen: %w", err)
+	}
+	return src, nil
+}
+
+// literal 即値とアドレスのGoの式
+func literal(w gvm.Word) string {
+	switch w := w.(type) {
+	case gvm.Integer:
+		return fmt.Sprintf("gvm.Integer(%d)", w)
+	case gvm.Float:
+		f := float64(w)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return fmt.Sprintf("rt.Float(%#x)", math.Float64bits(f))
+		}
+		return fmt.Sprintf("gvm.Float(%s)", strconv.FormatFloat(f, 'g', -1, 64))
+	case gvm.Char:
+		return fmt.Sprintf("gvm.Char(%d)", w)
+	case gvm.Bool:
+		return fmt.Sprintf("gvm.Bool(%t)", w)
+	case gvm.ProgramAddress:
+		return fmt.Sprintf("gvm.ProgramAddress(%d)", w)
+	case gvm.HeapAddress:
+		return fmt.Sprintf("gvm.HeapAddress(%d)", w)
+	default:
+		panic(fmt.Sprintf("gogen: unsupported literal: %s", w))
+	}
+}
+
+// index スタック上の位置(添字)の式
+func index(w gvm.Word) string {
+	switch w := w.(type) {
+	case gvm.BpOffset:
+		return fmt.Sprintf("bp%+d", w)
+	case gvm.SpOffset:
+		return fmt.Sprintf("sp%+d", w)
+	default:
+		panic(fmt.Sprintf("gogen: unsupported offset: %s", w))
+	}
+}
+
+// slot スタック上の位置の式. 範囲はrt.Slotで確かめておく
+func slot(w gvm.Word) string {
+	return "stack[" + index(w) + "]"
+}
+
+// local 汎用レジスタを入れるローカル変数. acm1などの別名ではなくr<番号>にする
+func local(w gvm.Word) string {
+	return fmt.Sprintf("r%d", w.(gvm.GeneralPurposeRegister))
+}
+
+// value オペランドの値の式
+func value(w gvm.Word) string {
+	switch w := w.(type) {
+	case gvm.Register:
+		return local(w)
+	case gvm.Offset:
+		return slot(w)
+	default:
+		return literal(w)
+	}
+}
+
+// stockable スタック, ヒープに置く値の式. レジスタはrt.Stockableで確かめてから型アサーションする
+func stockable(w gvm.Word) string {
+	if reg, ok := w.(gvm.Register); ok {
+		return local(reg) + ".(gvm.Stockable)"
+	}
+	return value(w)
+}
+
+// insn 命令1つ分のコードを書く. 次の命令へ進まないならtrue
+func (g *generator) insn(inst gvm.Instruction) bool {
+	ops := inst.Operands
+	// エラーで止まるときのPC. 多くの命令は止まっても次へ進めてある
+	fail := func(cond, err string) {
+		g.printf("if %s {\nreturn regs(%d), %s\n}\n", cond, inst.Next(), err)
+	}
+	// オペランドを使う前にRuntimeと同じ順で確かめる
+	check := func(w gvm.Word, fn string) {
+		switch w.(type) {
+		case gvm.Offset:
+			fail(fmt.Sprintf("err := rt.Slot(stack, %s, %q); err != nil", index(w), w), "err")
+		case gvm.Register:
+			if fn != "" {
+				fail(fmt.Sprintf("err := rt.%s(gvm.%s, %q, %s); err != nil", fn, opName(inst.Op), w, local(w)), "err")
+			}
+		}
+	}
+	switch op := inst.Op; op {
+	case gvm.NOP:
+	case gvm.MOV:
+		if _, ok := ops[0].(gvm.Register); ok {
+			check(ops[1], "")
+			g.printf("%s = %s\n", local(ops[0]), value(ops[1]))
+		} else {
+			check(ops[0], "")
+			check(ops[1], "Stockable")
+			g.printf("%s = %s\n", slot(ops[0]), stockable(ops[1]))
+		}
+	case gvm.PUSH:
+		check(ops[0], "Stockable")
+		g.printf("if s, err := rt.Push(stack, sp, %s); err != nil {\nreturn regs(%d), err\n} else {\nsp = s\n}\n",
+			stockable(ops[0]), inst.Next())
+	case gvm.POP:
+		g.printf("if v, s, err := rt.Pop(stack, sp); err != nil {\nreturn regs(%d), err\n} else {\n%s, sp = v, s\n}\n",
+			inst.Next(), local(ops[0]))
+	case gvm.ALLOC:
+		size := ""
+		switch w := ops[0].(type) {
+		case gvm.Register:
+			check(w, "Number")
+			size = local(w) + ".Value()"
+		default:
+			size = strconv.Itoa(w.(gvm.Operand).Value())
+		}
+		g.printf("if h, s, err := rt.Alloc(stack, heap, sp, hp, %s); err != nil {\nreturn regs(%d), err\n} else {\nhp, sp = h, s\n}\n",
+			size, inst.Next())
+	case gvm.LOAD:
+		check(ops[1], "Number")
+		g.printf("if v, err := rt.Load(stack, heap, sp, %s); err != nil {\nreturn regs(%d), err\n} else {\n%s = v\n}\n",
+			value(ops[1]), inst.Next(), local(ops[0]))
+	case gvm.STORE:
+		check(ops[0], "Number")
+		fail(fmt.Sprintf("err := rt.Store(stack, heap, sp, %s, %q, %s); err != nil", value(ops[0]), ops[1], value(ops[1])), "err")
+	case gvm.ADD, gvm.SUB, gvm.MUL, gvm.DIV:
+		g.printf("if v, f, err := rt.Arith(gvm.%s, %q, %s, %s, flags); err != nil {\nreturn regs(%d), err\n} else {\n%s, flags = v, f\n}\n",
+			opName(op), ops[0], local(ops[0]), value(ops[1]), inst.Next(), local(ops[0]))
+	case gvm.CMP:
+		g.printf("if f, err := rt.Cmp(%q, %q, %s, %s, flags); err != nil {\nreturn regs(%d), err\n} else {\nflags = f\n}\n",
+			ops[0], ops[1], value(ops[0]), value(ops[1]), inst.Next())
+	case gvm.EQ, gvm.NE, gvm.LT, gvm.LE:
+		g.printf("if f, err := rt.Compare(gvm.%s, %q, %q, %s, %s, flags); err != nil {\nreturn regs(%d), err\n} else {\nflags = f\n}\n",
+			opName(op), ops[0], ops[1], value(ops[0]), value(ops[1]), inst.Next())
+	case gvm.JMP:
+		g.printf("pc = %d\ncontinue\n", gvm.Target(inst.At, ops[0]))
+		return true
+	case gvm.CALL:
+		g.printf("if s, err := rt.Call(stack, sp, bp, %d); err != nil {\nreturn regs(%d), err\n} else {\nbp, sp = s, s\n}\n",
+			inst.Next(), inst.At)
+		g.printf("pc = %d\ncontinue\n", gvm.Target(inst.At, ops[0]))
+		return true
+	case gvm.RET:
+		g.printf("if ret, b, s, err := rt.Ret(stack, bp); err != nil {\nsp = s\nreturn regs(%d), err\n} else {\npc, bp, sp = ret, b, s\n}\ncontinue\n", inst.At)
+		return true
+	default:
+		g.printf("if %s {\npc = %d\ncontinue\n}\n", conditions[op], gvm.Target(inst.At, ops[0]))
+	}
+	return false
+}
+
+// opName gvmパッケージでの定数名
+func opName(op gvm.Opcode) string {
+	return strings.ToUpper(op.String())
+}
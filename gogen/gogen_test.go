@@ -0,0 +1,188 @@
+package gogen
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/x0y14/gvm"
+	"github.com/x0y14/gvm/internal/sample"
+)
+
+// programs 生成したコードとインタプリタで結果を比べるProgram
+var programs = slices.Concat(sample.Integers, sample.Floats, sample.Faults)
+
+const mainSrc = `package main
+
+import (
+	"fmt"
+
+	"github.com/x0y14/gvm"
+)
+
+func main() {
+	for _, fn := range funcs {
+		regs, err := fn(make([]gvm.Stockable, %d), make([]gvm.Stockable, %d))
+		for _, reg := range []gvm.Register{gvm.PC, gvm.BP, gvm.SP, gvm.HP, gvm.ZF, gvm.SF, gvm.CF, gvm.OF,
+			gvm.R0, gvm.R1, gvm.R2, gvm.R3, gvm.ACM1, gvm.ACM2} {
+			fmt.Print(regs[reg], " ")
+		}
+		fmt.Println(err)
+	}
+}
+`
+
+func TestGenerate_Run(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated code")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	root, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum, err := os.ReadFile(filepath.Join(root, "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("go.sum", string(sum))
+	write("go.mod", fmt.Sprintf("module gen\n\ngo 1.25\n\nrequire github.com/x0y14/gvm v0.0.0\n\nreplace github.com/x0y14/gvm => %s\n", root))
+	write("main.go", fmt.Sprintf(mainSrc, sample.Config.StackSize, sample.Config.HeapSize))
+
+	var expect []string
+	var funcs []string
+	for i, p := range programs {
+		program := p.Compile(t)
+		name := fmt.Sprintf("P%d", i)
+		src, err := Generate(program, Options{Package: "main", Func: name})
+		if err != nil {
+			t.Fatalf("%s: %v", p.Name, err)
+		}
+		write(strings.ToLower(name)+".go", string(src))
+		funcs = append(funcs, name)
+
+		r := gvm.NewRuntime(program, sample.Config)
+		err = r.Run()
+		var line strings.Builder
+		for _, reg := range sample.Order {
+			fmt.Fprint(&line, r.Register(reg), " ")
+		}
+		fmt.Fprint(&line, err)
+		expect = append(expect, line.String())
+	}
+	write("funcs.go", fmt.Sprintf("package main\n\nimport \"github.com/x0y14/gvm\"\n\nvar funcs = []func(stack, heap []gvm.Stockable) (map[gvm.Register]gvm.Operand, error){%s}\n",
+		strings.Join(funcs, ", ")))
+
+	cmd := exec.Command(goBin, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go run: %v\n%s", err, out)
+	}
+	got := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	for i, p := range programs {
+		if diff := cmp.Diff(expect[i], got[i]); diff != "" {
+			t.Errorf("%s: diff: %s", p.Name, diff)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	program := sample.Program{Src: "mov r1, 1\nadd r1, 2"}.Compile(t)
+	src, err := Generate(program, Options{Package: "gen", Func: "Add"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `// Code generated by gogen. DO NOT EDIT.
+
+package gen
+
+import (
+	"github.com/x0y14/gvm"
+	"github.com/x0y14/gvm/gogen/rt"
+)
+
+func Add(stack, heap []gvm.Stockable) (map[gvm.Register]gvm.Operand, error) {
+	var r0 gvm.Operand
+	var r1 gvm.Operand
+	var r2 gvm.Operand
+	var r3 gvm.Operand
+	var r4 gvm.Operand
+	var r5 gvm.Operand
+	var flags rt.Flags
+	pc, bp, sp, hp := 0, 0, len(stack)-1, 0
+	regs := func(pc int) map[gvm.Register]gvm.Operand {
+		return map[gvm.Register]gvm.Operand{
+			gvm.PC: gvm.ProgramAddress(pc), gvm.BP: gvm.BasePointer(bp), gvm.SP: gvm.StackPointer(sp), gvm.HP: gvm.HeapAddress(hp),
+			gvm.ZF: gvm.Bool(flags.ZF), gvm.SF: gvm.Bool(flags.SF), gvm.CF: gvm.Bool(flags.CF), gvm.OF: gvm.Bool(flags.OF),
+			gvm.GeneralPurposeRegister(0): r0,
+			gvm.GeneralPurposeRegister(1): r1,
+			gvm.GeneralPurposeRegister(2): r2,
+			gvm.GeneralPurposeRegister(3): r3,
+			gvm.GeneralPurposeRegister(4): r4,
+			gvm.GeneralPurposeRegister(5): r5,
+		}
+	}
+	for {
+		switch pc {
+		case 0:
+			r1 = gvm.Integer(1)
+			if v, f, err := rt.Arith(gvm.ADD, "r1", r1, gvm.Integer(2), flags); err != nil {
+				return regs(6), err
+			} else {
+				r1, flags = v, f
+			}
+			fallthrough
+		case 6:
+			return regs(6), nil
+		default:
+			if 6 < pc {
+				return regs(pc), nil
+			}
+			return regs(pc), rt.InvalidTarget(pc)
+		}
+	}
+}
+`
+	if diff := cmp.Diff(expect, string(src)); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func TestGenerate_Error(t *testing.T) {
+	tests := []struct {
+		name    string
+		program gvm.Program
+		expect  string
+	}{
+		{"opcode", gvm.Program{gvm.NOP, gvm.YIELD}, "gogen: 1: unsupported opcode: yield"},
+		{"special register", gvm.Program{gvm.MOV, gvm.R1, gvm.SP}, "gogen: 0: unsupported operand: sp"},
+		{"flag register", gvm.Program{gvm.MOV, gvm.ZF, gvm.Bool(true)}, "gogen: 0: unsupported operand: zf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Generate(tt.program, Options{Package: "gen", Func: "F"})
+			if err == nil || err.Error() != tt.expect {
+				t.Errorf("want=%s, got=%v", tt.expect, err)
+			}
+		})
+	}
+	if _, err := Generate(gvm.Program{gvm.MOV, gvm.R1}, Options{Package: "gen", Func: "F"}); err == nil {
+		t.Error("want verify error")
+	}
+}
@@ -0,0 +1,292 @@
+package rt
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/x0y14/gvm"
+)
+
+// Flags ZF, SF, CF, OF
+type Flags struct {
+	ZF, SF, CF, OF bool
+}
+
+// Unordered NaNとの比較の結果か. gvmと同じくZFとSFが同時に立っていれば順序なし.
+// Compareは他のフラグを下ろすので, 前の演算のSFは残らない
+func (f Flags) Unordered() bool {
+	return f.ZF && f.SF
+}
+
+var operators = map[gvm.Opcode]string{
+	gvm.ADD: "+",
+	gvm.SUB: "-",
+	gvm.MUL: "*",
+	gvm.DIV: "/",
+	gvm.EQ:  "==",
+	gvm.NE:  "!=",
+	gvm.LT:  "<",
+	gvm.LE:  "<=",
+}
+
+// sameType 同じ型の即値どうしか
+func sameType(x, y gvm.Operand) bool {
+	a, ok := x.(gvm.Immediate)
+	if !ok {
+		return false
+	}
+	b, ok := y.(gvm.Immediate)
+	return ok && a.Type() == b.Type()
+}
+
+// Float ビット列から浮動小数点数の即値を作る. NaNと無限大の定数に使う
+func Float(bits uint64) gvm.Float {
+	return gvm.Float(math.Float64frombits(bits))
+}
+
+func overflow() error {
+	return fmt.Errorf("stack: %w: overflow", gvm.ErrOutOfBounds)
+}
+
+// Push SPを1つ下げて値を置く. 新しいSPを返す
+func Push(stack []gvm.Stockable, sp int, v gvm.Stockable) (int, error) {
+	if sp <= 0 {
+		return sp, overflow()
+	}
+	sp--
+	stack[sp] = v
+	return sp, nil
+}
+
+// Pop SPの位置の値を取り出して空にする
+func Pop(stack []gvm.Stockable, sp int) (gvm.Stockable, int, error) {
+	if sp < 0 || len(stack) <= sp {
+		return nil, sp, fmt.Errorf("stack: %w: underflow", gvm.ErrOutOfBounds)
+	}
+	v := stack[sp]
+	stack[sp] = nil
+	return v, sp + 1, nil
+}
+
+// Call 戻り先とBPを積む. 新しいBP(SPと同じ)を返す
+func Call(stack []gvm.Stockable, sp, bp, ret int) (int, error) {
+	if sp < 2 {
+		return sp, overflow()
+	}
+	stack[sp-1] = gvm.ProgramAddress(ret)
+	stack[sp-2] = gvm.BasePointer(bp)
+	return sp - 2, nil
+}
+
+// Slot BP, SPからのオフセットがスタックの中か. atは位置, nameはオフセットの表記
+func Slot(stack []gvm.Stockable, at int, name string) error {
+	if at < 0 || len(stack) <= at {
+		return fmt.Errorf("stack: %w: %s, at=%d", gvm.ErrOutOfBounds, name, at)
+	}
+	return nil
+}
+
+// Stockable レジスタの値をスタック, ヒープに置けるか. nameはレジスタの表記
+func Stockable(op gvm.Opcode, name string, v gvm.Operand) error {
+	if _, ok := v.(gvm.Stockable); !ok {
+		return fmt.Errorf("%w: %s %s: %v", gvm.ErrTypeMismatch, op, name, v)
+	}
+	return nil
+}
+
+// Number レジスタの値をALLOCの大きさ, LOAD, STOREのアドレスに使えるか
+func Number(op gvm.Opcode, name string, v gvm.Operand) error {
+	if v == nil {
+		return fmt.Errorf("%w: %s %s: %v", gvm.ErrTypeMismatch, op, name, nil)
+	}
+	return nil
+}
+
+// Arith ADD, SUB, MUL, DIV dst, src. xはdstの値, yはsrcの値. エラーならx, fをそのまま返す
+func Arith(op gvm.Opcode, dst string, x, y gvm.Operand, f Flags) (gvm.Operand, Flags, error) {
+	if !sameType(x, y) {
+		return x, f, fmt.Errorf("%w: %s %s %v", gvm.ErrTypeMismatch, dst, operators[op], y)
+	}
+	switch x := x.(type) {
+	case gvm.Integer:
+		y := y.(gvm.Integer)
+		var z gvm.Integer
+		switch op {
+		case gvm.ADD:
+			z = x + y
+		case gvm.SUB:
+			z = x - y
+		case gvm.MUL:
+			z = x * y
+		case gvm.DIV:
+			if y == 0 {
+				return x, f, fmt.Errorf("%w: %s / %v", gvm.ErrDivisionByZero, dst, y)
+			}
+			z = x / y
+		}
+		return z, intFlags(op, x, y, z), nil
+	case gvm.Float:
+		y := y.(gvm.Float)
+		var z gvm.Float
+		switch op {
+		case gvm.ADD:
+			z = x + y
+		case gvm.SUB:
+			z = x - y
+		case gvm.MUL:
+			z = x * y
+		case gvm.DIV:
+			z = x / y
+		}
+		return z, Flags{ZF: z == 0, SF: z < 0}, nil
+	default:
+		return x, f, fmt.Errorf("%w: invalid %s value: %s", gvm.ErrTypeMismatch, op.String(), dst)
+	}
+}
+
+func intFlags(op gvm.Opcode, x, y, z gvm.Integer) Flags {
+	f := Flags{ZF: z == 0, SF: z < 0}
+	switch op {
+	case gvm.ADD:
+		f.CF = uint64(z) < uint64(x)
+		f.OF = (x < 0) == (y < 0) && (z < 0) != (x < 0)
+	case gvm.SUB, gvm.CMP:
+		f.CF = uint64(x) < uint64(y)
+		f.OF = (x < 0) != (y < 0) && (z < 0) != (x < 0)
+	case gvm.MUL:
+		overflow := x != 0 && (z/x != y || x == -1 && y == math.MinInt)
+		f.CF, f.OF = overflow, overflow
+	case gvm.DIV:
+		f.OF = x == math.MinInt && y == -1
+	}
+	return f
+}
+
+// Cmp CMP o1, o2. n1, n2はオペランドの表記, x, yはその値
+func Cmp(n1, n2 string, x, y gvm.Operand, f Flags) (Flags, error) {
+	if !sameType(x, y) {
+		return f, fmt.Errorf("%w: cmp %s, %s", gvm.ErrTypeMismatch, n1, n2)
+	}
+	if fx, ok := x.(gvm.Float); ok {
+		fy := y.(gvm.Float)
+		if math.IsNaN(float64(fx)) || math.IsNaN(float64(fy)) {
+			return Flags{ZF: true, SF: true, CF: true, OF: true}, nil
+		}
+		return Flags{ZF: fx == fy, SF: fx < fy, CF: fx < fy}, nil
+	}
+	a, b := gvm.Integer(x.Value()), gvm.Integer(y.Value())
+	return intFlags(gvm.CMP, a, b, a-b), nil
+}
+
+// Compare EQ, NE, LT, LE o1, o2. 結果はZFに入り, SF, CF, OFは下ろす
+func Compare(op gvm.Opcode, n1, n2 string, x, y gvm.Operand, flags Flags) (Flags, error) {
+	if !sameType(x, y) {
+		return flags, fmt.Errorf("%w: %s %s %s", gvm.ErrTypeMismatch, n1, operators[op], n2)
+	}
+	if f1, ok := x.(gvm.Float); ok {
+		f2 := y.(gvm.Float)
+		switch op {
+		case gvm.EQ:
+			return Flags{ZF: f1 == f2}, nil
+		case gvm.NE:
+			return Flags{ZF: f1 != f2}, nil
+		case gvm.LT:
+			return Flags{ZF: f1 < f2}, nil
+		default:
+			return Flags{ZF: f1 <= f2}, nil
+		}
+	}
+	v1, v2 := x.Value(), y.Value()
+	switch op {
+	case gvm.EQ:
+		return Flags{ZF: v1 == v2}, nil
+	case gvm.NE:
+		return Flags{ZF: v1 != v2}, nil
+	case gvm.LT:
+		return Flags{ZF: v1 < v2}, nil
+	default:
+		return Flags{ZF: v1 <= v2}, nil
+	}
+}
+
+// Ret RETでフレームを戻す. 戻り先, BP, SPを返す
+func Ret(stack []gvm.Stockable, bp int) (int, int, int, error) {
+	sp := bp
+	v, sp, err := Pop(stack, sp)
+	prev, ok := v.(gvm.BasePointer)
+	if err != nil || !ok {
+		return 0, bp, sp, fmt.Errorf("ret: broken frame: %s", gvm.BasePointer(bp))
+	}
+	v, sp, err = Pop(stack, sp)
+	ret, ok := v.(gvm.ProgramAddress)
+	if err != nil || !ok {
+		return 0, bp, sp, fmt.Errorf("ret: broken frame: %s", gvm.BasePointer(bp))
+	}
+	return ret.Value(), prev.Value(), sp, nil
+}
+
+// Alloc ヒープをsizeだけ確保し, 先頭のアドレスを積む. HPとSPを返す
+func Alloc(stack []gvm.Stockable, heap []gvm.Stockable, sp, hp, size int) (int, int, error) {
+	if len(heap) <= hp+size {
+		return hp, sp, fmt.Errorf("heap: %w: out of memory", gvm.ErrOutOfBounds)
+	}
+	sp, err := Push(stack, sp, gvm.HeapAddress(hp))
+	if err != nil {
+		return hp, sp, err
+	}
+	return hp + size, sp, nil
+}
+
+func stackSlot(stack []gvm.Stockable, sp int, addr gvm.StackAddress) (int, error) {
+	if addr.Value() < sp || len(stack) <= addr.Value() {
+		return 0, fmt.Errorf("stack: %w: %s, sp=%d", gvm.ErrOutOfBounds, addr, gvm.StackPointer(sp))
+	}
+	return addr.Value(), nil
+}
+
+// Load LOAD dst, src. addrはsrcの値
+func Load(stack, heap []gvm.Stockable, sp int, addr gvm.Operand) (gvm.Operand, error) {
+	if a, ok := addr.(gvm.StackAddress); ok {
+		at, err := stackSlot(stack, sp, a)
+		if err != nil {
+			return nil, err
+		}
+		return stack[at], nil
+	}
+	at := addr.Value()
+	if at < 0 || len(heap) <= at {
+		return nil, fmt.Errorf("heap: %w: %d", gvm.ErrOutOfBounds, at)
+	}
+	return heap[at], nil
+}
+
+// Store STORE dst, src. addrはdstの値, vはsrcの値, nameはsrcの表記
+func Store(stack, heap []gvm.Stockable, sp int, addr gvm.Operand, name string, v gvm.Operand) error {
+	if a, ok := addr.(gvm.StackAddress); ok {
+		at, err := stackSlot(stack, sp, a)
+		if err != nil {
+			return err
+		}
+		s, ok := v.(gvm.Stockable)
+		if !ok {
+			return fmt.Errorf("unsupported store src: %s", name)
+		}
+		stack[at] = s
+		return nil
+	}
+	at := addr.Value()
+	if at < 0 || len(heap) <= at {
+		return fmt.Errorf("heap: %w: %d", gvm.ErrOutOfBounds, at)
+	}
+	s, ok := v.(gvm.Stockable)
+	if !ok {
+		return fmt.Errorf("%w: store %s: %v", gvm.ErrTypeMismatch, name, v)
+	}
+	heap[at] = s
+	return nil
+}
+
+// InvalidTarget 命令の先頭として生成していない位置へ戻ろうとした
+func InvalidTarget(pc int) error {
+	return fmt.Errorf("invalid program address: %d", pc)
+}
@@ -0,0 +1,250 @@
+package gvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Native Goで実装された関数. 呼び出し規約はCALLと同じで, 引数はスタックに積まれ戻り値はR1に入る
+type Native struct {
+	Name  string
+	Arity int
+	Fn    func(args []Stockable) (Stockable, error)
+}
+
+// Host Runtimeの外とのやりとり
+type Host struct {
+	Natives []Native      // NCALLで呼び出す関数
+	Input   io.RuneReader // INで読み込む入力. 無ければ常にEOF
+}
+
+type EventKind int
+
+const (
+	_ EventKind = iota
+	EventNative
+	EventInput
+)
+
+func (k EventKind) String() string {
+	return []string{
+		EventNative: "native",
+		EventInput:  "input",
+	}[k]
+}
+
+// Event 実行ごとに結果が変わりうる, Hostとの一回のやりとり
+type Event struct {
+	Step  uint64 // 何命令目で起きたか
+	Kind  EventKind
+	Value Stockable
+	Err   string
+}
+
+// Tape 記録されたEventの列
+type Tape struct {
+	Events []Event
+}
+
+// DivergenceError 再生中に記録と異なるやりとりが起きた
+type DivergenceError struct {
+	Step   uint64
+	Kind   EventKind // 停止した場合は0
+	Expect *Event    // 記録が尽きている場合はnil
+}
+
+func (e *DivergenceError) Error() string {
+	if e.Expect == nil {
+		return fmt.Sprintf("replay: diverged at step %d: unexpected %s", e.Step, e.Kind)
+	}
+	if e.Kind == 0 {
+		return fmt.Sprintf("replay: diverged at step %d: halted, want %s at step %d", e.Step, e.Expect.Kind, e.Expect.Step)
+	}
+	return fmt.Sprintf("replay: diverged at step %d: got %s, want %s at step %d", e.Step, e.Kind, e.Expect.Kind, e.Expect.Step)
+}
+
+type session struct {
+	host   *Host
+	steps  uint64
+	record *Tape
+	replay *Tape
+	next   int
+}
+
+func (r *Runtime) session() *session {
+	if r.host == nil {
+		r.host = &session{host: &Host{}}
+	}
+	return r.host
+}
+
+// Attach NCALL, INが使うHostを設定する
+func (r *Runtime) Attach(host *Host) {
+	r.session().host = host
+}
+
+// Record 以降のHostとのやりとりをTapeに記録する
+func (r *Runtime) Record() *Tape {
+	s := r.session()
+	s.record, s.replay = &Tape{}, nil
+	return s.record
+}
+
+// Replay Hostを呼ぶ代わりにTapeの内容を返し, 記録と食い違えばDivergenceErrorにする
+func (r *Runtime) Replay(tape *Tape) {
+	s := r.session()
+	s.record, s.replay, s.next = nil, tape, 0
+}
+
+// interact Hostとのやりとりを一回行う. 再生中はTapeから, 記録中は結果をTapeに残す
+func (s *session) interact(kind EventKind, do func() (Stockable, error)) (Stockable, error) {
+	if s.replay != nil {
+		if len(s.replay.Events) <= s.next {
+			return nil, &DivergenceError{Step: s.steps, Kind: kind}
+		}
+		ev := &s.replay.Events[s.next]
+		if ev.Kind != kind || ev.Step != s.steps {
+			return nil, &DivergenceError{Step: s.steps, Kind: kind, Expect: ev}
+		}
+		s.next++
+		if ev.Err != "" {
+			return nil, errors.New(ev.Err)
+		}
+		return ev.Value, nil
+	}
+	v, err := do()
+	if s.record != nil {
+		ev := Event{Step: s.steps, Kind: kind, Value: v}
+		if err != nil {
+			ev.Err = err.Error()
+		}
+		s.record.Events = append(s.record.Events, ev)
+	}
+	return v, err
+}
+
+// finish 再生し残したやりとりがあれば食い違いとする
+func (s *session) finish() error {
+	if s.replay != nil && s.next < len(s.replay.Events) {
+		ev := &s.replay.Events[s.next]
+		return &DivergenceError{Step: s.steps, Expect: ev}
+	}
+	return nil
+}
+
+func (r *Runtime) ncall(index int) error {
+	s := r.session()
+	if index < 0 || len(s.host.Natives) <= index {
+		if s.replay == nil {
+			return fmt.Errorf("ncall: unknown native: %d", index)
+		}
+	}
+	v, err := s.interact(EventNative, func() (Stockable, error) {
+		native := s.host.Natives[index]
+		if len(r.stack) < r.sp().Value()+native.Arity {
+			return nil, fmt.Errorf("%s: not enough arguments", native.Name)
+		}
+		args := make([]Stockable, native.Arity)
+		for i := range args {
+			args[i] = r.stack[r.sp().Value()+native.Arity-1-i].stock()
+		}
+		return native.Fn(args)
+	})
+	if err != nil {
+		return fmt.Errorf("ncall: %d: %w", index, err)
+	}
+	r.set(R1, v)
+	return nil
+}
+
+func (r *Runtime) in(dst Register) error {
+	s := r.session()
+	v, err := s.interact(EventInput, func() (Stockable, error) {
+		if s.host.Input == nil {
+			return Char(-1), nil
+		}
+		c, _, err := s.host.Input.ReadRune()
+		if err == io.EOF {
+			return Char(-1), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return Char(c), nil
+	})
+	if err != nil {
+		return fmt.Errorf("in: %w", err)
+	}
+	r.set(dst, v)
+	return nil
+}
+
+const tapeVersion = 1
+
+// MarshalBinary Tapeを保存できる形にする
+func (t *Tape) MarshalBinary() ([]byte, error) {
+	buf := []byte{tapeVersion}
+	buf = binary.AppendUvarint(buf, uint64(len(t.Events)))
+	for _, ev := range t.Events {
+		buf = binary.AppendUvarint(buf, ev.Step)
+		buf = binary.AppendUvarint(buf, uint64(ev.Kind))
+		var err error
+		if buf, err = appendWord(buf, ev.Value); err != nil {
+			return nil, err
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(ev.Err)))
+		buf = append(buf, ev.Err...)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary MarshalBinaryで保存したTapeを読み込む
+func (t *Tape) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || data[0] != tapeVersion {
+		return errors.New("tape: unsupported version")
+	}
+	rd := bytes.NewReader(data[1:])
+	n, err := readLength(rd)
+	if err != nil {
+		return err
+	}
+	events := make([]Event, n)
+	for i := range events {
+		if events[i].Step, err = binary.ReadUvarint(rd); err != nil {
+			return err
+		}
+		kind, err := binary.ReadUvarint(rd)
+		if err != nil {
+			return err
+		}
+		events[i].Kind = EventKind(kind)
+		w, err := readWord(rd)
+		if err != nil {
+			return err
+		}
+		if w != nil {
+			v, ok := w.(Stockable)
+			if !ok {
+				return fmt.Errorf("tape: not stockable: %s", w.String())
+			}
+			events[i].Value = v
+		}
+		size, err := readLength(rd)
+		if err != nil {
+			return err
+		}
+		msg := make([]byte, size)
+		if _, err := io.ReadFull(rd, msg); err != nil {
+			return err
+		}
+		events[i].Err = string(msg)
+	}
+	if rd.Len() != 0 {
+		return errors.New("tape: trailing data")
+	}
+	t.Events = events
+	return nil
+}
@@ -0,0 +1,158 @@
+package gvm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func counterHost(input string) *Host {
+	n := 0
+	return &Host{
+		Natives: []Native{
+			{
+				Name:  "next",
+				Arity: 1,
+				Fn: func(args []Stockable) (Stockable, error) {
+					n++
+					return Integer(args[0].Value() + n), nil
+				},
+			},
+			{
+				Name: "fail",
+				Fn: func(args []Stockable) (Stockable, error) {
+					return nil, errors.New("boom")
+				},
+			},
+		},
+		Input: strings.NewReader(input),
+	}
+}
+
+func TestRuntime_Host(t *testing.T) {
+	prog := Program{
+		IN, R2,
+		PUSH, R2,
+		NCALL, Integer(0),
+		NCALL, Integer(0),
+		POP, R3,
+		IN, R2,
+		IN, R3,
+	}
+	r := NewRuntime(prog, &Config{StackSize: 2})
+	r.Attach(counterHost("a"))
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Integer('a'+2), r.Register(R1)); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if diff := cmp.Diff(Char(-1), r.Register(R3)); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+
+	r = NewRuntime(Program{NCALL, Integer(1)}, &Config{StackSize: 2})
+	r.Attach(counterHost(""))
+	if err := r.Run(); err == nil || err.Error() != "ncall: 1: boom" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRuntime_Replay(t *testing.T) {
+	prog := Program{
+		IN, R2,
+		PUSH, R2,
+		NCALL, Integer(0),
+		POP, R3,
+		IN, R2,
+		NCALL, Integer(1),
+	}
+	expect := NewRuntime(prog, &Config{StackSize: 2})
+	expect.Attach(counterHost("xy"))
+	tape := expect.Record()
+	expectErr := expect.Run()
+	if expectErr == nil {
+		t.Fatal("expect error")
+	}
+	if diff := cmp.Diff([]EventKind{EventInput, EventNative, EventInput, EventNative}, kinds(tape)); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+
+	// 保存したTapeを読み込み, Hostなしで同じ結果を再現する
+	data, err := tape.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded Tape
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(tape, &loaded); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	r := NewRuntime(prog, &Config{StackSize: 2})
+	r.Replay(&loaded)
+	err = r.Run()
+	if err == nil || err.Error() != expectErr.Error() {
+		t.Errorf("want=%v, got=%v", expectErr, err)
+	}
+	if diff := cmp.Diff(registerMap(expect.registers), registerMap(r.registers)); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if diff := cmp.Diff(stockables(expect.stack), stockables(r.stack)); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func TestRuntime_Replay_Divergence(t *testing.T) {
+	prog := Program{
+		IN, R2,
+		IN, R3,
+	}
+	r := NewRuntime(prog, &Config{StackSize: 2})
+	r.Attach(counterHost("ab"))
+	tape := r.Record()
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		prog   Program
+		expect string
+	}{
+		{"same", prog, ""},
+		{"shifted", Program{IN, R2, NOP, IN, R3}, "in: replay: diverged at step 2: got input, want input at step 1"},
+		{"kind", Program{IN, R2, NCALL, Integer(0)}, "ncall: 0: replay: diverged at step 1: got native, want input at step 1"},
+		{"exhausted", Program{IN, R2, IN, R3, IN, R1}, "in: replay: diverged at step 2: unexpected input"},
+		{"halted", Program{IN, R2}, "replay: diverged at step 1: halted, want input at step 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRuntime(tt.prog, &Config{StackSize: 2})
+			r.Replay(tape)
+			err := r.Run()
+			got := ""
+			if err != nil {
+				got = err.Error()
+				var divergence *DivergenceError
+				if !errors.As(err, &divergence) {
+					t.Errorf("not divergence: %v", err)
+				}
+			}
+			if got != tt.expect {
+				t.Errorf("want=%q, got=%q", tt.expect, got)
+			}
+		})
+	}
+}
+
+func kinds(tape *Tape) []EventKind {
+	var kinds []EventKind
+	for _, ev := range tape.Events {
+		kinds = append(kinds, ev.Kind)
+	}
+	return kinds
+}
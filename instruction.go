@@ -0,0 +1,58 @@
+package gvm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Instruction Program上の命令. Atはopcodeの位置で, OperandsはProgramと同じ領域を指す
+type Instruction struct {
+	At       int
+	Op       Opcode
+	Operands []Word
+}
+
+func (i Instruction) String() string {
+	s := make([]string, len(i.Operands))
+	for k, w := range i.Operands {
+		s[k] = w.String()
+	}
+	if len(s) == 0 {
+		return i.Op.String()
+	}
+	return i.Op.String() + " " + strings.Join(s, ", ")
+}
+
+// Next フォールスルー先
+func (i Instruction) Next() int {
+	return i.At + 1 + len(i.Operands)
+}
+
+// Errorf 命令の位置を先頭に付けたエラー
+func (i Instruction) Errorf(format string, args ...any) error {
+	return fmt.Errorf("%d: %s", i.At, fmt.Sprintf(format, args...))
+}
+
+// Instructions Programを命令に分ける. Verifyを通ったProgramに使う
+func Instructions(program Program) []Instruction {
+	var insts []Instruction
+	for at := 0; at < len(program); {
+		op := program[at].(Opcode)
+		n := op.NumOperands()
+		insts = append(insts, Instruction{At: at, Op: op, Operands: program[at+1 : at+1+n]})
+		at += 1 + n
+	}
+	return insts
+}
+
+// Target ジャンプ先の絶対アドレス. ProgramOffsetはopcodeの位置atからの相対. 飛び先でなければ-1
+func Target(at int, w Word) int {
+	switch op := w.(type) {
+	case ProgramAddress:
+		return op.Value()
+	case ProgramOffset:
+		return at + op.Value()
+	default:
+		return -1
+	}
+}
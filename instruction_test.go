@@ -0,0 +1,41 @@
+package gvm
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInstructions(t *testing.T) {
+	program := Program{MOV, R1, Integer(1), RET, JMP, ProgramOffset(-4), CALL, ProgramAddress(3)}
+	var got []string
+	for _, inst := range Instructions(program) {
+		got = append(got, inst.String())
+	}
+	expect := []string{"mov r1, 1", "ret", "jmp -4", "call @3"}
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+
+	insts := Instructions(program)
+	tests := []struct {
+		inst   Instruction
+		next   int
+		target int
+	}{
+		{insts[0], 3, -1},
+		{insts[2], 6, 0},
+		{insts[3], 8, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.inst.String(), func(t *testing.T) {
+			target := Target(tt.inst.At, tt.inst.Operands[0])
+			if diff := cmp.Diff([]int{tt.next, tt.target}, []int{tt.inst.Next(), target}); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+	if err := insts[1].Errorf("bad %s", "thing"); err.Error() != "3: bad thing" {
+		t.Errorf("got=%v", err)
+	}
+}
@@ -0,0 +1,177 @@
+// Package sample gogen, wat, cgenのテストで共有するProgram
+package sample
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/x0y14/gvm"
+	"github.com/x0y14/gvm/minic"
+)
+
+// Update testdataのゴールデンファイルを書き直す
+var Update = flag.Bool("update", false, "testdataのゴールデンファイルを書き直す")
+
+// Config テストで実行するときのスタックとヒープの大きさ
+var Config = &gvm.Config{StackSize: 64, HeapSize: 16}
+
+// Order 結果を並べるレジスタの順
+var Order = []gvm.Register{gvm.PC, gvm.BP, gvm.SP, gvm.HP, gvm.ZF, gvm.SF, gvm.CF, gvm.OF,
+	gvm.R0, gvm.R1, gvm.R2, gvm.R3, gvm.ACM1, gvm.ACM2}
+
+type Program struct {
+	Name   string
+	Src    string
+	C      bool        // minicのソース
+	Direct gvm.Program // Srcの代わりに直接書くProgram
+}
+
+// Compile アセンブルかminicのコンパイルをする
+func (p Program) Compile(t testing.TB) gvm.Program {
+	t.Helper()
+	if p.Direct != nil {
+		return p.Direct
+	}
+	var program gvm.Program
+	var err error
+	if p.C {
+		program, err = minic.Compile(p.Src)
+	} else {
+		program, err = gvm.Assemble(p.Src)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return program
+}
+
+// Find 名前でProgramを探す
+func Find(t testing.TB, programs []Program, name string) Program {
+	t.Helper()
+	for _, p := range programs {
+		if p.Name == name {
+			return p
+		}
+	}
+	t.Fatalf("sample: %s not found", name)
+	return Program{}
+}
+
+// Integers Integerだけを使うProgram. どのバックエンドでも動く
+var Integers = []Program{
+	{Name: "empty"},
+	{Name: "arith", Src: `
+mov r1, 7
+mul r1, 6
+sub r1, 2
+div r1, 3
+mov r3, -9223372036854775807
+sub r3, 2
+`},
+	{Name: "stack", Src: `
+push 1
+push 2
+push [sp+1]
+pop r1
+pop r2
+mov [sp+0], r1
+mov r3, [sp+0]
+mov [sp+0], [sp+0]
+`},
+	{Name: "loop", Src: `
+    mov r1, 0
+    mov r2, 0
+loop:
+    add r2, r1
+    add r1, 1
+    cmp r1, 10
+    jl loop
+    lt r1, 20
+`},
+	{Name: "compare", Src: `
+    mov r1, 0
+    sub r1, 5
+    mov r2, 0
+    eq r1, r1
+    je yes
+    mov r2, 1
+    jmp end
+yes:
+    mov r2, 2
+end:
+`},
+	{Name: "heap", Src: `
+alloc 3
+pop r1
+store r1, 5
+store @1, 7
+load r2, r1
+load r3, 1
+alloc r2
+pop r4
+`},
+	{Name: "fib", Src: `
+int fib(int n) {
+    if (n < 2) { return n }
+    return fib(n - 1) + fib(n - 2)
+}
+int main() { return fib(12) }`, C: true},
+	{Name: "gcd", Src: `
+int gcd(int a, int b) {
+    while (b != 0) {
+        int t = b
+        b = a % b
+        a = t
+    }
+    return a
+}
+int main() { return gcd(1071, 462) * 1000 + gcd(10, 4) }`, C: true},
+}
+
+// Floats Floatを使うProgram
+var Floats = []Program{
+	{Name: "float arith", Src: "mov r2, 1.5\nadd r2, 2.25\nmul r2, -2.0\ndiv r2, 0.5"},
+	{Name: "float heap", Src: "alloc 1\npop r1\nstore r1, 1.5\nload r2, r1"},
+	{Name: "flags", Src: `
+    mov r1, 0
+    cmp r1, 1
+    jae unsigned
+    mov r2, 1
+unsigned:
+    cmp 1.0, 2.0
+    jbe below
+    mov r3, 1
+below:
+    eq 1.5, 1.5
+`},
+	{Name: "unordered", Src: unordered()},
+}
+
+// Faults 実行時エラーで止まるProgram
+var Faults = []Program{
+	{Name: "division by zero", Src: "mov r1, 1\ndiv r1, 0\nmov r2, 1"},
+	{Name: "typemismatch", Src: "mov r1, 1\nmov r2, 1.5\nadd r1, r2"},
+	{Name: "cmp typemismatch", Src: "mov r1, 1\ncmp r1, true"},
+	{Name: "invalid value", Src: "mov r1, true\nmov r2, false\nadd r1, r2"},
+	{Name: "heap out of bounds", Src: "load r1, @100"},
+	{Name: "broken frame", Src: "push 1\npush 2\nmov r1, 1\ncall f\nf:\nmov [bp+0], 3\nret"},
+	{Name: "stack overflow", Src: "f:\ncall f"},
+	{Name: "out of memory", Src: "alloc 16"},
+	{Name: "offset out of bounds", Src: "mov r1, [bp+100]"},
+	{Name: "nil push", Src: "push r1"},
+	{Name: "nil alloc", Src: "alloc r1"},
+	{Name: "nil address", Src: "load r2, r1"},
+	{Name: "nil store", Src: "mov r1, @0\nstore r1, r2"},
+}
+
+// unordered NaNとの比較のあと, 飛んだ条件付きジャンプのビットをr1に立てる
+func unordered() string {
+	var sb strings.Builder
+	sb.WriteString("mov r2, 0.0\ndiv r2, 0.0\nmov r1, 0\n")
+	for i, j := range []string{"je", "jne", "jl", "jle", "jg", "jge", "jb", "jbe", "ja", "jae", "jo", "jno", "js", "jns"} {
+		fmt.Fprintf(&sb, "cmp r2, 1.0\n%s t%d\njmp n%d\nt%d:\nadd r1, %d\nn%d:\n", j, i, i, i, 1<<i, i)
+	}
+	return sb.String()
+}
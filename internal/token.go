@@ -22,6 +22,7 @@ const (
 
 	Identifier
 	Integer
+	Float
 	String
 
 	Lrb // (
@@ -36,6 +37,23 @@ const (
 	Add // +
 	Sub // -
 	Mul // *
+
+	At // @
+
+	Div    // /
+	Mod    // %
+	Lbrace // {
+	Rbrace // }
+	Assign // =
+	Eq     // ==
+	Ne     // !=
+	Lt     // <
+	Le     // <=
+	Gt     // >
+	Ge     // >=
+	Not    // !
+	And    // &&
+	Or     // ||
 )
 
 func (tk TokenKind) String() string {
@@ -44,13 +62,33 @@ func (tk TokenKind) String() string {
 		Comment:    "Comment",
 		Identifier: "Identifier",
 		Integer:    "Integer",
+		Float:      "Float",
 		String:     "String",
 		Lrb:        "(",
 		Rrb:        ")",
+		Lcb:        "[",
+		Rcb:        "]",
 		Dot:        ".",
+		Comma:      ",",
 		Colon:      ":",
 		Add:        "+",
+		Sub:        "-",
 		Mul:        "*",
+		At:         "@",
+		Div:        "/",
+		Mod:        "%",
+		Lbrace:     "{",
+		Rbrace:     "}",
+		Assign:     "=",
+		Eq:         "==",
+		Ne:         "!=",
+		Lt:         "<",
+		Le:         "<=",
+		Gt:         ">",
+		Ge:         ">=",
+		Not:        "!",
+		And:        "&&",
+		Or:         "||",
 	}
 	return kinds[tk]
 }
@@ -73,6 +111,13 @@ func (t *Token) GetValueAsInteger() (int, error) {
 	return int(i64), nil
 }
 
+func (t *Token) GetValueAsFloat() (float64, error) {
+	if t.Kind != Float {
+		return 0, fmt.Errorf("type mismatch: actual=%s", t.Kind.String())
+	}
+	return strconv.ParseFloat(string(t.Raw), 64)
+}
+
 func (t *Token) GetValueAsString() (string, error) {
 	if t.Kind != String {
 		return "", fmt.Errorf("type mismatch: actual=%s", t.Kind.String())
@@ -124,6 +169,16 @@ func integer() (*Token, error) {
 		v += string(text[loc.at])
 		loc.at++
 	}
+	// 小数点の後に数字が続けばFloat
+	if loc.at+1 < len(text) && text[loc.at] == '.' && isNumeric(text[loc.at+1]) {
+		tok.Kind = Float
+		v += string(text[loc.at])
+		loc.at++
+		for loc.at < len(text) && isNumeric(text[loc.at]) {
+			v += string(text[loc.at])
+			loc.at++
+		}
+	}
 	tok.Raw = []rune(v)
 	return &tok, nil
 }
@@ -131,7 +186,19 @@ func integer() (*Token, error) {
 func isSymbol(r rune) bool {
 	return r == '(' || r == ')' || r == '[' || r == ']' ||
 		r == '.' || r == ',' || r == ':' ||
-		r == '+' || r == '-' || r == '*'
+		r == '+' || r == '-' || r == '*' || r == '@' ||
+		r == '/' || r == '%' || r == '{' || r == '}' ||
+		r == '=' || r == '!' || r == '<' || r == '>' || r == '&' || r == '|'
+}
+
+// 2文字の記号. 1文字目だけでは記号にならないものも含む
+var digraphs = map[string]TokenKind{
+	"==": Eq,
+	"!=": Ne,
+	"<=": Le,
+	">=": Ge,
+	"&&": And,
+	"||": Or,
 }
 
 func symbol() (*Token, error) {
@@ -146,6 +213,22 @@ func symbol() (*Token, error) {
 		'+': {Kind: Add},
 		'-': {Kind: Sub},
 		'*': {Kind: Mul},
+		'@': {Kind: At},
+		'/': {Kind: Div},
+		'%': {Kind: Mod},
+		'{': {Kind: Lbrace},
+		'}': {Kind: Rbrace},
+		'=': {Kind: Assign},
+		'!': {Kind: Not},
+		'<': {Kind: Lt},
+		'>': {Kind: Gt},
+	}
+	if loc.at+1 < len(text) {
+		if kind, ok := digraphs[string(text[loc.at:loc.at+2])]; ok {
+			tok := Token{Kind: kind, Position: Position{StartedAt: loc.at, Line: loc.line}}
+			loc.at += 2
+			return &tok, nil
+		}
 	}
 	tok, ok := sym[text[loc.at]]
 	if !ok {
@@ -47,6 +47,59 @@ func TestTokenize(t *testing.T) {
 				{Kind: Eof, Position: Position{StartedAt: len(";hello"), Line: 0}},
 			},
 		},
+		{"float",
+			"1.25 3.x",
+			[]*Token{
+				{Kind: Float, Raw: []rune("1.25"), Position: Position{StartedAt: 0, Line: 0}},
+				{Kind: Integer, Raw: []rune("3"), Position: Position{StartedAt: 5, Line: 0}},
+				{Kind: Dot, Position: Position{StartedAt: 6, Line: 0}},
+				{Kind: Identifier, Raw: []rune("x"), Position: Position{StartedAt: 7, Line: 0}},
+				{Kind: Eof, Position: Position{StartedAt: 8, Line: 0}},
+			},
+		},
+		{"heap address",
+			"@12",
+			[]*Token{
+				{Kind: At, Position: Position{StartedAt: 0, Line: 0}},
+				{Kind: Integer, Raw: []rune("12"), Position: Position{StartedAt: 1, Line: 0}},
+				{Kind: Eof, Position: Position{StartedAt: 3, Line: 0}},
+			},
+		},
+		{"operators",
+			"a<=b!=!c&&{d%2}/3||e>f",
+			[]*Token{
+				{Kind: Identifier, Raw: []rune("a"), Position: Position{0, 0}},
+				{Kind: Le, Position: Position{1, 0}},
+				{Kind: Identifier, Raw: []rune("b"), Position: Position{3, 0}},
+				{Kind: Ne, Position: Position{4, 0}},
+				{Kind: Not, Position: Position{6, 0}},
+				{Kind: Identifier, Raw: []rune("c"), Position: Position{7, 0}},
+				{Kind: And, Position: Position{8, 0}},
+				{Kind: Lbrace, Position: Position{10, 0}},
+				{Kind: Identifier, Raw: []rune("d"), Position: Position{11, 0}},
+				{Kind: Mod, Position: Position{12, 0}},
+				{Kind: Integer, Raw: []rune("2"), Position: Position{13, 0}},
+				{Kind: Rbrace, Position: Position{14, 0}},
+				{Kind: Div, Position: Position{15, 0}},
+				{Kind: Integer, Raw: []rune("3"), Position: Position{16, 0}},
+				{Kind: Or, Position: Position{17, 0}},
+				{Kind: Identifier, Raw: []rune("e"), Position: Position{19, 0}},
+				{Kind: Gt, Position: Position{20, 0}},
+				{Kind: Identifier, Raw: []rune("f"), Position: Position{21, 0}},
+				{Kind: Eof, Position: Position{22, 0}},
+			},
+		},
+		{"assign",
+			"x = y == 1",
+			[]*Token{
+				{Kind: Identifier, Raw: []rune("x"), Position: Position{0, 0}},
+				{Kind: Assign, Position: Position{2, 0}},
+				{Kind: Identifier, Raw: []rune("y"), Position: Position{4, 0}},
+				{Kind: Eq, Position: Position{6, 0}},
+				{Kind: Integer, Raw: []rune("1"), Position: Position{9, 0}},
+				{Kind: Eof, Position: Position{10, 0}},
+			},
+		},
 		{
 			"asm",
 			`global _start
@@ -0,0 +1,204 @@
+package gvm
+
+import (
+	"errors"
+	"fmt"
+)
+
+type SymbolKind int
+
+const (
+	_          SymbolKind = iota
+	TextSymbol            // ProgramAddressで参照されるコード上の位置
+	DataSymbol            // HeapAddressで参照されるデータ領域上の位置
+)
+
+func (k SymbolKind) String() string {
+	return []string{
+		TextSymbol: "text",
+		DataSymbol: "data",
+	}[k]
+}
+
+type Symbol struct {
+	Name   string
+	Kind   SymbolKind
+	Offset int // セクション先頭からの相対位置
+}
+
+// Relocation Code[At]のProgramAddress/HeapAddressをリンク時に書き換える.
+// Symbolが空ならオブジェクト自身のセクション先頭を, そうでなければシンボルのアドレスを加算する.
+type Relocation struct {
+	At     int
+	Symbol string
+}
+
+// Object リンク前の翻訳単位
+type Object struct {
+	Name        string
+	Code        Program
+	DataSize    int // ヒープ先頭に確保するデータ領域のセル数
+	Exports     []Symbol
+	Imports     []string
+	Relocations []Relocation
+}
+
+func (o *Object) exports(name string) bool {
+	for _, sym := range o.Exports {
+		if sym.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *Object) imports(name string) bool {
+	for _, imp := range o.Imports {
+		if imp == name {
+			return true
+		}
+	}
+	return false
+}
+
+type linkedSymbol struct {
+	kind   SymbolKind
+	addr   int
+	object string
+}
+
+type layout struct {
+	prologue  Program
+	textBases []int
+	dataBases []int
+	symbols   map[string]linkedSymbol
+}
+
+// resolve セクションの配置を決めてシンボル表を作る
+func resolve(objects []*Object) (*layout, error) {
+	dataSize := 0
+	for _, obj := range objects {
+		dataSize += obj.DataSize
+	}
+	l := &layout{
+		textBases: make([]int, len(objects)),
+		dataBases: make([]int, len(objects)),
+		symbols:   map[string]linkedSymbol{},
+	}
+	if dataSize > 0 {
+		l.prologue = Program{MOV, HP, HeapAddress(dataSize)}
+	}
+
+	// セクションの配置を決める
+	text, data := len(l.prologue), 0
+	for i, obj := range objects {
+		l.textBases[i], l.dataBases[i] = text, data
+		text += len(obj.Code)
+		data += obj.DataSize
+	}
+
+	// シンボル表
+	var errs []error
+	for i, obj := range objects {
+		for _, sym := range obj.Exports {
+			if defined, ok := l.symbols[sym.Name]; ok {
+				errs = append(errs, fmt.Errorf("duplicate symbol: %s (%s, %s)", sym.Name, defined.object, obj.Name))
+				continue
+			}
+			var addr int
+			switch sym.Kind {
+			case TextSymbol:
+				addr = l.textBases[i] + sym.Offset
+			case DataSymbol:
+				addr = l.dataBases[i] + sym.Offset
+			default:
+				errs = append(errs, fmt.Errorf("%s: invalid symbol kind: %s", obj.Name, sym.Name))
+				continue
+			}
+			l.symbols[sym.Name] = linkedSymbol{kind: sym.Kind, addr: addr, object: obj.Name}
+		}
+	}
+	for _, obj := range objects {
+		for _, imp := range obj.Imports {
+			if _, ok := l.symbols[imp]; !ok {
+				errs = append(errs, fmt.Errorf("%s: undefined symbol: %s", obj.Name, imp))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return l, nil
+}
+
+// Symbols Linkした結果のProgram上での, 公開されたコードシンボルの位置
+func Symbols(objects ...*Object) (map[ProgramAddress]string, error) {
+	l, err := resolve(objects)
+	if err != nil {
+		return nil, err
+	}
+	symbols := map[ProgramAddress]string{}
+	for name, sym := range l.symbols {
+		if sym.kind == TextSymbol {
+			symbols[ProgramAddress(sym.addr)] = name
+		}
+	}
+	return symbols, nil
+}
+
+// Link オブジェクトを先頭から順に連結して一つのProgramにする.
+// データ領域を持つオブジェクトがある場合, 先頭にHPを進めるプロローグを挿入する.
+func Link(objects ...*Object) (Program, error) {
+	l, err := resolve(objects)
+	if err != nil {
+		return nil, err
+	}
+	textBases, dataBases, symbols := l.textBases, l.dataBases, l.symbols
+
+	var errs []error
+	// 再配置
+	program := append(Program{}, l.prologue...)
+	for i, obj := range objects {
+		code := append(Program{}, obj.Code...)
+		for _, rel := range obj.Relocations {
+			if rel.At < 0 || len(code) <= rel.At {
+				errs = append(errs, fmt.Errorf("%s: relocation out of range: %d", obj.Name, rel.At))
+				continue
+			}
+			var kind SymbolKind
+			var base int
+			switch code[rel.At].(type) {
+			case ProgramAddress:
+				kind, base = TextSymbol, textBases[i]
+			case HeapAddress:
+				kind, base = DataSymbol, dataBases[i]
+			default:
+				errs = append(errs, fmt.Errorf("%s: invalid relocation target: %s", obj.Name, code[rel.At].String()))
+				continue
+			}
+			if rel.Symbol != "" {
+				if !obj.imports(rel.Symbol) && !obj.exports(rel.Symbol) {
+					errs = append(errs, fmt.Errorf("%s: undeclared symbol: %s", obj.Name, rel.Symbol))
+					continue
+				}
+				sym := symbols[rel.Symbol]
+				if sym.kind != kind {
+					errs = append(errs, fmt.Errorf("%s: symbol kind mismatch: %s is %s", obj.Name, rel.Symbol, sym.kind))
+					continue
+				}
+				base = sym.addr
+			}
+			switch op := code[rel.At].(type) {
+			case ProgramAddress:
+				code[rel.At] = ProgramAddress(base + op.Value())
+			case HeapAddress:
+				code[rel.At] = HeapAddress(base + op.Value())
+			}
+		}
+		program = append(program, code...)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return program, nil
+}
@@ -0,0 +1,181 @@
+package gvm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLink(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects []*Object
+		expect  Program
+	}{
+		{
+			"single",
+			[]*Object{
+				{
+					Name: "main",
+					Code: Program{
+						NOP,
+						JMP, ProgramAddress(0),
+					},
+					Relocations: []Relocation{{At: 2}},
+				},
+			},
+			Program{
+				NOP,
+				JMP, ProgramAddress(0),
+			},
+		},
+		{
+			"import text",
+			[]*Object{
+				{
+					Name: "main",
+					Code: Program{
+						CALL, ProgramAddress(0),
+						JMP, ProgramAddress(4),
+						NOP,
+					},
+					Imports:     []string{"f"},
+					Relocations: []Relocation{{At: 1, Symbol: "f"}, {At: 3}},
+				},
+				{
+					Name: "lib",
+					Code: Program{
+						NOP,
+						RET,
+					},
+					Exports: []Symbol{{Name: "f", Kind: TextSymbol, Offset: 1}},
+				},
+			},
+			Program{
+				CALL, ProgramAddress(6),
+				JMP, ProgramAddress(4),
+				NOP,
+				NOP,
+				RET,
+			},
+		},
+		{
+			"import data",
+			[]*Object{
+				{
+					Name: "main",
+					Code: Program{
+						STORE, HeapAddress(0), Integer(1),
+						LOAD, R1, HeapAddress(0),
+					},
+					DataSize:    1,
+					Imports:     []string{"counter"},
+					Relocations: []Relocation{{At: 1}, {At: 5, Symbol: "counter"}},
+				},
+				{
+					Name:     "lib",
+					DataSize: 2,
+					Exports:  []Symbol{{Name: "counter", Kind: DataSymbol, Offset: 1}},
+				},
+			},
+			Program{
+				MOV, HP, HeapAddress(3),
+				STORE, HeapAddress(0), Integer(1),
+				LOAD, R1, HeapAddress(2),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Link(tt.objects...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expect, program); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestLink_Error(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects []*Object
+		expect  []string
+	}{
+		{
+			"undefined",
+			[]*Object{
+				{Name: "main", Code: Program{CALL, ProgramAddress(0)}, Imports: []string{"f", "g"}},
+			},
+			[]string{"main: undefined symbol: f", "main: undefined symbol: g"},
+		},
+		{
+			"duplicate",
+			[]*Object{
+				{Name: "a", Code: Program{RET}, Exports: []Symbol{{Name: "f", Kind: TextSymbol}}},
+				{Name: "b", Code: Program{RET}, Exports: []Symbol{{Name: "f", Kind: TextSymbol}}},
+			},
+			[]string{"duplicate symbol: f (a, b)"},
+		},
+		{
+			"kind mismatch",
+			[]*Object{
+				{
+					Name:        "main",
+					Code:        Program{CALL, ProgramAddress(0)},
+					Imports:     []string{"v"},
+					Relocations: []Relocation{{At: 1, Symbol: "v"}},
+				},
+				{Name: "lib", DataSize: 1, Exports: []Symbol{{Name: "v", Kind: DataSymbol}}},
+			},
+			[]string{"main: symbol kind mismatch: v is data"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Link(tt.objects...)
+			if err == nil {
+				t.Fatal("expect error")
+			}
+			if diff := cmp.Diff(tt.expect, strings.Split(err.Error(), "\n")); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestLink_Run(t *testing.T) {
+	program, err := Link(
+		&Object{
+			Name:        "main",
+			Code:        Program{ALLOC, Integer(1), POP, R2, LOAD, R1, HeapAddress(0)},
+			Imports:     []string{"answer"},
+			Relocations: []Relocation{{At: 6, Symbol: "answer"}},
+		},
+		&Object{
+			Name:     "lib",
+			DataSize: 2,
+			Exports:  []Symbol{{Name: "answer", Kind: DataSymbol, Offset: 1}},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRuntime(program, &Config{StackSize: 2, HeapSize: 4})
+	r.heap[1] = valueOf(Integer(42))
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	// データ領域の後ろからallocされる
+	if diff := cmp.Diff(HeapAddress(2), r.Register(R2)); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if diff := cmp.Diff(Integer(42), r.Register(R1)); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
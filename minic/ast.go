@@ -0,0 +1,117 @@
+package minic
+
+import "github.com/x0y14/gvm/internal"
+
+// File 翻訳単位. 関数の並び
+type File struct {
+	Funcs []*Func
+}
+
+// Func int name(int a, int b) { ... }
+type Func struct {
+	Line   int
+	Name   string
+	Params []string
+	Body   *Block
+}
+
+type Stmt interface {
+	line() int
+}
+
+type Expr interface {
+	line() int
+}
+
+type (
+	// Block { ... }. 宣言したローカル変数はブロックの終わりまで見える
+	Block struct {
+		Line  int
+		Stmts []Stmt
+	}
+	// Decl int name = value. Valueがnilなら0で初期化する
+	Decl struct {
+		Line  int
+		Name  string
+		Value Expr
+	}
+	// Assign name = value
+	Assign struct {
+		Line  int
+		Name  string
+		Value Expr
+	}
+	// If if (cond) then else. Elseは*Block, *If, nil
+	If struct {
+		Line int
+		Cond Expr
+		Then *Block
+		Else Stmt
+	}
+	// While while (cond) body
+	While struct {
+		Line int
+		Cond Expr
+		Body *Block
+	}
+	// Return return value. Valueがnilなら0を返す
+	Return struct {
+		Line  int
+		Value Expr
+	}
+	Break struct {
+		Line int
+	}
+	Continue struct {
+		Line int
+	}
+	// ExprStmt 文として書いた関数呼び出し
+	ExprStmt struct {
+		Line int
+		X    *Call
+	}
+)
+
+type (
+	Number struct {
+		Line  int
+		Value int
+	}
+	Ident struct {
+		Line int
+		Name string
+	}
+	// Unary -x, !x
+	Unary struct {
+		Line int
+		Op   internal.TokenKind
+		X    Expr
+	}
+	// Binary x op y
+	Binary struct {
+		Line int
+		Op   internal.TokenKind
+		X, Y Expr
+	}
+	Call struct {
+		Line int
+		Name string
+		Args []Expr
+	}
+)
+
+func (s *Block) line() int    { return s.Line }
+func (s *Decl) line() int     { return s.Line }
+func (s *Assign) line() int   { return s.Line }
+func (s *If) line() int       { return s.Line }
+func (s *While) line() int    { return s.Line }
+func (s *Return) line() int   { return s.Line }
+func (s *Break) line() int    { return s.Line }
+func (s *Continue) line() int { return s.Line }
+func (s *ExprStmt) line() int { return s.Line }
+
+func (e *Number) line() int { return e.Line }
+func (e *Ident) line() int  { return e.Line }
+func (e *Unary) line() int  { return e.Line }
+func (e *Binary) line() int { return e.Line }
+func (e *Call) line() int   { return e.Line }
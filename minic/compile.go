@@ -0,0 +1,419 @@
+package minic
+
+import (
+	"fmt"
+
+	"github.com/x0y14/gvm"
+	"github.com/x0y14/gvm/internal"
+)
+
+// jumps 比較演算子と, 成り立つときに飛ぶ命令
+var jumps = map[internal.TokenKind]gvm.Opcode{
+	internal.Eq: gvm.JE,
+	internal.Ne: gvm.JNE,
+	internal.Lt: gvm.JL,
+	internal.Le: gvm.JLE,
+	internal.Gt: gvm.JG,
+	internal.Ge: gvm.JGE,
+}
+
+var arithmetic = map[internal.TokenKind]gvm.Opcode{
+	internal.Add: gvm.ADD,
+	internal.Sub: gvm.SUB,
+	internal.Mul: gvm.MUL,
+	internal.Div: gvm.DIV,
+}
+
+type label int
+
+type loop struct {
+	brk, cont label
+}
+
+type compiler struct {
+	program gvm.Program
+	funcs   map[string]*Func
+	entries map[string]int
+	calls   map[int]*Call // CALLのオペランドの位置と, 呼び出し
+	labels  []int         // ラベルの位置. 未定なら-1
+	fixups  map[int]label // ジャンプのオペランドの位置と, 飛び先のラベル
+
+	// 関数ごとの状態
+	scopes []map[string]gvm.BpOffset
+	locals int
+	loops  []loop
+}
+
+func errorf(l int, format string, args ...any) error {
+	return fmt.Errorf("minic: %d: %s", l, fmt.Sprintf(format, args...))
+}
+
+// Compile ソースをProgramにする. mainを呼び出し, その戻り値をR1に残して終わる.
+//
+// 値はすべてInteger. 式はR1に計算し, 二項演算の左辺はスタックに退避する.
+// 引数は後ろから積んでCALLし, 呼び出し元が取り除く. 関数の中では
+//
+//	[bp+2+i]: i番目の引数
+//	[bp-1-j]: j番目に宣言したローカル変数
+//
+// になる. 戻り値はR1で返す.
+func Compile(src string) (gvm.Program, error) {
+	file, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return CompileFile(file)
+}
+
+// CompileFile 構文木をProgramにする
+func CompileFile(file *File) (gvm.Program, error) {
+	c := &compiler{
+		funcs:   map[string]*Func{},
+		entries: map[string]int{},
+		calls:   map[int]*Call{},
+		fixups:  map[int]label{},
+	}
+	for _, fn := range file.Funcs {
+		if _, ok := c.funcs[fn.Name]; ok {
+			return nil, errorf(fn.Line, "duplicate function: %s", fn.Name)
+		}
+		c.funcs[fn.Name] = fn
+	}
+	main, ok := c.funcs["main"]
+	if !ok {
+		return nil, fmt.Errorf("minic: undefined function: main")
+	}
+	if len(main.Params) != 0 {
+		return nil, errorf(main.Line, "main: want 0 params, got %d", len(main.Params))
+	}
+
+	end := c.newLabel()
+	if err := c.call(&Call{Line: main.Line, Name: "main"}); err != nil {
+		return nil, err
+	}
+	c.jump(gvm.JMP, end)
+	for _, fn := range file.Funcs {
+		if err := c.function(fn); err != nil {
+			return nil, err
+		}
+	}
+	c.mark(end)
+
+	for at, call := range c.calls {
+		c.program[at] = gvm.ProgramAddress(c.entries[call.Name])
+	}
+	for at, l := range c.fixups {
+		c.program[at] = gvm.ProgramAddress(c.labels[l])
+	}
+	return c.program, nil
+}
+
+func (c *compiler) emit(words ...gvm.Word) {
+	c.program = append(c.program, words...)
+}
+
+func (c *compiler) newLabel() label {
+	c.labels = append(c.labels, -1)
+	return label(len(c.labels) - 1)
+}
+
+func (c *compiler) mark(l label) {
+	c.labels[l] = len(c.program)
+}
+
+func (c *compiler) jump(op gvm.Opcode, l label) {
+	c.emit(op, nil)
+	c.fixups[len(c.program)-1] = l
+}
+
+func (c *compiler) function(fn *Func) error {
+	c.entries[fn.Name] = len(c.program)
+	params := map[string]gvm.BpOffset{}
+	for i, name := range fn.Params {
+		if _, ok := params[name]; ok {
+			return errorf(fn.Line, "duplicate param: %s", name)
+		}
+		params[name] = gvm.BpOffset(2 + i)
+	}
+	c.scopes = []map[string]gvm.BpOffset{params}
+	c.locals = 0
+	c.loops = nil
+
+	// ローカル変数の領域を先に積んでおく
+	for range countLocals(fn.Body) {
+		c.emit(gvm.PUSH, gvm.Integer(0))
+	}
+	if err := c.block(fn.Body); err != nil {
+		return err
+	}
+	c.emit(gvm.MOV, gvm.R1, gvm.Integer(0), gvm.RET)
+	return nil
+}
+
+// countLocals 関数の中で宣言するローカル変数の数. スロットは使い回さない
+func countLocals(s Stmt) int {
+	switch s := s.(type) {
+	case *Block:
+		n := 0
+		for _, s := range s.Stmts {
+			n += countLocals(s)
+		}
+		return n
+	case *Decl:
+		return 1
+	case *If:
+		n := countLocals(s.Then)
+		if s.Else != nil {
+			n += countLocals(s.Else)
+		}
+		return n
+	case *While:
+		return countLocals(s.Body)
+	default:
+		return 0
+	}
+}
+
+func (c *compiler) lookup(l int, name string) (gvm.BpOffset, error) {
+	for i := len(c.scopes) - 1; 0 <= i; i-- {
+		if at, ok := c.scopes[i][name]; ok {
+			return at, nil
+		}
+	}
+	return 0, errorf(l, "undefined variable: %s", name)
+}
+
+func (c *compiler) block(b *Block) error {
+	c.scopes = append(c.scopes, map[string]gvm.BpOffset{})
+	defer func() { c.scopes = c.scopes[:len(c.scopes)-1] }()
+	for _, s := range b.Stmts {
+		if err := c.stmt(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *compiler) stmt(s Stmt) error {
+	switch s := s.(type) {
+	case *Block:
+		return c.block(s)
+	case *Decl:
+		scope := c.scopes[len(c.scopes)-1]
+		if _, ok := scope[s.Name]; ok {
+			return errorf(s.Line, "duplicate variable: %s", s.Name)
+		}
+		var v Expr = &Number{Line: s.Line}
+		if s.Value != nil {
+			v = s.Value
+		}
+		// 初期化式の中ではまだ宣言していない
+		if err := c.expr(v); err != nil {
+			return err
+		}
+		at := gvm.BpOffset(-1 - c.locals)
+		c.locals++
+		scope[s.Name] = at
+		c.emit(gvm.MOV, at, gvm.R1)
+		return nil
+	case *Assign:
+		at, err := c.lookup(s.Line, s.Name)
+		if err != nil {
+			return err
+		}
+		if err := c.expr(s.Value); err != nil {
+			return err
+		}
+		c.emit(gvm.MOV, at, gvm.R1)
+		return nil
+	case *If:
+		els, end := c.newLabel(), c.newLabel()
+		if err := c.branch(s.Cond, els); err != nil {
+			return err
+		}
+		if err := c.block(s.Then); err != nil {
+			return err
+		}
+		c.jump(gvm.JMP, end)
+		c.mark(els)
+		if s.Else != nil {
+			if err := c.stmt(s.Else); err != nil {
+				return err
+			}
+		}
+		c.mark(end)
+		return nil
+	case *While:
+		l := loop{brk: c.newLabel(), cont: c.newLabel()}
+		c.mark(l.cont)
+		if err := c.branch(s.Cond, l.brk); err != nil {
+			return err
+		}
+		c.loops = append(c.loops, l)
+		err := c.block(s.Body)
+		c.loops = c.loops[:len(c.loops)-1]
+		if err != nil {
+			return err
+		}
+		c.jump(gvm.JMP, l.cont)
+		c.mark(l.brk)
+		return nil
+	case *Return:
+		var v Expr = &Number{Line: s.Line}
+		if s.Value != nil {
+			v = s.Value
+		}
+		if err := c.expr(v); err != nil {
+			return err
+		}
+		c.emit(gvm.RET)
+		return nil
+	case *Break:
+		if len(c.loops) == 0 {
+			return errorf(s.Line, "break outside loop")
+		}
+		c.jump(gvm.JMP, c.loops[len(c.loops)-1].brk)
+		return nil
+	case *Continue:
+		if len(c.loops) == 0 {
+			return errorf(s.Line, "continue outside loop")
+		}
+		c.jump(gvm.JMP, c.loops[len(c.loops)-1].cont)
+		return nil
+	case *ExprStmt:
+		return c.call(s.X)
+	default:
+		return fmt.Errorf("minic: unsupported statement: %T", s)
+	}
+}
+
+// branch condが0ならlへ飛ぶ
+func (c *compiler) branch(cond Expr, l label) error {
+	if err := c.expr(cond); err != nil {
+		return err
+	}
+	c.emit(gvm.CMP, gvm.R1, gvm.Integer(0))
+	c.jump(gvm.JE, l)
+	return nil
+}
+
+// boolean 直前のフラグでopが成り立てば1, でなければ0をR1に入れる. MOVはフラグを変えない
+func (c *compiler) boolean(op gvm.Opcode) {
+	end := c.newLabel()
+	c.emit(gvm.MOV, gvm.R1, gvm.Integer(1))
+	c.jump(op, end)
+	c.emit(gvm.MOV, gvm.R1, gvm.Integer(0))
+	c.mark(end)
+}
+
+// expr 式の値をR1に入れる. R2は作業用で, スタックは元の深さに戻す
+func (c *compiler) expr(x Expr) error {
+	switch x := x.(type) {
+	case *Number:
+		c.emit(gvm.MOV, gvm.R1, gvm.Integer(x.Value))
+		return nil
+	case *Ident:
+		at, err := c.lookup(x.Line, x.Name)
+		if err != nil {
+			return err
+		}
+		c.emit(gvm.MOV, gvm.R1, at)
+		return nil
+	case *Call:
+		return c.call(x)
+	case *Unary:
+		if err := c.expr(x.X); err != nil {
+			return err
+		}
+		switch x.Op {
+		case internal.Sub:
+			c.emit(gvm.MOV, gvm.R2, gvm.R1, gvm.MOV, gvm.R1, gvm.Integer(0), gvm.SUB, gvm.R1, gvm.R2)
+		case internal.Not:
+			c.emit(gvm.CMP, gvm.R1, gvm.Integer(0))
+			c.boolean(gvm.JE)
+		}
+		return nil
+	case *Binary:
+		if x.Op == internal.And || x.Op == internal.Or {
+			return c.logical(x)
+		}
+		if err := c.expr(x.X); err != nil {
+			return err
+		}
+		c.emit(gvm.PUSH, gvm.R1)
+		if err := c.expr(x.Y); err != nil {
+			return err
+		}
+		c.emit(gvm.MOV, gvm.R2, gvm.R1, gvm.POP, gvm.R1)
+		if op, ok := arithmetic[x.Op]; ok {
+			c.emit(op, gvm.R1, gvm.R2)
+			return nil
+		}
+		if op, ok := jumps[x.Op]; ok {
+			c.emit(gvm.CMP, gvm.R1, gvm.R2)
+			c.boolean(op)
+			return nil
+		}
+		if x.Op == internal.Mod {
+			// x % y = x - x / y * y
+			c.emit(
+				gvm.PUSH, gvm.R1,
+				gvm.DIV, gvm.R1, gvm.R2,
+				gvm.MUL, gvm.R1, gvm.R2,
+				gvm.MOV, gvm.R2, gvm.R1,
+				gvm.POP, gvm.R1,
+				gvm.SUB, gvm.R1, gvm.R2,
+			)
+			return nil
+		}
+		return errorf(x.Line, "unsupported operator: %s", x.Op)
+	default:
+		return fmt.Errorf("minic: unsupported expression: %T", x)
+	}
+}
+
+// logical &&, ||. 左辺で決まれば右辺は評価しない
+func (c *compiler) logical(x *Binary) error {
+	short, end := c.newLabel(), c.newLabel()
+	// &&は左辺が0なら0, ||は左辺が0でなければ1
+	op, v := gvm.JE, gvm.Integer(0)
+	if x.Op == internal.Or {
+		op, v = gvm.JNE, gvm.Integer(1)
+	}
+	for _, y := range []Expr{x.X, x.Y} {
+		if err := c.expr(y); err != nil {
+			return err
+		}
+		c.emit(gvm.CMP, gvm.R1, gvm.Integer(0))
+		c.jump(op, short)
+	}
+	c.emit(gvm.MOV, gvm.R1, 1-v)
+	c.jump(gvm.JMP, end)
+	c.mark(short)
+	c.emit(gvm.MOV, gvm.R1, v)
+	c.mark(end)
+	return nil
+}
+
+// call 引数を後ろから積んで呼び出し, 戻ったら取り除く
+func (c *compiler) call(x *Call) error {
+	fn, ok := c.funcs[x.Name]
+	if !ok {
+		return errorf(x.Line, "undefined function: %s", x.Name)
+	}
+	if len(fn.Params) != len(x.Args) {
+		return errorf(x.Line, "%s: want %d args, got %d", x.Name, len(fn.Params), len(x.Args))
+	}
+	for i := len(x.Args) - 1; 0 <= i; i-- {
+		if err := c.expr(x.Args[i]); err != nil {
+			return err
+		}
+		c.emit(gvm.PUSH, gvm.R1)
+	}
+	c.emit(gvm.CALL, nil)
+	c.calls[len(c.program)-1] = x
+	for range x.Args {
+		c.emit(gvm.POP, gvm.R2)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package minic
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/x0y14/gvm"
+)
+
+func run(t *testing.T, src string) (gvm.Operand, error) {
+	t.Helper()
+	program, err := Compile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	optimized, err := gvm.Optimize(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 最適化の前後で結果が変わらない
+	var results [2]gvm.Operand
+	var errs [2]error
+	for i, p := range []gvm.Program{program, optimized} {
+		r := gvm.NewRuntime(p, &gvm.Config{StackSize: 1024, HeapSize: 0})
+		errs[i] = r.Run()
+		results[i] = r.Register(gvm.R1)
+	}
+	if (errs[0] == nil) != (errs[1] == nil) {
+		t.Fatalf("optimized: want=%v, got=%v", errs[0], errs[1])
+	}
+	if errs[0] != nil {
+		return nil, errs[0]
+	}
+	if diff := cmp.Diff(results[0], results[1]); diff != "" {
+		t.Fatalf("optimized: diff: %s", diff)
+	}
+	return results[0], nil
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect string
+	}{
+		{"precedence", "1 + 2 * 3 - 4", "((1 + (2 * 3)) - 4)"},
+		{"left assoc", "8 / 4 / 2 % 3", "(((8 / 4) / 2) % 3)"},
+		{"paren", "(1 + 2) * 3", "((1 + 2) * 3)"},
+		{"unary", "-x * !-y", "((-x) * (!(-y)))"},
+		{"compare", "a < b == c >= d", "((a < b) == (c >= d))"},
+		{"logical", "a || b && c || !d", "((a || (b && c)) || (!d))"},
+		{"call", "f(1, g(), x + 1) + 2", "(f(1, g(), (x + 1)) + 2)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := Parse("int main() { return " + tt.src + " }")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ret := file.Funcs[0].Body.Stmts[0].(*Return)
+			if diff := cmp.Diff(tt.expect, String(ret.Value)); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect gvm.Operand
+	}{
+		{"return", "int main() { return 42 }", gvm.Integer(42)},
+		{"no return", "int main() { int x = 1 }", gvm.Integer(0)},
+		{"arith", "int main() { return -(1 + 2 * 3 - 20 / 4) % 3 }", gvm.Integer(-2)},
+		{"compare",
+			"int main() { return (1 < 2) + (2 <= 2) * 10 + (3 > 4) * 100 + (4 >= 5) * 1000 + (5 == 5) * 10000 + (5 != 5) * 100000 }",
+			gvm.Integer(10011)},
+		{"negative compare", "int main() { return -3 < 2 }", gvm.Integer(1)},
+		{"locals", `
+int main() {
+    int x = 3
+    int y
+    y = x * 2
+    x = x + y ; 9
+    return x * 10 + y
+}`, gvm.Integer(96)},
+		{"scope", `
+int main() {
+    int x = 1
+    {
+        int x = 2
+        x = x + 10
+    }
+    if (x == 1) { int x = 5  return x }
+    return 0
+}`, gvm.Integer(5)},
+		{"if else", `
+int sign(int n) {
+    if (n < 0) {
+        return -1
+    } else if (n == 0) {
+        return 0
+    } else {
+        return 1
+    }
+}
+int main() { return sign(-5) * 100 + sign(0) * 10 + sign(7) }`, gvm.Integer(-99)},
+		{"while", `
+int main() {
+    int i = 0
+    int sum = 0
+    while (1) {
+        i = i + 1
+        if (i > 10) { break }
+        if (i % 2 == 0) { continue }
+        sum = sum + i
+    }
+    return sum
+}`, gvm.Integer(25)},
+		{"fib", `
+int fib(int n) {
+    if (n < 2) { return n }
+    return fib(n - 1) + fib(n - 2)
+}
+int main() { return fib(15) }`, gvm.Integer(610)},
+		{"params", `
+int sub3(int a, int b, int c) { return a - b - c }
+int main() { return sub3(100, 20, 3) }`, gvm.Integer(77)},
+		{"gcd", `
+int gcd(int a, int b) {
+    while (b != 0) {
+        int t = b
+        b = a % b
+        a = t
+    }
+    return a
+}
+int main() { return gcd(1071, 462) }`, gvm.Integer(21)},
+		{"mutual recursion", `
+int even(int n) { if (n == 0) { return 1 } return odd(n - 1) }
+int odd(int n) { if (n == 0) { return 0 } return even(n - 1) }
+int main() { return even(10) * 10 + odd(7) }`, gvm.Integer(11)},
+		{"short circuit", `
+int main() {
+    int zero = 0
+    return (zero != 0 && 1 / zero) + (zero == 0 || 1 / zero) * 10 + (1 && 2) * 100 + (0 || 0) * 1000
+}`, gvm.Integer(110)},
+		{"call statement", `
+int noop(int x) { return x }
+int main() {
+    noop(1)
+    return
+}`, gvm.Integer(0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := run(t, tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expect, got); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestCompile_Error(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect string
+	}{
+		{"no main", "int f() { return 1 }", "minic: undefined function: main"},
+		{"main params", "int main(int x) { return x }", "minic: 1: main: want 0 params, got 1"},
+		{"duplicate function", "int main() { }\nint main() { }", "minic: 2: duplicate function: main"},
+		{"duplicate param", "int f(int a, int a) { }\nint main() { }", "minic: 1: duplicate param: a"},
+		{"duplicate variable", "int main() {\n int x\n int x\n}", "minic: 3: duplicate variable: x"},
+		{"undefined variable", "int main() {\n return y\n}", "minic: 2: undefined variable: y"},
+		{"self init", "int main() { int x = x }", "minic: 1: undefined variable: x"},
+		{"out of scope", "int main() { { int x } return x }", "minic: 1: undefined variable: x"},
+		{"undefined function", "int main() { return f() }", "minic: 1: undefined function: f"},
+		{"args", "int f(int a) { return a }\nint main() { return f(1, 2) }", "minic: 2: f: want 1 args, got 2"},
+		{"break", "int main() { break }", "minic: 1: break outside loop"},
+		{"continue", "int main() { continue }", "minic: 1: continue outside loop"},
+		{"keyword", "int main() { int while }", "minic: 1: unexpected keyword: while"},
+		{"statement", "int main() { x + 1 }", "minic: 1: unexpected token: +"},
+		{"unclosed", "int main() {\n return 1", "minic: 2: want=}, got=Eof"},
+		{"cond", "int main() { if x { } }", "minic: 1: want=(, got=x"},
+		{"lexer", "int main() { return 1 & 2 }", "minic: unexpected rune: &"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.src)
+			if err == nil {
+				t.Fatal("want error")
+			}
+			if diff := cmp.Diff(tt.expect, err.Error()); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestCompile_RuntimeError(t *testing.T) {
+	_, err := run(t, "int div(int a, int b) { return a / b }\nint main() { return div(1, 0) % 2 }")
+	if !errors.Is(err, gvm.ErrDivisionByZero) {
+		t.Errorf("want=division by zero, got=%v", err)
+	}
+}
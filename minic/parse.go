@@ -0,0 +1,393 @@
+package minic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/x0y14/gvm/internal"
+)
+
+var keywords = map[string]bool{
+	"int":      true,
+	"if":       true,
+	"else":     true,
+	"while":    true,
+	"return":   true,
+	"break":    true,
+	"continue": true,
+}
+
+// binaryLevels 二項演算子の優先順位. 後ろほど強く結びつく
+var binaryLevels = [][]internal.TokenKind{
+	{internal.Or},
+	{internal.And},
+	{internal.Eq, internal.Ne},
+	{internal.Lt, internal.Le, internal.Gt, internal.Ge},
+	{internal.Add, internal.Sub},
+	{internal.Mul, internal.Div, internal.Mod},
+}
+
+type parser struct {
+	tok *internal.Token
+}
+
+func line(tok *internal.Token) int {
+	return tok.Position.Line + 1
+}
+
+func (p *parser) errorf(tok *internal.Token, format string, args ...any) error {
+	return fmt.Errorf("minic: %d: %s", line(tok), fmt.Sprintf(format, args...))
+}
+
+func (p *parser) next() *internal.Token {
+	tok := p.tok
+	p.tok = p.tok.Next
+	for p.tok.Kind == internal.Comment {
+		p.tok = p.tok.Next
+	}
+	return tok
+}
+
+func (p *parser) expect(kind internal.TokenKind) (*internal.Token, error) {
+	if p.tok.Kind != kind {
+		return nil, p.errorf(p.tok, "want=%s, got=%s", kind.String(), p.describe(p.tok))
+	}
+	return p.next(), nil
+}
+
+func (p *parser) describe(tok *internal.Token) string {
+	if tok.Kind == internal.Identifier || tok.Kind == internal.Integer {
+		return string(tok.Raw)
+	}
+	return tok.Kind.String()
+}
+
+// keyword 予約語ならそれを読み飛ばす
+func (p *parser) keyword(name string) bool {
+	if p.tok.Kind == internal.Identifier && string(p.tok.Raw) == name {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(name string) error {
+	if !p.keyword(name) {
+		return p.errorf(p.tok, "want=%s, got=%s", name, p.describe(p.tok))
+	}
+	return nil
+}
+
+// name 予約語でない識別子
+func (p *parser) name() (*internal.Token, error) {
+	tok, err := p.expect(internal.Identifier)
+	if err != nil {
+		return nil, err
+	}
+	if keywords[string(tok.Raw)] {
+		return nil, p.errorf(tok, "unexpected keyword: %s", string(tok.Raw))
+	}
+	return tok, nil
+}
+
+// Parse ソースを構文木にする. 文は改行やセミコロンで区切らない(;から行末まではコメント).
+//
+//	int fib(int n) {
+//	    if (n < 2) { return n }
+//	    return fib(n - 1) + fib(n - 2)
+//	}
+//	int main() { return fib(10) }
+func Parse(src string) (*File, error) {
+	head, err := internal.Tokenize([]rune(src))
+	if err != nil {
+		return nil, fmt.Errorf("minic: %w", err)
+	}
+	p := &parser{tok: &internal.Token{Next: head}}
+	p.next()
+	file := &File{}
+	for p.tok.Kind != internal.Eof {
+		fn, err := p.function()
+		if err != nil {
+			return nil, err
+		}
+		file.Funcs = append(file.Funcs, fn)
+	}
+	return file, nil
+}
+
+func (p *parser) function() (*Func, error) {
+	start := p.tok
+	if err := p.expectKeyword("int"); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	fn := &Func{Line: line(start), Name: string(name.Raw)}
+	if _, err := p.expect(internal.Lrb); err != nil {
+		return nil, err
+	}
+	for p.tok.Kind != internal.Rrb {
+		if len(fn.Params) > 0 {
+			if _, err := p.expect(internal.Comma); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expectKeyword("int"); err != nil {
+			return nil, err
+		}
+		param, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		fn.Params = append(fn.Params, string(param.Raw))
+	}
+	p.next()
+	if fn.Body, err = p.block(); err != nil {
+		return nil, err
+	}
+	return fn, nil
+}
+
+func (p *parser) block() (*Block, error) {
+	start, err := p.expect(internal.Lbrace)
+	if err != nil {
+		return nil, err
+	}
+	b := &Block{Line: line(start)}
+	for p.tok.Kind != internal.Rbrace {
+		if p.tok.Kind == internal.Eof {
+			return nil, p.errorf(p.tok, "want=%s, got=%s", internal.Rbrace.String(), internal.Eof.String())
+		}
+		s, err := p.stmt()
+		if err != nil {
+			return nil, err
+		}
+		b.Stmts = append(b.Stmts, s)
+	}
+	p.next()
+	return b, nil
+}
+
+func (p *parser) stmt() (Stmt, error) {
+	start := p.tok
+	l := line(start)
+	switch {
+	case p.tok.Kind == internal.Lbrace:
+		return p.block()
+	case p.keyword("int"):
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		s := &Decl{Line: l, Name: string(name.Raw)}
+		if p.tok.Kind == internal.Assign {
+			p.next()
+			if s.Value, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case p.keyword("if"):
+		return p.ifStmt(l)
+	case p.keyword("while"):
+		cond, err := p.cond()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &While{Line: l, Cond: cond, Body: body}, nil
+	case p.keyword("return"):
+		s := &Return{Line: l}
+		// 値は同じ行に書く. 次の行は別の文
+		if p.tok.Kind != internal.Rbrace && p.tok.Position.Line == start.Position.Line {
+			v, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			s.Value = v
+		}
+		return s, nil
+	case p.keyword("break"):
+		return &Break{Line: l}, nil
+	case p.keyword("continue"):
+		return &Continue{Line: l}, nil
+	}
+
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	switch p.tok.Kind {
+	case internal.Assign:
+		p.next()
+		v, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return &Assign{Line: l, Name: string(name.Raw), Value: v}, nil
+	case internal.Lrb:
+		call, err := p.call(name)
+		if err != nil {
+			return nil, err
+		}
+		return &ExprStmt{Line: l, X: call}, nil
+	default:
+		return nil, p.errorf(p.tok, "unexpected token: %s", p.describe(p.tok))
+	}
+}
+
+func (p *parser) ifStmt(l int) (Stmt, error) {
+	cond, err := p.cond()
+	if err != nil {
+		return nil, err
+	}
+	then, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	s := &If{Line: l, Cond: cond, Then: then}
+	if !p.keyword("else") {
+		return s, nil
+	}
+	elseLine := line(p.tok)
+	if p.keyword("if") {
+		s.Else, err = p.ifStmt(elseLine)
+	} else {
+		s.Else, err = p.block()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// cond (expr)
+func (p *parser) cond() (Expr, error) {
+	if _, err := p.expect(internal.Lrb); err != nil {
+		return nil, err
+	}
+	x, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(internal.Rrb); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (p *parser) expr() (Expr, error) {
+	return p.binary(0)
+}
+
+func (p *parser) binary(level int) (Expr, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	x, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.tok
+		matched := false
+		for _, kind := range binaryLevels[level] {
+			if op.Kind == kind {
+				matched = true
+			}
+		}
+		if !matched {
+			return x, nil
+		}
+		p.next()
+		y, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &Binary{Line: line(op), Op: op.Kind, X: x, Y: y}
+	}
+}
+
+func (p *parser) unary() (Expr, error) {
+	if op := p.tok; op.Kind == internal.Sub || op.Kind == internal.Not {
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &Unary{Line: line(op), Op: op.Kind, X: x}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (Expr, error) {
+	tok := p.tok
+	switch tok.Kind {
+	case internal.Integer:
+		p.next()
+		v, err := tok.GetValueAsInteger()
+		if err != nil {
+			return nil, p.errorf(tok, "invalid integer: %s", string(tok.Raw))
+		}
+		return &Number{Line: line(tok), Value: v}, nil
+	case internal.Lrb:
+		return p.cond()
+	case internal.Identifier:
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.Kind == internal.Lrb {
+			return p.call(name)
+		}
+		return &Ident{Line: line(name), Name: string(name.Raw)}, nil
+	default:
+		return nil, p.errorf(tok, "unexpected token: %s", p.describe(tok))
+	}
+}
+
+func (p *parser) call(name *internal.Token) (*Call, error) {
+	p.next() // (
+	c := &Call{Line: line(name), Name: string(name.Raw)}
+	for p.tok.Kind != internal.Rrb {
+		if len(c.Args) > 0 {
+			if _, err := p.expect(internal.Comma); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		c.Args = append(c.Args, arg)
+	}
+	p.next()
+	return c, nil
+}
+
+// String 構文木を括弧付きの式として書く. テストとエラーの表示に使う
+func String(x Expr) string {
+	switch x := x.(type) {
+	case *Number:
+		return fmt.Sprint(x.Value)
+	case *Ident:
+		return x.Name
+	case *Unary:
+		return fmt.Sprintf("(%s%s)", x.Op, String(x.X))
+	case *Binary:
+		return fmt.Sprintf("(%s %s %s)", String(x.X), x.Op, String(x.Y))
+	case *Call:
+		args := make([]string, len(x.Args))
+		for i, arg := range x.Args {
+			args[i] = String(arg)
+		}
+		return fmt.Sprintf("%s(%s)", x.Name, strings.Join(args, ", "))
+	default:
+		return "?"
+	}
+}
@@ -2,6 +2,8 @@ package gvm
 
 type Opcode int
 
+// 番号はスナップショットに書かれるので, 新しい命令は末尾に足す.
+// 途中に足したり並べ替えたりするならsnapshotVersionを上げる
 const (
 	NOP Opcode = iota
 
@@ -19,6 +21,8 @@ const (
 
 	ADD
 	SUB
+	MUL
+	DIV
 
 	JMP
 	JE
@@ -28,6 +32,58 @@ const (
 	NE
 	LT
 	LE
+
+	NCALL
+	IN
+
+	ITOF
+	FTOI
+	CTOI
+	ITOC
+	ITOB
+	BTOI
+	CTOB
+	BTOC
+	FTOB
+	BTOF
+	CTOF
+	FTOC
+
+	TYPEOF
+
+	LDF
+	STF
+	LDX
+	STX
+
+	CMP
+	JL
+	JLE
+	JG
+	JGE
+	JB
+	JBE
+	JA
+	JAE
+	JO
+	JNO
+	JS
+	JNS
+
+	LEA
+
+	TRY
+	ENDTRY
+	THROW
+
+	SPAWN
+	YIELD
+	JOIN
+
+	CHAN
+	SEND
+	RECV
+	CLOSE
 )
 
 func (op Opcode) String() string {
@@ -43,6 +99,8 @@ func (op Opcode) String() string {
 		RET:   "ret",
 		ADD:   "add",
 		SUB:   "sub",
+		MUL:   "mul",
+		DIV:   "div",
 		JMP:   "jmp",
 		JE:    "je",
 		JNE:   "jne",
@@ -50,6 +108,56 @@ func (op Opcode) String() string {
 		NE:    "ne",
 		LT:    "lt",
 		LE:    "le",
+		NCALL: "ncall",
+		IN:    "in",
+		ITOF:  "itof",
+		FTOI:  "ftoi",
+		CTOI:  "ctoi",
+		ITOC:  "itoc",
+		ITOB:  "itob",
+		BTOI:  "btoi",
+		CTOB:  "ctob",
+		BTOC:  "btoc",
+		FTOB:  "ftob",
+		BTOF:  "btof",
+		CTOF:  "ctof",
+		FTOC:  "ftoc",
+
+		TYPEOF: "typeof",
+
+		LDF: "ldf",
+		STF: "stf",
+		LDX: "ldx",
+		STX: "stx",
+
+		CMP: "cmp",
+		JL:  "jl",
+		JLE: "jle",
+		JG:  "jg",
+		JGE: "jge",
+		JB:  "jb",
+		JBE: "jbe",
+		JA:  "ja",
+		JAE: "jae",
+		JO:  "jo",
+		JNO: "jno",
+		JS:  "js",
+		JNS: "jns",
+
+		LEA: "lea",
+
+		TRY:    "try",
+		ENDTRY: "endtry",
+		THROW:  "throw",
+
+		SPAWN: "spawn",
+		YIELD: "yield",
+		JOIN:  "join",
+
+		CHAN:  "chan",
+		SEND:  "send",
+		RECV:  "recv",
+		CLOSE: "close",
 	}[op]
 }
 
@@ -66,6 +174,8 @@ func (op Opcode) NumOperands() int {
 		RET:   0,
 		ADD:   2,
 		SUB:   2,
+		MUL:   2,
+		DIV:   2,
 		JMP:   1,
 		JE:    1,
 		JNE:   1,
@@ -73,5 +183,55 @@ func (op Opcode) NumOperands() int {
 		NE:    2,
 		LT:    2,
 		LE:    2,
+		NCALL: 1,
+		IN:    1,
+		ITOF:  2,
+		FTOI:  2,
+		CTOI:  2,
+		ITOC:  2,
+		ITOB:  2,
+		BTOI:  2,
+		CTOB:  2,
+		BTOC:  2,
+		FTOB:  2,
+		BTOF:  2,
+		CTOF:  2,
+		FTOC:  2,
+
+		TYPEOF: 2,
+
+		LDF: 3,
+		STF: 3,
+		LDX: 4,
+		STX: 4,
+
+		CMP: 2,
+		JL:  1,
+		JLE: 1,
+		JG:  1,
+		JGE: 1,
+		JB:  1,
+		JBE: 1,
+		JA:  1,
+		JAE: 1,
+		JO:  1,
+		JNO: 1,
+		JS:  1,
+		JNS: 1,
+
+		LEA: 2,
+
+		TRY:    1,
+		ENDTRY: 0,
+		THROW:  1,
+
+		SPAWN: 2,
+		YIELD: 0,
+		JOIN:  1,
+
+		CHAN:  2,
+		SEND:  2,
+		RECV:  2,
+		CLOSE: 1,
 	}[op]
 }
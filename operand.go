@@ -43,25 +43,27 @@ func (s SpecialRegister) Value() int  { return int(s) }
 func (s SpecialRegister) isOperand()  {}
 func (s SpecialRegister) isRegister() {}
 
+// GeneralPurposeRegister r0, r1, ... 数はConfig.Registersで決まる
 type GeneralPurposeRegister int
 
 const (
-	_ GeneralPurposeRegister = iota
+	R0 GeneralPurposeRegister = iota
 	R1
 	R2
 	R3
-	ACM1
-	ACM2
+	ACM1 // r4. 名前はacm1
+	ACM2 // r5. 名前はacm2
 )
 
 func (g GeneralPurposeRegister) String() string {
-	return []string{
-		R1:   "r1",
-		R2:   "r2",
-		R3:   "r3",
-		ACM1: "acm1",
-		ACM2: "acm2",
-	}[g]
+	switch g {
+	case ACM1:
+		return "acm1"
+	case ACM2:
+		return "acm2"
+	default:
+		return fmt.Sprintf("r%d", int(g))
+	}
 }
 func (g GeneralPurposeRegister) Value() int  { return int(g) }
 func (g GeneralPurposeRegister) isOperand()  {}
@@ -72,11 +74,17 @@ type FlagRegister int
 const (
 	_ FlagRegister = iota
 	ZF
+	SF // 符号
+	CF // 符号なしの桁あふれ
+	OF // 符号ありの桁あふれ
 )
 
 func (f FlagRegister) String() string {
 	return []string{
 		ZF: "zf",
+		SF: "sf",
+		CF: "cf",
+		OF: "of",
 	}[f]
 }
 func (f FlagRegister) Value() int  { return int(f) }
@@ -108,6 +116,16 @@ func (c Char) isOperand()          {}
 func (c Char) isStockable()        {}
 func (c Char) Type() PrimitiveType { return TChar }
 
+type Float float64
+
+func (f Float) String() string {
+	return strconv.FormatFloat(float64(f), 'g', -1, 64)
+}
+func (f Float) Value() int          { return int(f) }
+func (f Float) isOperand()          {}
+func (f Float) isStockable()        {}
+func (f Float) Type() PrimitiveType { return TFloat }
+
 type Bool bool
 
 func (b Bool) String() string {
@@ -191,10 +209,11 @@ type ProgramAddress int
 func (p ProgramAddress) String() string {
 	return fmt.Sprintf("@%d", p)
 }
-func (p ProgramAddress) Value() int  { return int(p) }
-func (p ProgramAddress) isOperand()  {}
-func (p ProgramAddress) isLocation() {}
-func (p ProgramAddress) isAddress()  {}
+func (p ProgramAddress) Value() int   { return int(p) }
+func (p ProgramAddress) isOperand()   {}
+func (p ProgramAddress) isStockable() {}
+func (p ProgramAddress) isLocation()  {}
+func (p ProgramAddress) isAddress()   {}
 
 type HeapAddress int
 
@@ -207,6 +226,26 @@ func (h HeapAddress) isStockable() {}
 func (h HeapAddress) isLocation()  {}
 func (h HeapAddress) isAddress()   {}
 
+// Field レコードのフィールド. ベースアドレスからの位置と型を持つ
+type Field struct {
+	Offset int
+	Type   PrimitiveType
+}
+
+func (f Field) String() string { return fmt.Sprintf("(+%d %s)", f.Offset, f.Type) }
+func (f Field) Value() int     { return f.Offset }
+func (f Field) isOperand()     {}
+
+// Element 固定長配列の要素の型と長さ
+type Element struct {
+	Type PrimitiveType
+	Len  int
+}
+
+func (e Element) String() string { return fmt.Sprintf("(%s %d)", e.Type, e.Len) }
+func (e Element) Value() int     { return e.Len }
+func (e Element) isOperand()     {}
+
 type Pointer interface {
 	Location
 	isPointer()
@@ -216,9 +255,20 @@ type BasePointer int
 func (b BasePointer) String() string { return fmt.Sprintf("@%d", b) }
 func (b BasePointer) Value() int     { return int(b) }
 func (b BasePointer) isOperand()     {}
+func (b BasePointer) isStockable()   {}
 func (b BasePointer) isLocation()    {}
 func (b BasePointer) isPointer()     {}
 
+// StackAddress スタック上の位置. LEAで作り, LOAD, STOREで読み書きする
+type StackAddress int
+
+func (s StackAddress) String() string { return fmt.Sprintf("stack@%d", s) }
+func (s StackAddress) Value() int     { return int(s) }
+func (s StackAddress) isOperand()     {}
+func (s StackAddress) isLocation()    {}
+func (s StackAddress) isAddress()     {}
+func (s StackAddress) isStockable()   {}
+
 type StackPointer int
 
 func (s StackPointer) String() string { return fmt.Sprintf("@%d", s) }
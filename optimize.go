@@ -0,0 +1,126 @@
+package gvm
+
+import "slices"
+
+// identities 結果を変えない整数の即値. 消してよいのはフラグを誰も読まないときだけ
+var identities = map[Opcode]Integer{
+	ADD: 0,
+	SUB: 0,
+	MUL: 1,
+	DIV: 1,
+}
+
+// Optimize 覗き穴最適化をしたProgramを返す. programは書き換えない.
+//
+//	mov r, r           -> 消す
+//	push x; pop r      -> mov r, x (pushしたxを読むだけなら消す)
+//	add r, 0 など      -> 次にフラグを読む前にフラグを書き換えるなら消す
+//	jmp, je などで次へ -> 消す
+//
+// 縮んだ分はProgramAddress, ProgramOffsetを付け替える. 型が合わずに起きるはずだったエラーは保たない.
+func Optimize(program Program) (Program, error) {
+	if err := Verify(program); err != nil {
+		return nil, err
+	}
+	p := slices.Clone(program)
+	for {
+		next, changed := peephole(p)
+		if !changed {
+			return next, nil
+		}
+		p = next
+	}
+}
+
+// peephole 1回分の書き換え. 消した命令への参照は次に残った命令へ付け替える
+func peephole(program Program) (Program, bool) {
+	insts := Instructions(program)
+	targets := map[int]bool{}
+	for _, inst := range insts {
+		for _, w := range inst.Operands {
+			switch w.(type) {
+			case ProgramAddress, ProgramOffset:
+				targets[Target(inst.At, w)] = true
+			}
+		}
+	}
+
+	var out Program
+	var emitted []Instruction // outに出した命令. Atは元の位置
+	addrs := make(map[int]int, len(insts)+1)
+	changed := false
+	for i := 0; i < len(insts); i++ {
+		inst := insts[i]
+		addrs[inst.At] = len(out)
+		switch {
+		case inst.Op == MOV && inst.Operands[0] == inst.Operands[1]:
+			changed = true
+			continue
+		case inst.Op == PUSH && i+1 < len(insts) && insts[i+1].Op == POP && !targets[insts[i+1].At]:
+			dst, ok := insts[i+1].Operands[0].(GeneralPurposeRegister)
+			if !ok {
+				break
+			}
+			changed = true
+			i++
+			addrs[insts[i].At] = len(out)
+			if inst.Operands[0] == dst {
+				continue
+			}
+			inst = Instruction{At: inst.At, Op: MOV, Operands: []Word{dst, inst.Operands[0]}}
+		case isIdentity(inst) && flagsDead(insts[i+1:]):
+			changed = true
+			continue
+		case isJump(inst.Op) && inst.Op != TRY && Target(inst.At, inst.Operands[0]) == inst.Next():
+			changed = true
+			continue
+		}
+		emitted = append(emitted, inst)
+		out = append(out, inst.Op)
+		out = append(out, inst.Operands...)
+	}
+	addrs[len(program)] = len(out)
+	if !changed {
+		return program, false
+	}
+
+	// 飛び先の付け替え
+	at := 0
+	for _, inst := range emitted {
+		for i, w := range inst.Operands {
+			switch w := w.(type) {
+			case ProgramAddress:
+				out[at+1+i] = ProgramAddress(addrs[w.Value()])
+			case ProgramOffset:
+				out[at+1+i] = ProgramOffset(addrs[inst.At+w.Value()] - at)
+			}
+		}
+		at += 1 + len(inst.Operands)
+	}
+	return out, true
+}
+
+func isIdentity(inst Instruction) bool {
+	v, ok := identities[inst.Op]
+	return ok && inst.Operands[1] == v
+}
+
+// flagsDead 続く命令がフラグを読む前にすべてのフラグを書き換えるか.
+// 制御が移る命令やフラグレジスタを直接触る命令, Programの終わりに着いたら読まれるものとする
+func flagsDead(insts []Instruction) bool {
+	for _, inst := range insts {
+		switch inst.Op {
+		case ADD, SUB, MUL, DIV, CMP:
+			return true
+		case NOP, MOV, PUSH, POP:
+			for _, w := range inst.Operands {
+				if _, ok := w.(FlagRegister); ok {
+					return false
+				}
+			}
+		default:
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package gvm
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect string
+	}{
+		{"mov self", "mov r1, 1\nmov r1, r1", "mov r1, 1"},
+		{"push pop", "push r1\npop r2", "mov r2, r1"},
+		{"push pop self", "mov r1, 1\npush r1\npop r1", "mov r1, 1"},
+		{"push pop immediate", "push 3\npop r2", "mov r2, 3"},
+		{"push pop offset", "push 1\npush [sp+0]\npop r2\npop r3", "push 1\nmov r2, [sp+0]\npop r3"},
+		{"push pop label", "push r1\nl:\npop r2\npush r2\njmp l", "push r1\nl:\npop r2\npush r2\njmp l"},
+		{"push pop special", "push bp\npop bp", "push bp\npop bp"},
+		{"identity", "mov r1, 1\nadd r1, 0\nmul r1, 1\nsub r1, 0\ndiv r1, 1\nadd r1, 2", "mov r1, 1\nadd r1, 2"},
+		{"identity flags at end", "mov r1, 1\nadd r1, 0", "mov r1, 1\nadd r1, 0"},
+		{"identity flags read", "mov r1, 1\nadd r1, 0\nje l\nmov r2, 1\nl:", "mov r1, 1\nadd r1, 0\nje l\nmov r2, 1\nl:"},
+		{"identity flag register", "mov r1, 1\nadd r1, 0\nmov r2, zf\ncmp r1, 1", "mov r1, 1\nadd r1, 0\nmov r2, zf\ncmp r1, 1"},
+		{"identity float", "mov r1, 1.0\nadd r1, 0.0\ncmp r1, 1.0", "mov r1, 1.0\nadd r1, 0.0\ncmp r1, 1.0"},
+		{"jump next", "jmp l\nl:\nmov r1, 1", "mov r1, 1"},
+		{"conditional jump next", "cmp r1, 1\nje l\nl:\nmov r1, 1", "cmp r1, 1\nmov r1, 1"},
+		{"try next", "try l\nl:\nmov r1, 1", "try l\nl:\nmov r1, 1"},
+		{"retarget", `
+mov r1, 0
+loop:
+    push r1
+    pop r2
+    add r1, 1
+    cmp r1, 10
+    jmp next
+next:
+    jl loop
+call f
+jmp end
+f:
+    mov r3, r3
+    ret
+end:
+`, `
+mov r1, 0
+loop:
+    mov r2, r1
+    add r1, 1
+    cmp r1, 10
+    jl loop
+call f
+jmp end
+f:
+    ret
+end:
+`},
+		{"cascade", "push r2\nmov r1, r1\npop r3", "mov r3, r2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Assemble(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect, err := Assemble(tt.expect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Optimize(program)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(expect, got); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestOptimize_ProgramOffset(t *testing.T) {
+	program := Program{
+		MOV, R1, Integer(0),
+		ADD, R1, Integer(1), // 3
+		MOV, R2, R2,
+		CMP, R1, Integer(5),
+		JL, ProgramOffset(-9), // 12 -> 3
+		JMP, ProgramOffset(2), // 14 -> 16
+	}
+	expect := Program{
+		MOV, R1, Integer(0),
+		ADD, R1, Integer(1),
+		CMP, R1, Integer(5),
+		JL, ProgramOffset(-6),
+	}
+	got, err := Optimize(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func TestOptimize_Error(t *testing.T) {
+	if _, err := Optimize(Program{MOV, R1}); err == nil {
+		t.Error("want verify error")
+	}
+}
+
+// TestOptimize_Differential 最適化の前後で, 実行後のレジスタ(PC以外), スタック, ヒープが一致する
+func TestOptimize_Differential(t *testing.T) {
+	srcs := map[string]string{
+		"loop": `
+mov r1, 0
+mov r2, 0
+loop:
+    push r1
+    pop r3
+    add r3, 0
+    add r2, r3
+    mov r2, r2
+    add r1, 1
+    cmp r1, 10
+    jmp cond
+cond:
+    jl loop
+`,
+		"calls": `
+push 5
+call sq
+pop r2
+push r1
+pop r3
+jmp end
+sq:
+    mov r1, [bp+2]
+    push r1
+    pop r2
+    mul r1, r2
+    mul r1, 1
+    ret
+end:
+`,
+		"heap": `
+alloc 2
+pop r1
+push 7
+pop r2
+store r1, r2
+store @1, 8
+load r3, @1
+add r3, 0
+mul r3, 2
+`,
+		"flags": `
+mov r1, 3
+mov r1, r1
+sub r1, 0
+js neg
+mov r2, 1
+neg:
+`,
+	}
+	for name, src := range srcs {
+		t.Run(name, func(t *testing.T) {
+			program, err := Assemble(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			optimized, err := Optimize(program)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(program) <= len(optimized) {
+				t.Errorf("not optimized: %d -> %d", len(program), len(optimized))
+			}
+			run := func(p Program) *Runtime {
+				r := NewRuntime(p, &Config{StackSize: 16, HeapSize: 4})
+				if err := r.Run(); err != nil {
+					t.Fatal(err)
+				}
+				r.registers[iPC] = value{}
+				return r
+			}
+			before, after := run(program), run(optimized)
+			if diff := cmp.Diff(registerMap(before.registers), registerMap(after.registers)); diff != "" {
+				t.Errorf("registers: %s", diff)
+			}
+			if diff := cmp.Diff(stockables(before.stack), stockables(after.stack)); diff != "" {
+				t.Errorf("stack: %s", diff)
+			}
+			if diff := cmp.Diff(stockables(before.heap), stockables(after.heap)); diff != "" {
+				t.Errorf("heap: %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package gvm
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrPanic jobの実行中にpanicした. 他のjobとworkerには影響しない
+var ErrPanic = errors.New("job panicked")
+
+// Prepared 検査とデコードを済ませたProgram. 変更されないので複数のgoroutineで共有できる
+type Prepared struct {
+	program Program
+	code    *decoded
+	config  Config // 検査したときのConfig. 同じConfigのPoolでだけ実行できる
+}
+
+// Job Poolで実行する1つの仕事
+type Job struct {
+	Program *Prepared
+	Setup   func(r *Runtime) error      // 実行前に入力を置く. nilでよい
+	Done    func(r *Runtime, err error) // 実行後に結果を読む. rはこの呼び出しの間だけ使える. nilでよい
+}
+
+// Pool Runtimeを使い回して, 多数のProgramを限られた数のgoroutineで実行する
+type Pool struct {
+	config Config
+	sem    chan struct{} // 同時に実行している数
+	free   chan *Runtime // 使い回すRuntime
+}
+
+// NewPool workersが0以下ならGOMAXPROCSにする
+func NewPool(config *Config, workers int) *Pool {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &Pool{
+		config: *config,
+		sem:    make(chan struct{}, workers),
+		free:   make(chan *Runtime, workers),
+	}
+}
+
+func (p *Pool) get() *Runtime {
+	select {
+	case r := <-p.free:
+		return r
+	default:
+		return NewRuntime(nil, &p.config)
+	}
+}
+
+func (p *Pool) put(r *Runtime) {
+	select {
+	case p.free <- r:
+	default:
+	}
+}
+
+// Prepare programを複製して検査, デコードする. 以後programを書き換えても影響しない
+func (p *Pool) Prepare(program Program) (*Prepared, error) {
+	program = slices.Clone(program)
+	code, err := decode(program)
+	if err != nil {
+		return nil, err
+	}
+	// 使えるレジスタはConfigで決まるので, ここで一度だけ確かめる
+	r := p.get()
+	defer p.put(r)
+	r.Reset(program)
+	if err := r.checkRegisters(); err != nil {
+		return nil, err
+	}
+	return &Prepared{program: program, code: code, config: p.config}, nil
+}
+
+// Run jobを実行する. 同時に実行されるのはworkers個まで.
+// Setup, 実行, Doneでのpanicは捕まえて, ErrPanicを包んだエラーとして返す.
+// 別のConfigのPoolでPrepareしたProgramは実行しない
+func (p *Pool) Run(job Job) (err error) {
+	if job.Program.config != p.config {
+		return fmt.Errorf("pool: config mismatch: prepared with %+v, pool has %+v", job.Program.config, p.config)
+	}
+	p.sem <- struct{}{}
+	defer func() { <-p.sem }()
+	r := p.get()
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("pool: %w: %v", ErrPanic, v)
+		}
+		// panicしたRuntimeは状態が分からないので使い回さない
+		if !errors.Is(err, ErrPanic) {
+			p.put(r)
+		}
+	}()
+
+	r.Reset(job.Program.program)
+	err = execute(r, job)
+	if job.Done != nil {
+		job.Done(r, err)
+	}
+	return err
+}
+
+// execute Setupと実行. panicはエラーにしてDoneに渡す
+func execute(r *Runtime, job Job) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("pool: %w: %v", ErrPanic, v)
+		}
+	}()
+	if job.Setup != nil {
+		if err := job.Setup(r); err != nil {
+			return err
+		}
+	}
+	return r.runDecoded(job.Program.code)
+}
+
+// Do jobsを並列に実行し, 全て終わるまで待つ. エラーはjobsと同じ順に返す
+func (p *Pool) Do(jobs []Job) []error {
+	errs := make([]error, len(jobs))
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range min(cap(p.sem), len(jobs)) {
+		wg.Go(func() {
+			for {
+				i := int(next.Add(1) - 1)
+				if len(jobs) <= i {
+					return
+				}
+				errs[i] = p.Run(jobs[i])
+			}
+		})
+	}
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,205 @@
+package gvm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRuntime_Reset(t *testing.T) {
+	config := &Config{StackSize: 8, HeapSize: 4}
+	r := NewRuntime(assemble(t, pipeWith(1)), config)
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	stack, heap := &r.stack[0], &r.heap[0]
+
+	program := Program{MOV, R1, Integer(1)}
+	r.Reset(program)
+	if diff := cmp.Diff(NewRuntime(program, config), r, cmp.AllowUnexported(Runtime{}, value{})); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if stack != &r.stack[0] || heap != &r.heap[0] {
+		t.Error("stack and heap should be reused")
+	}
+}
+
+// squareProgram R2*R2+1をR1に入れる
+var squareProgram = Program{
+	MOV, R1, R2,
+	MUL, R1, R2,
+	ADD, R1, Integer(1),
+}
+
+func TestPool_Do(t *testing.T) {
+	p := NewPool(&Config{StackSize: 4}, 4)
+	prepared, err := p.Prepare(squareProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := make([]Operand, 1000)
+	jobs := make([]Job, len(results))
+	for i := range jobs {
+		jobs[i] = Job{
+			Program: prepared,
+			Setup: func(r *Runtime) error {
+				return r.SetRegister(R2, Integer(i))
+			},
+			Done: func(r *Runtime, err error) {
+				results[i] = r.Register(R1)
+			},
+		}
+	}
+	// 失敗するjobがあっても他には影響しない
+	jobs[3].Setup = func(r *Runtime) error { return r.SetRegister(R2, Float(1)) }
+	jobs[5].Setup = func(r *Runtime) error { return r.SetRegister(GeneralPurposeRegister(99), Integer(1)) }
+
+	errs := p.Do(jobs)
+	for i, err := range errs {
+		switch i {
+		case 3:
+			if !errors.Is(err, ErrTypeMismatch) {
+				t.Errorf("%d: want=typemismatch, got=%v", i, err)
+			}
+		case 5:
+			if err == nil || err.Error() != "unknown register: r99" {
+				t.Errorf("%d: want=unknown register, got=%v", i, err)
+			}
+		default:
+			if err != nil {
+				t.Fatalf("%d: %v", i, err)
+			}
+			if diff := cmp.Diff(Integer(i*i+1), results[i]); diff != "" {
+				t.Fatalf("%d: diff: %s", i, diff)
+			}
+		}
+	}
+	if 4 < len(p.free) {
+		t.Errorf("runtimes: want<=4, got=%d", len(p.free))
+	}
+}
+
+func TestPool_Panic(t *testing.T) {
+	p := NewPool(&Config{StackSize: 4}, 2)
+	square, err := p.Prepare(squareProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// NCALLで呼んだ関数がpanicする
+	broken, err := p.Prepare(Program{NCALL, Integer(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var done []error
+	jobs := []Job{
+		{Program: square, Setup: func(r *Runtime) error { panic("setup") }},
+		{Program: broken, Setup: func(r *Runtime) error {
+			r.Attach(&Host{Natives: []Native{{Name: "boom", Fn: func([]Stockable) (Stockable, error) { panic("native") }}}})
+			return nil
+		}, Done: func(r *Runtime, err error) { done = append(done, err) }},
+		{Program: square, Setup: func(r *Runtime) error { return r.SetRegister(R2, Integer(1)) }, Done: func(r *Runtime, err error) { panic("done") }},
+		{Program: square, Setup: func(r *Runtime) error { return r.SetRegister(R2, Integer(2)) }},
+	}
+	tests := []string{
+		"pool: job panicked: setup",
+		"pool: job panicked: native",
+		"pool: job panicked: done",
+		"",
+	}
+	// workerが1つでも止まるとDoが終わらない
+	for i, err := range p.Do(jobs) {
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if diff := cmp.Diff(tests[i], got); diff != "" {
+			t.Errorf("%d: diff: %s", i, diff)
+		}
+	}
+	// Doneには実行中のpanicがエラーとして渡る
+	if len(done) != 1 || !errors.Is(done[0], ErrPanic) {
+		t.Errorf("done: want=[ErrPanic], got=%v", done)
+	}
+}
+
+func TestPool_Prepare(t *testing.T) {
+	p := NewPool(&Config{StackSize: 4, Registers: 2}, 1)
+	program := Program{MOV, R1, Integer(2)}
+	prepared, err := p.Prepare(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Prepareした後に元のProgramを書き換えても影響しない
+	program[2] = Integer(3)
+	var got Operand
+	if err := p.Run(Job{Program: prepared, Done: func(r *Runtime, err error) { got = r.Register(R1) }}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Integer(2), got); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+
+	if _, err := p.Prepare(Program{MOV, R2, Integer(1)}); err == nil || err.Error() != "1: unknown register: r2" {
+		t.Errorf("want=unknown register, got=%v", err)
+	}
+	if _, err := p.Prepare(Program{MOV, R1}); err == nil {
+		t.Error("want=verify error")
+	}
+
+	// レジスタの少ないPoolではr1を確かめていないので実行しない
+	small := NewPool(&Config{StackSize: 4, Registers: 1}, 1)
+	err = small.Run(Job{Program: prepared, Done: func(r *Runtime, err error) { t.Error("done should not be called") }})
+	if err == nil || err.Error() != "pool: config mismatch: prepared with {StackSize:4 HeapSize:0 Registers:2}, pool has {StackSize:4 HeapSize:0 Registers:1}" {
+		t.Errorf("want=config mismatch, got=%v", err)
+	}
+	// 同じConfigなら別のPoolでPrepareしたものも実行できる
+	same := NewPool(&Config{StackSize: 4, Registers: 2}, 1)
+	if err := same.Run(Job{Program: prepared}); err != nil {
+		t.Error(err)
+	}
+}
+
+func BenchmarkPool(b *testing.B) {
+	config := &Config{StackSize: 16, HeapSize: 16}
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r := NewRuntime(squareProgram, config)
+			r.set(R2, Integer(i))
+			if err := r.RunDecoded(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("pool", func(b *testing.B) {
+		b.ReportAllocs()
+		p := NewPool(config, 1)
+		prepared, err := p.Prepare(squareProgram)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < b.N; i++ {
+			err := p.Run(Job{Program: prepared, Setup: func(r *Runtime) error { return r.SetRegister(R2, Integer(i)) }})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("pool/parallel", func(b *testing.B) {
+		b.ReportAllocs()
+		p := NewPool(config, 0)
+		prepared, err := p.Prepare(squareProgram)
+		if err != nil {
+			b.Fatal(err)
+		}
+		job := Job{Program: prepared, Setup: func(r *Runtime) error { return r.SetRegister(R2, Integer(3)) }}
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if err := p.Run(job); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,246 @@
+package gvm
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Profiler 実行された命令をPCと呼び出し中の関数ごとに数える
+type Profiler struct {
+	Symbols map[ProgramAddress]string // 関数の先頭アドレスと名前. 無い関数はアドレスで表す
+
+	total  int64
+	pcs    map[ProgramAddress]int64
+	ops    map[Opcode]int64
+	frames [][]profileFrame // スレッドごとの呼び出し中の関数
+	stacks map[string]*profileSample
+}
+
+type profileFrame struct {
+	entry ProgramAddress // 関数の先頭
+	site  ProgramAddress // 呼び出し元のCALLの位置
+	bp    BasePointer    // フレームのBP. 関数の最初の命令を見るまでは-1
+}
+
+type profileSample struct {
+	pcs     []ProgramAddress // 葉から根へ
+	entries []ProgramAddress
+	count   int64
+}
+
+func NewProfiler(symbols map[ProgramAddress]string) *Profiler {
+	return &Profiler{
+		Symbols: symbols,
+		pcs:     map[ProgramAddress]int64{},
+		ops:     map[Opcode]int64{},
+		frames:  [][]profileFrame{{{entry: 0, bp: -1}}},
+		stacks:  map[string]*profileSample{},
+	}
+}
+
+// Profile 以降に実行される命令をpに記録する. nilなら記録をやめる
+func (r *Runtime) Profile(p *Profiler) {
+	r.profiler = p
+}
+
+// observe 命令を実行する直前に呼ばれる
+func (p *Profiler) observe(r *Runtime) {
+	pc := r.pc()
+	op, ok := r.program[pc].(Opcode)
+	if !ok {
+		return
+	}
+	p.total++
+	p.pcs[pc]++
+	p.ops[op]++
+
+	frames := p.unwind(r)
+	var key strings.Builder
+	sample := &profileSample{pcs: []ProgramAddress{pc}}
+	fmt.Fprintf(&key, "%d", pc)
+	for i := len(frames) - 1; 0 <= i; i-- {
+		sample.entries = append(sample.entries, frames[i].entry)
+		if 0 < i {
+			sample.pcs = append(sample.pcs, frames[i].site)
+			fmt.Fprintf(&key, ",%d", frames[i].site)
+		}
+	}
+	if s, ok := p.stacks[key.String()]; ok {
+		s.count++
+	} else {
+		sample.count = 1
+		p.stacks[key.String()] = sample
+	}
+
+	switch op {
+	case CALL:
+		entry := ProgramAddress(Target(pc.Value(), r.program[pc+1]))
+		frames = append(frames, profileFrame{entry: entry, site: pc, bp: -1})
+	case RET:
+		if 1 < len(frames) {
+			frames = frames[:len(frames)-1]
+		}
+	}
+	p.frames[r.current] = frames
+}
+
+// unwind 実行中のスレッドの呼び出し中の関数. 初めて見るスレッドはPCの関数から始める.
+// THROWや捕捉したフォールトで外側の関数のハンドラへ飛んでいれば, BPが一致するフレームまで戻す
+func (p *Profiler) unwind(r *Runtime) []profileFrame {
+	for len(p.frames) <= r.current {
+		p.frames = append(p.frames, nil)
+	}
+	frames := p.frames[r.current]
+	if len(frames) == 0 {
+		frames = []profileFrame{{entry: r.pc(), bp: r.bp()}}
+	}
+	if top := &frames[len(frames)-1]; top.bp < 0 {
+		if r.pc() != top.entry && 1 < len(frames) {
+			// CALLが失敗して呼び出し先に入らなかった
+			frames = frames[:len(frames)-1]
+		} else {
+			top.bp = r.bp()
+		}
+	}
+	for 1 < len(frames) && frames[len(frames)-1].bp != r.bp() {
+		frames = frames[:len(frames)-1]
+	}
+	return frames
+}
+
+func (p *Profiler) name(entry ProgramAddress) string {
+	if name, ok := p.Symbols[entry]; ok {
+		return name
+	}
+	return entry.String()
+}
+
+// WriteHistogram opcodeごとの実行回数を多い順に書き出す
+func (p *Profiler) WriteHistogram(w io.Writer) error {
+	ops := make([]Opcode, 0, len(p.ops))
+	for op := range p.ops {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if p.ops[ops[i]] != p.ops[ops[j]] {
+			return p.ops[ops[i]] > p.ops[ops[j]]
+		}
+		return ops[i] < ops[j]
+	})
+	for _, op := range ops {
+		percent := float64(p.ops[op]) * 100 / float64(p.total)
+		if _, err := fmt.Fprintf(w, "%-6s %10d %6.2f%%\n", op.String(), p.ops[op], percent); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%-6s %10d\n", "total", p.total)
+	return err
+}
+
+// protobufの最低限のエンコーダ
+type protobuf []byte
+
+func (b protobuf) varint(field int, v uint64) protobuf {
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+func (b protobuf) bytes(field int, v []byte) protobuf {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func (b protobuf) packed(field int, vs []uint64) protobuf {
+	var inner []byte
+	for _, v := range vs {
+		inner = binary.AppendUvarint(inner, v)
+	}
+	return b.bytes(field, inner)
+}
+
+// WritePprof pprofのprofile.proto形式(gzip圧縮)で書き出す. go tool pprofで読める
+func (p *Profiler) WritePprof(w io.Writer) error {
+	strs := []string{""}
+	index := map[string]uint64{"": 0}
+	str := func(s string) uint64 {
+		if i, ok := index[s]; ok {
+			return i
+		}
+		index[s] = uint64(len(strs))
+		strs = append(strs, s)
+		return index[s]
+	}
+
+	var out protobuf
+	valueType := protobuf{}.varint(1, str("instructions")).varint(2, str("count"))
+	out = out.bytes(1, valueType)
+
+	// 関数は先頭アドレス, ロケーションは(PC, 関数)ごとに一つ
+	functions := map[ProgramAddress]uint64{}
+	type locKey struct{ pc, entry ProgramAddress }
+	locations := map[locKey]uint64{}
+	var functionMsgs, locationMsgs []protobuf
+	function := func(entry ProgramAddress) uint64 {
+		if id, ok := functions[entry]; ok {
+			return id
+		}
+		id := uint64(len(functions) + 1)
+		functions[entry] = id
+		msg := protobuf{}.varint(1, id).
+			varint(2, str(p.name(entry))).
+			varint(3, str(p.name(entry))).
+			varint(4, str("program")).
+			varint(5, uint64(entry))
+		functionMsgs = append(functionMsgs, msg)
+		return id
+	}
+	location := func(pc, entry ProgramAddress) uint64 {
+		key := locKey{pc, entry}
+		if id, ok := locations[key]; ok {
+			return id
+		}
+		id := uint64(len(locations) + 1)
+		locations[key] = id
+		line := protobuf{}.varint(1, function(entry)).varint(2, uint64(pc))
+		msg := protobuf{}.varint(1, id).varint(3, uint64(pc)).bytes(4, line)
+		locationMsgs = append(locationMsgs, msg)
+		return id
+	}
+
+	keys := make([]string, 0, len(p.stacks))
+	for key := range p.stacks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := p.stacks[key]
+		ids := make([]uint64, len(s.pcs))
+		for i := range s.pcs {
+			ids[i] = location(s.pcs[i], s.entries[i])
+		}
+		sample := protobuf{}.packed(1, ids).packed(2, []uint64{uint64(s.count)})
+		out = out.bytes(2, sample)
+	}
+	for _, msg := range locationMsgs {
+		out = out.bytes(4, msg)
+	}
+	for _, msg := range functionMsgs {
+		out = out.bytes(5, msg)
+	}
+	for _, s := range strs {
+		out = out.bytes(6, []byte(s))
+	}
+	out = out.bytes(11, valueType)
+	out = out.varint(12, 1)
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(out); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,247 @@
+package gvm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func profiledProgram(t *testing.T) (Program, map[ProgramAddress]string) {
+	objects := []*Object{
+		{
+			Name: "main",
+			Code: Program{
+				MOV, R2, Integer(0),
+				PUSH, R2, // 3
+				CALL, ProgramAddress(0),
+				POP, R3,
+				ADD, R2, Integer(1),
+				LT, R2, Integer(3),
+				JE, ProgramAddress(3),
+				JMP, ProgramAddress(26), // libの後ろ(終了)
+			},
+			Imports:     []string{"inc"},
+			Relocations: []Relocation{{At: 6, Symbol: "inc"}, {At: 16}},
+		},
+		{
+			Name: "lib",
+			Code: Program{
+				MOV, R1, BpOffset(2),
+				ADD, R1, Integer(1),
+				RET,
+			},
+			Exports: []Symbol{{Name: "inc", Kind: TextSymbol}},
+		},
+	}
+	program, err := Link(objects...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbols, err := Symbols(objects...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbols[0] = "main"
+	return program, symbols
+}
+
+func TestProfiler_WriteHistogram(t *testing.T) {
+	program, symbols := profiledProgram(t)
+	r := NewRuntime(program, &Config{StackSize: 8})
+	p := NewProfiler(symbols)
+	r.Profile(p)
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := p.WriteHistogram(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if diff := cmp.Diff([]string{
+		"add             6  20.69%",
+		"mov             4  13.79%",
+		"push            3  10.34%",
+		"pop             3  10.34%",
+		"call            3  10.34%",
+		"ret             3  10.34%",
+		"je              3  10.34%",
+		"lt              3  10.34%",
+		"jmp             1   3.45%",
+		"total          29",
+	}, lines); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+// fields protobufのメッセージを(フィールド番号, 値)の列にする. 数値はvarintのみ扱う
+func fields(t *testing.T, msg []byte) [][2]any {
+	var out [][2]any
+	rd := bytes.NewReader(msg)
+	for rd.Len() > 0 {
+		key, err := binary.ReadUvarint(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch key & 7 {
+		case 0:
+			v, err := binary.ReadUvarint(rd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, [2]any{int(key >> 3), v})
+		case 2:
+			n, err := binary.ReadUvarint(rd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := make([]byte, n)
+			if _, err := io.ReadFull(rd, b); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, [2]any{int(key >> 3), b})
+		default:
+			t.Fatalf("unexpected wire type: %d", key&7)
+		}
+	}
+	return out
+}
+
+func TestProfiler_WritePprof(t *testing.T) {
+	program, symbols := profiledProgram(t)
+	r := NewRuntime(program, &Config{StackSize: 8})
+	p := NewProfiler(symbols)
+	r.Profile(p)
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := p.WritePprof(&buf); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var strs []string
+	var total uint64
+	functions := 0
+	for _, f := range fields(t, raw) {
+		switch f[0] {
+		case 2: // sample
+			for _, sf := range fields(t, f[1].([]byte)) {
+				if sf[0] == 2 {
+					v, _ := binary.Uvarint(sf[1].([]byte))
+					total += v
+				}
+			}
+		case 5: // function
+			functions++
+		case 6: // string_table
+			strs = append(strs, string(f[1].([]byte)))
+		}
+	}
+	if total != 29 {
+		t.Errorf("total: want=29, got=%d", total)
+	}
+	if functions != 2 {
+		t.Errorf("functions: want=2, got=%d", functions)
+	}
+	if diff := cmp.Diff([]string{"", "instructions", "count", "main", "program", "inc"}, strs); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+// entriesAt pcで記録したサンプルの, 葉から根への関数の先頭
+func entriesAt(p *Profiler, pc ProgramAddress) [][]ProgramAddress {
+	var entries [][]ProgramAddress
+	for _, s := range p.stacks {
+		if s.pcs[0] == pc {
+			entries = append(entries, s.entries)
+		}
+	}
+	return entries
+}
+
+func TestProfiler_Frames(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		pc     ProgramAddress
+		expect [][]ProgramAddress
+	}{
+		{
+			// 外側のハンドラへ飛んだらfのフレームは無くなる
+			"throw",
+			`
+    try catch
+    call f
+    endtry
+catch:
+    mov r2, 1
+    jmp end
+f:
+    throw 7
+end:`,
+			5,
+			[][]ProgramAddress{{0}},
+		},
+		{
+			"caught call",
+			`
+    try catch
+    call f
+    endtry
+catch:
+    mov r2, 1
+    jmp end
+f:
+    call f
+end:`,
+			5,
+			[][]ProgramAddress{{0}},
+		},
+		{
+			// mainがfの中でYIELDしても, wはw自身のフレームで数える
+			"threads",
+			`
+    spawn w, 0
+    call f
+    jmp end
+f:
+    yield
+    ret
+w:
+    mov r2, 1
+    ret
+end:`,
+			9,
+			[][]ProgramAddress{{9}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRuntime(assemble(t, tt.src), &Config{StackSize: 16})
+			p := NewProfiler(nil)
+			r.Profile(p)
+			if err := r.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expect, entriesAt(p, tt.pc)); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
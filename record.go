@@ -0,0 +1,94 @@
+package gvm
+
+import "fmt"
+
+// heapBase LDF/STF/LDX/STXのベースアドレス
+func (r *Runtime) heapBase(operand Operand) (int, error) {
+	switch op := operand.(type) {
+	case Register:
+		v := r.get(op)
+		if v.kind != kindHeapAddress {
+			return 0, fmt.Errorf("heap: invalid base: %v", v.operand())
+		}
+		return int(v.bits), nil
+	case HeapAddress:
+		return op.Value(), nil
+	default:
+		return 0, fmt.Errorf("heap: invalid base: %s", operand.String())
+	}
+}
+
+// checkType 値がフィールド, 要素の型と一致するか. 未初期化(nil)は読み出しのみ許す
+func checkType(t PrimitiveType, v Operand) error {
+	imm, ok := v.(Immediate)
+	if !ok || imm.Type() != t {
+		return fmt.Errorf("%w: want=%s, got=%v", ErrTypeMismatch, t, v)
+	}
+	return nil
+}
+
+// access LDF, STF, LDX, STXを実行する
+//
+//	LDF dst, base, field
+//	STF base, field, src
+//	LDX dst, base, index, element
+//	STX base, index, element, src
+func (r *Runtime) access(op Opcode, operands []Operand) error {
+	var base Operand
+	var dst Register
+	var src Operand
+	switch op {
+	case LDF, LDX:
+		reg, ok := operands[0].(Register)
+		if !ok {
+			return fmt.Errorf("heap: invalid %s dst: %s", op, operands[0].String())
+		}
+		dst, base = reg, operands[1]
+		operands = operands[2:]
+	case STF, STX:
+		base, src = operands[0], r.value(operands[len(operands)-1])
+		operands = operands[1 : len(operands)-1]
+	}
+
+	addr, err := r.heapBase(base)
+	if err != nil {
+		return err
+	}
+	var t PrimitiveType
+	switch op {
+	case LDF, STF:
+		field := operands[0].(Field)
+		addr += field.Offset
+		t = field.Type
+	case LDX, STX:
+		elem := operands[1].(Element)
+		idx, ok := r.value(operands[0]).(Integer)
+		if !ok {
+			return fmt.Errorf("heap: invalid index: %v", r.value(operands[0]))
+		}
+		if idx < 0 || elem.Len <= int(idx) {
+			return fmt.Errorf("%w: %d, len=%d", ErrIndexOutOfRange, idx, elem.Len)
+		}
+		addr += int(idx)
+		t = elem.Type
+	}
+	if addr < 0 || len(r.heap) <= addr {
+		return fmt.Errorf("heap: %w: %d", ErrOutOfBounds, addr)
+	}
+
+	if dst != nil {
+		v := r.load(HeapAddress(addr))
+		if v.kind != kindNil {
+			if err := checkType(t, v.operand()); err != nil {
+				return err
+			}
+		}
+		r.put(dst, v)
+		return nil
+	}
+	if err := checkType(t, src); err != nil {
+		return err
+	}
+	r.store(HeapAddress(addr), valueOf(src.(Stockable)))
+	return nil
+}
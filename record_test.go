@@ -0,0 +1,146 @@
+package gvm
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAssemble_Record(t *testing.T) {
+	program, err := Assemble(`
+.record point(x: integer, y: float)
+.array buf: char[16]
+    alloc point
+    pop r1
+    stf r1, point.y, 1.5
+    ldf r2, r1, point.x
+    alloc buf
+    stx @2, r3, buf, r2
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Program{
+		ALLOC, Integer(2),
+		POP, R1,
+		STF, R1, Field{Offset: 1, Type: TFloat}, Float(1.5),
+		LDF, R2, R1, Field{Offset: 0, Type: TInteger},
+		ALLOC, Element{Type: TChar, Len: 16},
+		STX, HeapAddress(2), R3, Element{Type: TChar, Len: 16}, R2,
+	}, program); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func TestRuntime_Record(t *testing.T) {
+	const header = `
+.record point(x: integer, y: float, ok: bool)
+.array word: char[3]
+`
+	tests := []struct {
+		name   string
+		src    string
+		reg    Register
+		expect Operand
+		err    string
+	}{
+		{
+			"field",
+			`
+    alloc point
+    pop r1
+    stf r1, point.y, 2.5
+    stf r1, point.x, 7
+    ldf r2, r1, point.y
+    ldf r3, r1, point.x`,
+			R2, Float(2.5), "",
+		},
+		{
+			"uninitialized field",
+			"alloc point\npop r1\nldf r2, r1, point.ok",
+			R2, nil, "",
+		},
+		{
+			"field typemismatch",
+			"alloc point\npop r1\nstf r1, point.ok, 1",
+			R1, HeapAddress(0), "typemismatch: want=bool, got=1",
+		},
+		{
+			"load typemismatch",
+			"store @2, 1\nldf r2, @0, point.ok",
+			R2, nil, "typemismatch: want=bool, got=1",
+		},
+		{
+			"element",
+			`
+    alloc word
+    pop r1
+    mov r3, 0
+loop:
+    itoc r2, r3
+    stx r1, r3, word, r2
+    add r3, 1
+    lt r3, 3
+    je loop
+    ldx r2, r1, 2, word`,
+			R2, Char(2), "",
+		},
+		{
+			"index out of range",
+			"alloc word\npop r1\nstx r1, 3, word, 1",
+			R1, HeapAddress(0), "index out of range: 3, len=3",
+		},
+		{
+			"negative index",
+			"mov r3, -1\nldx r2, @0, r3, word",
+			R2, nil, "index out of range: -1, len=3",
+		},
+		{
+			"invalid base",
+			"mov r1, 0\nldf r2, r1, point.x",
+			R2, nil, "heap: invalid base: 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Assemble(header + tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := NewRuntime(program, &Config{StackSize: 4, HeapSize: 8})
+			err = r.Run()
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.err {
+				t.Errorf("want=%q, got=%q", tt.err, got)
+			}
+			if diff := cmp.Diff(tt.expect, r.Register(tt.reg)); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestAssemble_RecordError(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect string
+	}{
+		{"directive", ".struct p(x: integer)", "asm: 1: unknown directive: struct"},
+		{"type", ".record p(x: int)", "asm: 1: unknown type: int"},
+		{"field", ".record p(x: integer)\nldf r1, r2, p.y", "asm: 2: undefined field: p.y"},
+		{"duplicate field", ".record p(x: integer, x: bool)", "asm: 1: duplicate field: x"},
+		{"duplicate type", ".record p()\n.array p: bool[1]", "asm: 2: duplicate type: p"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Assemble(tt.src)
+			if err == nil || err.Error() != tt.expect {
+				t.Errorf("want=%q, got=%v", tt.expect, err)
+			}
+		})
+	}
+}
@@ -4,103 +4,245 @@ import (
 	"fmt"
 )
 
+// Config Runtimeの大きさ. フィールドは増えることがあるので, リテラルはキー付きで書く
 type Config struct {
 	StackSize int
 	HeapSize  int
+	Registers int // 汎用レジスタの数. 0ならDefaultRegisters
 }
 
+// DefaultRegisters 汎用レジスタの既定の数. r0からacm2(r5)まで
+const DefaultRegisters = 6
+
 type Runtime struct {
 	program   Program
-	registers map[Register]Operand
-	stack     []Stockable
-	heap      []Stockable
+	registers []value // regIndexの位置に並べる. 汎用レジスタはiR0からConfig.Registersの数だけ
+	stack     []value
+	heap      []value
+	host      *session
+	profiler  *Profiler
+	handlers  []handler
+	threads   []*thread // SPAWNするまではnil
+	current   int
+	channels  []*channel
 }
 
 func NewRuntime(program Program, config *Config) *Runtime {
-	regs := map[Register]Operand{
-		// Specials
-		PC: ProgramAddress(0),
-		BP: BasePointer(0),
-		SP: StackPointer(config.StackSize - 1),
-		HP: HeapAddress(0),
-		// GeneralPurposes
-		R1:   nil,
-		R2:   nil,
-		R3:   nil,
-		ACM1: nil,
-		ACM2: nil,
-		// Flags
-		ZF: Bool(false),
-	}
-	return &Runtime{
+	n := config.Registers
+	if n <= 0 {
+		n = DefaultRegisters
+	}
+	r := &Runtime{
 		program:   program,
-		registers: regs,
-		stack:     make([]Stockable, config.StackSize),
-		heap:      make([]Stockable, config.HeapSize),
+		registers: make([]value, iR0+n),
+		stack:     make([]value, config.StackSize),
+		heap:      make([]value, config.HeapSize),
+	}
+	r.resetRegisters()
+	return r
+}
+
+// resetRegisters 汎用レジスタをnilに, 特殊レジスタとフラグを初期値にする
+func (r *Runtime) resetRegisters() {
+	clear(r.registers)
+	r.setPC(0)
+	r.setBP(0)
+	r.setSP(StackPointer(len(r.stack) - 1))
+	r.setHP(0)
+	for i := iZF; i <= iOF; i++ {
+		r.registers[i] = boolValue(false)
+	}
+}
+
+// Reset programを最初から実行できる状態に戻す. レジスタ, スタック, ヒープは確保し直さずに使い回す.
+// ハンドラ, スレッド, チャネルは捨て, AttachやProfileも外す
+func (r *Runtime) Reset(program Program) {
+	if r.threads != nil {
+		r.switchTo(0)
+	}
+	r.resetRegisters()
+	clear(r.stack)
+	clear(r.heap)
+	r.program = program
+	r.host, r.profiler = nil, nil
+	r.handlers, r.threads, r.current, r.channels = nil, nil, 0, nil
+}
+
+// registerIndex レジスタ配列regs上の位置. regsにないレジスタならfalse
+func registerIndex(regs []value, reg Register) (int, bool) {
+	ok := false
+	switch reg := reg.(type) {
+	case SpecialRegister:
+		ok = PC <= reg && reg <= HP
+	case FlagRegister:
+		ok = ZF <= reg && reg <= OF
+	case GeneralPurposeRegister:
+		ok = 0 <= reg
+	}
+	if !ok {
+		return 0, false
 	}
+	i := regIndex(reg)
+	return i, i < len(regs)
+}
+
+// Register レジスタの値
+func (r *Runtime) Register(reg Register) Operand {
+	i, ok := registerIndex(r.registers, reg)
+	if !ok {
+		return nil
+	}
+	return r.registers[i].operand()
+}
+
+// SetRegister レジスタに値を入れる. 実行前に入力を渡すのに使う. PC, BP, SP, HPには同じ種類の値しか入れられない
+func (r *Runtime) SetRegister(reg Register, v Operand) error {
+	i, ok := registerIndex(r.registers, reg)
+	if !ok {
+		return fmt.Errorf("unknown register: %s", reg.String())
+	}
+	if i < len(pointerKinds) && valueOf(v).kind != pointerKinds[i] {
+		return fmt.Errorf("%w: %s: %v", ErrTypeMismatch, reg, v)
+	}
+	r.registers[i] = valueOf(v)
+	return nil
 }
 
 func (r *Runtime) Run() error {
-	for r.pc().Value() < len(r.program) {
-		switch word := r.program[r.pc()]; word.(type) {
-		case Opcode:
-			err := r.do()
-			if err != nil {
-				return err
+	if err := Verify(r.program); err != nil {
+		return err
+	}
+	if err := r.checkRegisters(); err != nil {
+		return err
+	}
+	if err := r.loop(); err != nil {
+		return err
+	}
+	if r.host != nil {
+		return r.host.finish()
+	}
+	return nil
+}
+
+// checkRegisters Programが使うレジスタがすべてこのRuntimeにあるか
+func (r *Runtime) checkRegisters() error {
+	for at, w := range r.program {
+		if reg, ok := w.(Register); ok {
+			if _, ok := registerIndex(r.registers, reg); !ok {
+				return fmt.Errorf("%d: unknown register: %s", at, reg.String())
 			}
-		default:
-			return fmt.Errorf("unsupported word: %s", word.String())
 		}
 	}
 	return nil
 }
 
-func (r *Runtime) typecheck(o1, o2 Operand) bool {
-	switch o1.(type) {
-	case Register:
-		switch o2.(type) {
-		case Register:
-			return r.typecheck(r.registers[o1.(Register)], r.registers[o2.(Register)])
-		case Immediate:
-			return r.typecheck(r.registers[o1.(Register)], o2)
+func (r *Runtime) loop() error {
+	for !r.Halted() {
+		if err := r.Step(); err != nil {
+			return err
 		}
-	case Immediate:
-		switch o2.(type) {
-		case Register:
-			return r.typecheck(o2, r.registers[o2.(Register)])
-		case Immediate:
-			return o1.(Immediate).Type() == o2.(Immediate).Type()
+	}
+	return nil
+}
+
+// Halted PCがProgramの終わりに達したかどうか. スレッドがあれば全スレッドが終わったかどうか
+func (r *Runtime) Halted() bool {
+	if r.pc().Value() < len(r.program) {
+		return false
+	}
+	for i := range r.threads {
+		if !r.finished(i) {
+			return false
 		}
 	}
-	return false
+	return true
 }
 
-func (r *Runtime) isPrimitive(primitive PrimitiveType, operand Operand) bool {
-	switch operand.(type) {
-	case Register:
-		return r.isPrimitive(primitive, r.registers[operand.(Register)])
-	case Immediate:
-		return primitive == operand.(Immediate).Type()
+// Step 一命令だけ実行する. 検査は行わないので, 必要なら先にVerifyを呼ぶ
+func (r *Runtime) Step() error {
+	if r.Halted() {
+		return nil
+	}
+	if r.host != nil {
+		defer func() { r.host.steps++ }()
+	}
+	if r.profiler != nil {
+		r.profiler.observe(r)
+	}
+	switch word := r.program[r.pc()]; word.(type) {
+	case Opcode:
+		if err := r.catch(r.do()); err != nil {
+			return err
+		}
+		return r.reschedule()
 	default:
-		return false
+		return fmt.Errorf("unsupported word: %s", word.String())
+	}
+}
+
+// get レジスタの値
+func (r *Runtime) get(reg Register) value {
+	return r.registers[regIndex(reg)]
+}
+
+// put レジスタに値を入れる
+func (r *Runtime) put(reg Register, v value) {
+	r.registers[regIndex(reg)] = v
+}
+
+// pointerKinds PC, BP, SP, HPに入れられる値の種類
+var pointerKinds = [...]kind{iPC: kindProgramAddress, iBP: kindBasePointer, iSP: kindStackPointer, iHP: kindHeapAddress}
+
+// assign MOV, POPでレジスタに値を入れる. PC, BP, SP, HPには同じ種類の値しか入れられない
+func (r *Runtime) assign(op Opcode, reg Register, v value) error {
+	if i := regIndex(reg); i < len(pointerKinds) && v.kind != pointerKinds[i] {
+		return fmt.Errorf("%w: %s %s: %v", ErrTypeMismatch, op, reg, v.operand())
 	}
+	r.put(reg, v)
+	return nil
 }
 
 func (r *Runtime) set(reg Register, operand Operand) {
-	r.registers[reg] = operand
+	r.put(reg, valueOf(operand))
 }
 
+// pc, bp, sp, hp 特殊レジスタを読む. 別の型はassignとSetRegisterが入れさせないので, 入っていればpanicする
 func (r *Runtime) pc() ProgramAddress {
-	return r.registers[PC].(ProgramAddress)
+	if v := r.registers[iPC]; v.kind == kindProgramAddress {
+		return ProgramAddress(v.bits)
+	}
+	panic(fmt.Sprintf("pc: not a ProgramAddress: %v", r.registers[iPC].operand()))
 }
 func (r *Runtime) bp() BasePointer {
-	return r.registers[BP].(BasePointer)
+	if v := r.registers[iBP]; v.kind == kindBasePointer {
+		return BasePointer(v.bits)
+	}
+	panic(fmt.Sprintf("bp: not a BasePointer: %v", r.registers[iBP].operand()))
 }
 func (r *Runtime) sp() StackPointer {
-	return r.registers[SP].(StackPointer)
+	if v := r.registers[iSP]; v.kind == kindStackPointer {
+		return StackPointer(v.bits)
+	}
+	panic(fmt.Sprintf("sp: not a StackPointer: %v", r.registers[iSP].operand()))
 }
 func (r *Runtime) hp() HeapAddress {
-	return r.registers[HP].(HeapAddress)
+	if v := r.registers[iHP]; v.kind == kindHeapAddress {
+		return HeapAddress(v.bits)
+	}
+	panic(fmt.Sprintf("hp: not a HeapAddress: %v", r.registers[iHP].operand()))
+}
+
+func (r *Runtime) setPC(pc ProgramAddress) {
+	r.registers[iPC] = makeValue(kindProgramAddress, int(pc))
+}
+func (r *Runtime) setBP(bp BasePointer) {
+	r.registers[iBP] = makeValue(kindBasePointer, int(bp))
+}
+func (r *Runtime) setSP(sp StackPointer) {
+	r.registers[iSP] = makeValue(kindStackPointer, int(sp))
+}
+func (r *Runtime) setHP(hp HeapAddress) {
+	r.registers[iHP] = makeValue(kindHeapAddress, int(hp))
 }
 
 func (r *Runtime) calcOffset(offset Offset) int {
@@ -114,54 +256,189 @@ func (r *Runtime) calcOffset(offset Offset) int {
 	}
 }
 
-func (r *Runtime) push(stockable Stockable) {
-	r.set(SP, r.sp()-1)
-	if r.sp() < 0 {
-		panic("stack overflow")
+// slot Offsetが指すスタック上の位置. スタックの外はエラー
+func (r *Runtime) slot(offset Offset) (int, error) {
+	at := r.calcOffset(offset)
+	if at < 0 || len(r.stack) <= at {
+		return 0, fmt.Errorf("stack: %w: %s, at=%d", ErrOutOfBounds, offset, at)
+	}
+	return at, nil
+}
+
+func (r *Runtime) push(v value) error {
+	if r.sp() <= 0 {
+		return fmt.Errorf("stack: %w: overflow", ErrOutOfBounds)
+	}
+	r.setSP(r.sp() - 1)
+	r.stack[r.sp()] = v
+	return nil
+}
+func (r *Runtime) pop() (value, error) {
+	sp := r.sp().Value()
+	if sp < 0 || len(r.stack) <= sp {
+		return value{}, fmt.Errorf("stack: %w: underflow", ErrOutOfBounds)
+	}
+	v := r.stack[sp]
+	r.stack[sp] = value{}
+	r.setSP(r.sp() + 1)
+	return v, nil
+}
+
+// stockable スタック, ヒープに置くレジスタの値. nilなど置けない値はエラー
+func (r *Runtime) stockable(op Opcode, reg Register) (value, error) {
+	v := r.get(reg)
+	if !v.stockable() {
+		return value{}, fmt.Errorf("%w: %s %s: %v", ErrTypeMismatch, op, reg, v.operand())
 	}
-	r.stack[r.sp()] = stockable
+	return v, nil
 }
-func (r *Runtime) pop() Stockable {
-	v := r.stack[r.sp()]
-	r.stack[r.sp()] = nil
-	r.set(SP, r.sp()+1)
-	return v
+
+// number レジスタの値をサイズやアドレスとして読む. nilはエラー
+func (r *Runtime) number(op Opcode, reg Register) (int, error) {
+	v := r.get(reg)
+	if v.kind == kindNil {
+		return 0, fmt.Errorf("%w: %s %s: %v", ErrTypeMismatch, op, reg, nil)
+	}
+	return v.int(), nil
+}
+
+// stackSlot StackAddressが指すスタック上の位置. 生きている領域[sp, len)の外はエラー
+func (r *Runtime) stackSlot(addr StackAddress) (int, error) {
+	if addr.Value() < r.sp().Value() || len(r.stack) <= addr.Value() {
+		return 0, fmt.Errorf("stack: %w: %s, sp=%d", ErrOutOfBounds, addr, r.sp())
+	}
+	return addr.Value(), nil
 }
 
-func (r *Runtime) store(addr HeapAddress, stockable Stockable) {
+func (r *Runtime) store(addr HeapAddress, v value) {
 	if 0 <= addr.Value() && addr.Value() < len(r.heap) {
-		r.heap[addr.Value()] = stockable
+		r.heap[addr.Value()] = v
 		return
 	}
 	panic("heap: out of bounds") // 不法侵入
 }
-func (r *Runtime) load(addr HeapAddress) Stockable {
+func (r *Runtime) load(addr HeapAddress) value {
 	if 0 <= addr.Value() && addr.Value() < len(r.heap) {
 		return r.heap[addr.Value()]
 	}
 	panic("heap: out of bounds")
 }
 
-func (r *Runtime) add(dst Register, src Operand) error {
+var operators = map[Opcode]string{
+	ADD: "+",
+	SUB: "-",
+	MUL: "*",
+	DIV: "/",
+	EQ:  "==",
+	NE:  "!=",
+	LT:  "<",
+	LE:  "<=",
+}
+
+// sameType 同じ型の即値どうしか
+func sameType(x, y value) bool {
+	return x.immediate() && x.kind == y.kind
+}
+
+// arith ADD, SUB, MUL, DIV dst, src. yはsrcの値
+func (r *Runtime) arith(op Opcode, dst Register, y value) error {
+	x := r.get(dst)
 	// 一致チェック
-	if !r.typecheck(dst, src) {
-		return fmt.Errorf("typemismatch: %s + %s", dst.String(), src.String())
+	if !sameType(x, y) {
+		return fmt.Errorf("%w: %v %s %v", ErrTypeMismatch, dst, operators[op], y.operand())
+	}
+	switch x.kind {
+	case kindInteger:
+		x, y := Integer(x.bits), Integer(y.bits)
+		var z Integer
+		switch op {
+		case ADD:
+			z = x + y
+		case SUB:
+			z = x - y
+		case MUL:
+			z = x * y
+		case DIV:
+			if y == 0 {
+				return fmt.Errorf("%w: %v / %v", ErrDivisionByZero, dst, y)
+			}
+			z = x / y
+		}
+		r.put(dst, makeValue(kindInteger, int(z)))
+		r.setFlags(intFlags(op, x, y, z))
+	case kindFloat:
+		x, y := Float(x.float()), Float(y.float())
+		var z Float
+		switch op {
+		case ADD:
+			z = x + y
+		case SUB:
+			z = x - y
+		case MUL:
+			z = x * y
+		case DIV:
+			z = x / y
+		}
+		r.put(dst, floatValue(float64(z)))
+		r.setFlags(floatFlags(z))
+	default:
+		// 数字かどうかチェック
+		return fmt.Errorf("%w: invalid %s value: %s", ErrTypeMismatch, op.String(), dst.String())
+	}
+	return nil
+}
+
+// value レジスタなら中身を, それ以外はそのまま返す
+func (r *Runtime) value(operand Operand) Operand {
+	if reg, ok := operand.(Register); ok {
+		return r.get(reg).operand()
 	}
-	// 数字かどうかチェック
-	if !r.isPrimitive(TInteger, dst) {
-		return fmt.Errorf("invalid add value: %s", dst.String())
+	return operand
+}
+
+// read valueと同じだが, 箱に入れずにvalueで返す
+func (r *Runtime) read(operand Operand) value {
+	if reg, ok := operand.(Register); ok {
+		return r.get(reg)
 	}
-	//
-	switch src.(type) {
-	case Register:
-		r.registers[dst] = Integer(r.registers[dst].(Integer).Value() + r.registers[src.(Register)].(Integer).Value())
-		return nil
-	case Integer:
-		r.registers[dst] = Integer(r.registers[dst].(Integer).Value() + src.(Integer).Value())
+	return valueOf(operand)
+}
+
+// compare EQ, NE, LT, LE o1, o2. 結果をZFに入れ, SF, CF, OFは下ろす
+func (r *Runtime) compare(op Opcode, o1, o2 Operand) error {
+	x, y := r.read(o1), r.read(o2)
+	if !sameType(x, y) {
+		return fmt.Errorf("%w: %v %s %v", ErrTypeMismatch, o1, operators[op], o2)
+	}
+	var result bool
+	if x.kind == kindFloat {
+		f1, f2 := x.float(), y.float()
+		switch op {
+		case EQ:
+			result = f1 == f2
+		case NE:
+			result = f1 != f2
+		case LT:
+			result = f1 < f2
+		case LE:
+			result = f1 <= f2
+		}
+		r.setFlags(flags{zf: result})
 		return nil
-	default:
-		return fmt.Errorf("unsupported add src: %s", src.String())
 	}
+	v1, v2 := x.int(), y.int()
+	switch op {
+	case EQ:
+		result = v1 == v2
+	case NE:
+		result = v1 != v2
+	case LT:
+		result = v1 < v2
+	case LE:
+		result = v1 <= v2
+	}
+	r.setFlags(flags{zf: result})
+	return nil
 }
 
 func (r *Runtime) do() error {
@@ -169,37 +446,50 @@ func (r *Runtime) do() error {
 	case Opcode:
 		switch word {
 		case NOP:
-			defer func() { r.set(PC, r.pc()+1+ProgramAddress(word.NumOperands())) }()
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
 			return nil
 		case MOV:
-			defer func() { r.set(PC, r.pc()+1+ProgramAddress(word.NumOperands())) }()
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
 			dst := r.program[r.pc()+1].(Operand)
 			src := r.program[r.pc()+2].(Operand)
 			switch dst.(type) {
 			case Register: // ex) mov r1, ??
 				switch src.(type) {
 				case Register:
-					r.registers[dst.(Register)] = r.registers[src.(Register)]
-					return nil
+					return r.assign(word, dst.(Register), r.get(src.(Register)))
 				case Offset:
-					r.registers[dst.(Register)] = r.stack[r.calcOffset(src.(Offset))]
-					return nil
-				case Immediate:
-					r.registers[dst.(Register)] = src
-					return nil
+					at, err := r.slot(src.(Offset))
+					if err != nil {
+						return err
+					}
+					return r.assign(word, dst.(Register), r.stack[at])
+				case Immediate, Address:
+					return r.assign(word, dst.(Register), valueOf(src))
 				default:
 					return fmt.Errorf("unsupported mov src: %s", word.String())
 				}
 			case Offset:
+				at, err := r.slot(dst.(Offset))
+				if err != nil {
+					return err
+				}
 				switch src.(type) {
 				case Register:
-					r.stack[r.calcOffset(dst.(Offset))] = r.registers[src.(Register)].(Stockable)
+					v, err := r.stockable(word, src.(Register))
+					if err != nil {
+						return err
+					}
+					r.stack[at] = v
 					return nil
 				case Offset:
-					r.stack[r.calcOffset(dst.(Offset))] = r.stack[r.calcOffset(src.(Offset))]
+					from, err := r.slot(src.(Offset))
+					if err != nil {
+						return err
+					}
+					r.stack[at] = r.stack[from]
 					return nil
 				case Immediate:
-					r.stack[r.calcOffset(dst.(Offset))] = src.(Stockable)
+					r.stack[at] = valueOf(src.(Stockable))
 					return nil
 				default:
 					return fmt.Errorf("unsupported mov src: %s", word.String())
@@ -208,85 +498,121 @@ func (r *Runtime) do() error {
 				return fmt.Errorf("unsupported mov dstAddr: %s", word.String())
 			}
 		case PUSH:
-			defer func() { r.set(PC, r.pc()+1+ProgramAddress(word.NumOperands())) }()
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
 			src, ok := r.program[r.pc()+1].(Operand)
 			if !ok {
 				return fmt.Errorf("invalid push src: want=operand, got=%s", word.String())
 			}
 			switch src.(type) {
 			case Register:
-				r.push(r.registers[src.(Register)].(Stockable))
-				return nil
+				v, err := r.stockable(word, src.(Register))
+				if err != nil {
+					return err
+				}
+				return r.push(v)
 			case Offset:
-				r.push(r.stack[r.calcOffset(src.(Offset))])
-				return nil
+				at, err := r.slot(src.(Offset))
+				if err != nil {
+					return err
+				}
+				return r.push(r.stack[at])
 			case Immediate:
-				r.push(src.(Stockable))
-				return nil
+				return r.push(valueOf(src.(Stockable)))
 			default:
 				return fmt.Errorf("unsupported push src: %s", src.String())
 			}
 		case POP:
-			defer func() { r.set(PC, r.pc()+1+ProgramAddress(word.NumOperands())) }()
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
 			dst, ok := r.program[r.pc()+1].(Operand)
 			if !ok {
 				return fmt.Errorf("invalid pop dstAddr: want=operand, got=%s", word.String())
 			}
 			switch dst := dst.(type) {
 			case Register:
-				r.registers[dst] = r.pop()
-				return nil
+				v, err := r.pop()
+				if err != nil {
+					return err
+				}
+				return r.assign(word, dst, v)
 			default:
 				return fmt.Errorf("invalid pop dstAddr: %s", word.String())
 			}
 		case ALLOC:
-			defer func() { r.set(PC, r.pc()+1+ProgramAddress(word.NumOperands())) }()
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
 			var size int
 			switch op := r.program[r.pc()+1].(type) {
 			case Register:
-				size = r.registers[op.(Register)].Value()
+				n, err := r.number(word, op.(Register))
+				if err != nil {
+					return err
+				}
+				size = n
 			case Integer:
 				size = op.Value()
+			case Element:
+				size = op.Value()
 			default:
 				return fmt.Errorf("heap: invalid alloc size: %s", op.String())
 			}
 			if len(r.heap) <= r.hp().Value()+size {
-				panic("heap: out of memory")
+				return fmt.Errorf("heap: %w: out of memory", ErrOutOfBounds)
 			}
 			base := r.hp()
-			r.set(HP, HeapAddress(r.hp().Value()+size))
-			r.push(base)
+			if err := r.push(makeValue(kindHeapAddress, int(base))); err != nil {
+				return err
+			}
+			r.setHP(base + HeapAddress(size))
 			return nil
 		case STORE:
 			// Store DstHeapAddr Src
-			defer func() { r.set(PC, r.pc()+1+ProgramAddress(word.NumOperands())) }()
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
 			dst := r.program[r.pc()+1]
 			var dstAddr int
 			switch op := dst.(type) {
 			case Register:
-				dstAddr = r.registers[op.(Register)].Value()
+				if addr := r.get(op); addr.kind == kindStackAddress {
+					at, err := r.stackSlot(StackAddress(addr.bits))
+					if err != nil {
+						return err
+					}
+					v := r.read(r.program[r.pc()+2].(Operand))
+					if !v.stockable() {
+						return fmt.Errorf("unsupported store src: %v", r.program[r.pc()+2])
+					}
+					r.stack[at] = v
+					return nil
+				}
+				n, err := r.number(word, op)
+				if err != nil {
+					return err
+				}
+				dstAddr = n
 			case HeapAddress:
 				dstAddr = op.Value()
 			default:
 				return fmt.Errorf("heap: invalid store dst: %s", dst.String())
 			}
 			if dstAddr < 0 || len(r.heap) <= dstAddr {
-				panic("heap: memory access out of bounds")
+				return fmt.Errorf("heap: %w: %d", ErrOutOfBounds, dstAddr)
 			}
 			src := r.program[r.pc()+2]
 			switch src.(type) {
 			case Register:
-				r.store(HeapAddress(dstAddr), r.registers[src.(Register)].(Stockable))
+				v, err := r.stockable(word, src.(Register))
+				if err != nil {
+					return err
+				}
+				r.store(HeapAddress(dstAddr), v)
 				return nil
 			case Immediate:
-				r.store(HeapAddress(dstAddr), src.(Stockable))
+				r.store(HeapAddress(dstAddr), valueOf(src.(Stockable)))
 				return nil
 			default:
 				return fmt.Errorf("unsupported store src: %s", src.String())
 			}
 		case LOAD:
 			// Load Dst SrcHeapAddr
-			defer func() { r.set(PC, r.pc()+1+ProgramAddress(word.NumOperands())) }()
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
 			dst, ok := r.program[r.pc()+1].(Register)
 			if !ok {
 				return fmt.Errorf("heap: invalid load dst: %s", dst.String())
@@ -295,34 +621,250 @@ func (r *Runtime) do() error {
 			var srcAddr int
 			switch op := src.(type) {
 			case Register:
-				srcAddr = r.registers[op.(Register)].Value()
+				if addr := r.get(op); addr.kind == kindStackAddress {
+					at, err := r.stackSlot(StackAddress(addr.bits))
+					if err != nil {
+						return err
+					}
+					r.put(dst, r.stack[at])
+					return nil
+				}
+				n, err := r.number(word, op)
+				if err != nil {
+					return err
+				}
+				srcAddr = n
+			case HeapAddress:
+				srcAddr = op.Value()
 			case Immediate:
 				srcAddr = op.Value()
 			default:
 				return fmt.Errorf("heap: invalid load src: %s", src.String())
 			}
 			if srcAddr < 0 || len(r.heap) <= srcAddr {
-				panic("heap: memory access out of bounds")
+				return fmt.Errorf("heap: %w: %d", ErrOutOfBounds, srcAddr)
 			}
-			r.registers[dst] = r.load(HeapAddress(srcAddr))
+			r.put(dst, r.load(HeapAddress(srcAddr)))
 			return nil
-		case ADD:
-			defer func() { r.set(PC, r.pc()+1+ProgramAddress(word.NumOperands())) }()
+		case ADD, SUB, MUL, DIV:
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
 			dst := r.program[r.pc()+1]
 			src := r.program[r.pc()+2]
 			switch dst.(type) {
 			case Register:
 				switch src.(type) {
-				case Register:
-					return r.add(dst.(Register), r.registers[src.(Register)])
-				case Integer:
-					return r.add(dst.(Register), src.(Operand))
+				case Register, Integer, Float:
+					return r.arith(word, dst.(Register), r.read(src.(Operand)))
 				default:
-					return fmt.Errorf("unsupported add src: %s", src.String())
+					return fmt.Errorf("unsupported %s src: %s", word.String(), src.String())
 				}
 			default:
-				return fmt.Errorf("unsupported add dst: %s", dst.String())
+				return fmt.Errorf("unsupported %s dst: %s", word.String(), dst.String())
+			}
+		case JMP:
+			r.setPC(ProgramAddress(Target(r.pc().Value(), r.program[r.pc()+1])))
+			return nil
+		case JE, JNE, JL, JLE, JG, JGE, JB, JBE, JA, JAE, JO, JNO, JS, JNS:
+			f, ok := r.flags()
+			if !ok {
+				defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+				return fmt.Errorf("%w: invalid %s flag: %v, %v, %v, %v", ErrTypeMismatch, word.String(), r.Register(ZF), r.Register(SF), r.Register(CF), r.Register(OF))
+			}
+			if conditions[word](f) {
+				r.setPC(ProgramAddress(Target(r.pc().Value(), r.program[r.pc()+1])))
+				return nil
+			}
+			r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands()))
+			return nil
+		case TRY:
+			// Try Catch: 現在のSP, BPでハンドラを設置する
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+			r.handlers = append(r.handlers, handler{
+				catch: ProgramAddress(Target(r.pc().Value(), r.program[r.pc()+1])),
+				sp:    r.sp(),
+				bp:    r.bp(),
+			})
+			return nil
+		case ENDTRY:
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+			if _, ok := r.popHandler(); !ok {
+				return fmt.Errorf("endtry: no handler")
+			}
+			return nil
+		case THROW:
+			var v Stockable
+			switch src := r.program[r.pc()+1].(type) {
+			case Register:
+				v = r.get(src).stock()
+			case Offset:
+				at, err := r.slot(src)
+				if err != nil {
+					return err
+				}
+				v = r.stack[at].stock()
+			case Stockable:
+				v = src
+			}
+			if r.throw(v) {
+				return nil
+			}
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+			return &ThrowError{Value: v}
+		case SPAWN:
+			// Spawn Entry Arg: 新しいスレッドの番号をR1に入れる
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+			entry := ProgramAddress(Target(r.pc().Value(), r.program[r.pc()+1]))
+			var arg Stockable
+			switch src := r.program[r.pc()+2].(type) {
+			case Register:
+				arg = r.get(src).stock()
+			case Offset:
+				at, err := r.slot(src)
+				if err != nil {
+					return err
+				}
+				arg = r.stack[at].stock()
+			case Stockable:
+				arg = src
+			}
+			id, err := r.spawn(entry, arg)
+			if err != nil {
+				return err
+			}
+			r.set(R1, id)
+			return nil
+		case YIELD:
+			r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands()))
+			if r.threads == nil {
+				return nil
+			}
+			return r.schedule()
+		case JOIN:
+			// Join Thread: 終わるまで他のスレッドを実行し, 終わったらそのR1を受け取る
+			v, done, err := r.join(r.value(r.program[r.pc()+1].(Operand)))
+			if err != nil {
+				defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+				return err
+			}
+			if !done {
+				// PCはJOINのまま. 再開したらもう一度JOINする
+				return r.schedule()
+			}
+			r.set(R1, v)
+			r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands()))
+			return nil
+		case CHAN:
+			// Chan Dst Cap: ヒープにチャネルを置き, その位置をDstに入れる
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+			at, err := r.makeChannel(r.program[r.pc()+2].(Operand))
+			if err != nil {
+				return err
+			}
+			r.set(r.program[r.pc()+1].(Register), at)
+			return nil
+		case SEND:
+			// Send Chan Src: 送り手が止まる場合もPCは進めておき, 受け取られたら再開する
+			r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands()))
+			at := r.pc() - 1 - ProgramAddress(word.NumOperands())
+			c, err := r.channel(r.program[at+1].(Operand))
+			if err != nil {
+				return err
+			}
+			return r.send(c, r.read(r.program[at+2].(Operand)).stock())
+		case RECV:
+			// Recv Dst Chan: 受け取ったらZFをtrue, 閉じていて空ならDstをnil, ZFをfalseにする
+			c, err := r.channel(r.program[r.pc()+2].(Operand))
+			if err != nil {
+				defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+				return err
+			}
+			v, ok, blocked := r.recv(c)
+			if blocked {
+				// PCはRECVのまま. 再開したらもう一度RECVする
+				return r.schedule()
+			}
+			r.set(r.program[r.pc()+1].(Register), v)
+			r.setFlags(flags{zf: ok})
+			r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands()))
+			return nil
+		case CLOSE:
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+			c, err := r.channel(r.program[r.pc()+1].(Operand))
+			if err != nil {
+				return err
+			}
+			return r.closeChannel(c)
+		case LEA:
+			// Lea Dst Offset: スタック上の位置をStackAddressにする
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+			dst := r.program[r.pc()+1].(Register)
+			r.put(dst, makeValue(kindStackAddress, r.calcOffset(r.program[r.pc()+2].(Offset))))
+			return nil
+		case CMP:
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+			return r.cmp(r.program[r.pc()+1].(Operand), r.program[r.pc()+2].(Operand))
+		case EQ, NE, LT, LE:
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+			return r.compare(word, r.program[r.pc()+1].(Operand), r.program[r.pc()+2].(Operand))
+		case CALL:
+			// 戻り先とBPを積んで, BPを新しいフレームの先頭にする
+			// [bp+0]: 呼び出し元のBP, [bp+1]: 戻り先, [bp+2]~: 引数
+			ret := r.pc() + 1 + ProgramAddress(word.NumOperands())
+			// 片方だけ積んだ状態でcatchに渡さないよう, 先に2つ分の空きを確かめる
+			if r.sp() < 2 {
+				return fmt.Errorf("stack: %w: overflow", ErrOutOfBounds)
+			}
+			r.stack[r.sp()-1] = makeValue(kindProgramAddress, int(ret))
+			r.stack[r.sp()-2] = makeValue(kindBasePointer, int(r.bp()))
+			r.setSP(r.sp() - 2)
+			r.setBP(BasePointer(r.sp()))
+			r.setPC(ProgramAddress(Target(r.pc().Value(), r.program[r.pc()+1])))
+			return nil
+		case RET:
+			r.setSP(StackPointer(r.bp()))
+			bp, err := r.pop()
+			if err != nil || bp.kind != kindBasePointer {
+				return fmt.Errorf("ret: broken frame: %s", r.bp().String())
+			}
+			ret, err := r.pop()
+			if err != nil || ret.kind != kindProgramAddress {
+				return fmt.Errorf("ret: broken frame: %s", r.bp().String())
+			}
+			r.registers[iBP] = bp
+			r.registers[iPC] = ret
+			return nil
+		case ITOF, FTOI, CTOI, ITOC, ITOB, BTOI, CTOB, BTOC, FTOB, BTOF, CTOF, FTOC:
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+			dst, ok := r.program[r.pc()+1].(Register)
+			if !ok {
+				return fmt.Errorf("invalid %s dst: %s", word.String(), r.program[r.pc()+1].String())
+			}
+			return r.convert(word, dst, r.program[r.pc()+2].(Operand))
+		case TYPEOF:
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+			dst, ok := r.program[r.pc()+1].(Register)
+			if !ok {
+				return fmt.Errorf("invalid %s dst: %s", word.String(), r.program[r.pc()+1].String())
+			}
+			r.set(dst, typeOf(r.value(r.program[r.pc()+2].(Operand))))
+			return nil
+		case LDF, STF, LDX, STX:
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+			operands := make([]Operand, word.NumOperands())
+			for i := range operands {
+				operands[i] = r.program[r.pc()+1+ProgramAddress(i)].(Operand)
+			}
+			return r.access(word, operands)
+		case NCALL:
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+			return r.ncall(r.program[r.pc()+1].(Operand).Value())
+		case IN:
+			defer func() { r.setPC(r.pc() + 1 + ProgramAddress(word.NumOperands())) }()
+			dst, ok := r.program[r.pc()+1].(Register)
+			if !ok {
+				return fmt.Errorf("invalid in dst: %s", r.program[r.pc()+1].String())
 			}
+			return r.in(dst)
 		default:
 			return fmt.Errorf("unsupported opcode: %s", word.String())
 		}
@@ -1,35 +1,65 @@
 package gvm
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 )
 
+// state 比べるためにRuntimeのレジスタ, スタック, ヒープをOperandに戻したもの
+type state struct {
+	program   Program
+	registers map[Register]Operand
+	stack     []Stockable
+	heap      []Stockable
+}
+
+func stateOf(r *Runtime) *state {
+	return &state{
+		program:   r.program,
+		registers: registerMap(r.registers),
+		stack:     stockables(r.stack),
+		heap:      stockables(r.heap),
+	}
+}
+
+func registerMap(regs []value) map[Register]Operand {
+	m := make(map[Register]Operand, len(regs))
+	for i, v := range regs {
+		m[registerAt(i)] = v.operand()
+	}
+	return m
+}
+
 func TestRuntime_Run(t *testing.T) {
 	tests := []struct {
 		name   string
 		prog   Program
 		config *Config
-		result *Runtime
+		result *state
 	}{
 		{
 			"init",
 			[]Word{},
-			&Config{2, 2},
-			&Runtime{
+			&Config{StackSize: 2, HeapSize: 2},
+			&state{
 				program: nil,
 				registers: map[Register]Operand{
 					PC:   ProgramAddress(0),
 					BP:   BasePointer(0),
 					SP:   StackPointer(2 - 1),
 					HP:   HeapAddress(0),
+					R0:   nil,
 					R1:   nil,
 					R2:   nil,
 					R3:   nil,
 					ACM1: nil,
 					ACM2: nil,
 					ZF:   Bool(false),
+					SF:   Bool(false),
+					CF:   Bool(false),
+					OF:   Bool(false),
 				},
 				stack: []Stockable{nil, nil},
 				heap:  []Stockable{nil, nil},
@@ -41,20 +71,24 @@ func TestRuntime_Run(t *testing.T) {
 				PUSH, Integer(99),
 				POP, R3,
 			},
-			&Config{2, 0},
-			&Runtime{
+			&Config{StackSize: 2},
+			&state{
 				program: nil,
 				registers: map[Register]Operand{
 					PC:   ProgramAddress(4),
 					BP:   BasePointer(0),
 					SP:   StackPointer(1),
 					HP:   HeapAddress(0),
+					R0:   nil,
 					R1:   nil,
 					R2:   nil,
 					R3:   Integer(99),
 					ACM1: nil,
 					ACM2: nil,
 					ZF:   Bool(false),
+					SF:   Bool(false),
+					CF:   Bool(false),
+					OF:   Bool(false),
 				},
 				stack: []Stockable{nil, nil},
 				heap:  []Stockable{},
@@ -67,20 +101,24 @@ func TestRuntime_Run(t *testing.T) {
 				POP, R3,
 				MOV, R1, R3,
 			},
-			&Config{2, 0},
-			&Runtime{
+			&Config{StackSize: 2},
+			&state{
 				program: nil,
 				registers: map[Register]Operand{
 					PC:   ProgramAddress(7),
 					BP:   BasePointer(0),
 					SP:   StackPointer(1),
 					HP:   HeapAddress(0),
+					R0:   nil,
 					R1:   Integer(99),
 					R2:   nil,
 					R3:   Integer(99),
 					ACM1: nil,
 					ACM2: nil,
 					ZF:   Bool(false),
+					SF:   Bool(false),
+					CF:   Bool(false),
+					OF:   Bool(false),
 				},
 				stack: []Stockable{nil, nil},
 				heap:  []Stockable{},
@@ -96,20 +134,24 @@ func TestRuntime_Run(t *testing.T) {
 				STORE, R1, R2, // R1(addr)にR2の値(42)をstore
 				LOAD, R3, R1, // R1(addr)からloadしてR3へ
 			},
-			&Config{4, 4},
-			&Runtime{
+			&Config{StackSize: 4, HeapSize: 4},
+			&state{
 				program: nil,
 				registers: map[Register]Operand{
 					PC:   ProgramAddress(14),
 					BP:   BasePointer(0),
 					SP:   StackPointer(3),
 					HP:   HeapAddress(1),
+					R0:   nil,
 					R1:   HeapAddress(0),
 					R2:   Integer(42),
 					R3:   Integer(42),
 					ACM1: nil,
 					ACM2: nil,
 					ZF:   Bool(false),
+					SF:   Bool(false),
+					CF:   Bool(false),
+					OF:   Bool(false),
 				},
 				stack: []Stockable{nil, nil, nil, nil},
 				heap:  []Stockable{Integer(42), nil, nil, nil},
@@ -124,20 +166,24 @@ func TestRuntime_Run(t *testing.T) {
 				POP, R2,
 				ADD, R1, R2,
 			},
-			&Config{2, 0},
-			&Runtime{
+			&Config{StackSize: 2},
+			&state{
 				program: nil,
 				registers: map[Register]Operand{
 					PC:   ProgramAddress(11),
 					BP:   BasePointer(0),
 					SP:   StackPointer(1),
 					HP:   HeapAddress(0),
+					R0:   nil,
 					R1:   Integer(15),
 					R2:   Integer(5),
 					R3:   nil,
 					ACM1: nil,
 					ACM2: nil,
 					ZF:   Bool(false),
+					SF:   Bool(false),
+					CF:   Bool(false),
+					OF:   Bool(false),
 				},
 				stack: []Stockable{nil, nil},
 				heap:  []Stockable{},
@@ -150,20 +196,24 @@ func TestRuntime_Run(t *testing.T) {
 				POP, R1,
 				ADD, R1, Integer(3),
 			},
-			&Config{2, 0},
-			&Runtime{
+			&Config{StackSize: 2},
+			&state{
 				program: nil,
 				registers: map[Register]Operand{
 					PC:   ProgramAddress(7),
 					BP:   BasePointer(0),
 					SP:   StackPointer(1),
 					HP:   HeapAddress(0),
+					R0:   nil,
 					R1:   Integer(10),
 					R2:   nil,
 					R3:   nil,
 					ACM1: nil,
 					ACM2: nil,
 					ZF:   Bool(false),
+					SF:   Bool(false),
+					CF:   Bool(false),
+					OF:   Bool(false),
 				},
 				stack: []Stockable{nil, nil},
 				heap:  []Stockable{},
@@ -180,9 +230,113 @@ func TestRuntime_Run(t *testing.T) {
 			}
 
 			tt.result.program = tt.prog
-			if diff := cmp.Diff(tt.result, r, cmp.AllowUnexported(Runtime{})); diff != "" {
+			if diff := cmp.Diff(tt.result, stateOf(r), cmp.AllowUnexported(state{})); diff != "" {
 				t.Errorf("diff: %s", diff)
 			}
 		})
 	}
 }
+
+func TestRuntime_Float(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		reg    Register
+		expect Operand
+		err    string
+	}{
+		{"add", "mov r1, 1.5\nadd r1, 2.25", R1, Float(3.75), ""},
+		{"sub", "mov r1, 1.5\nmov r2, 2.0\nsub r1, r2", R1, Float(-0.5), ""},
+		{"mul", "mov r1, 1.5\nmul r1, 4.0", R1, Float(6), ""},
+		{"div", "mov r1, 1.0\ndiv r1, 4.0", R1, Float(0.25), ""},
+		{"div integer", "mov r1, 7\ndiv r1, 2", R1, Integer(3), ""},
+		{"div zero", "mov r1, 7\ndiv r1, 0", R1, Integer(7), "division by zero: r1 / 0"},
+		{"typemismatch", "mov r1, 1.0\nadd r1, 1", R1, Float(1), "typemismatch: r1 + 1"},
+		{"lt", "mov r1, 0.5\nlt r1, 0.75", ZF, Bool(true), ""},
+		{"le", "mov r1, 0.5\nle r1, -0.5", ZF, Bool(false), ""},
+		{"itof", "mov r1, 3\nitof r2, r1", R2, Float(3), ""},
+		{"ftoi", "ftoi r1, -2.75", R1, Integer(-2), ""},
+		{"ftoi result", "ftoi r1, 1.0\nmul r1, 1.0", R1, Integer(1), "typemismatch: r1 * 1"},
+		{"ftoi nan", "mov r1, 0.0\ndiv r1, 0.0\nftoi r2, r1", R2, Integer(0), ""},
+		{"ctoi", "mov r1, 5\nitoc r2, r1\nctoi r3, r2", R3, Integer(5), ""},
+		{"itoc out of range", "itoc r1, -1", R1, Char(0xfffd), ""},
+		{"conversion typemismatch", "ftoi r1, 1", R1, nil, "typemismatch: ftoi 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Assemble(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := NewRuntime(program, &Config{StackSize: 2})
+			err = r.Run()
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.err {
+				t.Errorf("want=%q, got=%q", tt.err, got)
+			}
+			if diff := cmp.Diff(tt.expect, r.Register(tt.reg)); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+
+			// デコード済みの経路でも同じ結果になる
+			d := NewRuntime(program, &Config{StackSize: 2})
+			_ = d.RunDecoded()
+			equateNaN := cmp.Comparer(func(x, y Float) bool { return x == y || x != x && y != y })
+			if diff := cmp.Diff(r, d, cmp.AllowUnexported(Runtime{}, value{}), equateNaN); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestRuntime_Registers(t *testing.T) {
+	program, err := Assemble(`
+    mov r0, 1
+    mov r31, 2
+    add r31, r0
+    mov acm1, r31
+    mov r1, r4
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"run", "decoded"} {
+		t.Run(name, func(t *testing.T) {
+			r := NewRuntime(program, &Config{StackSize: 2, Registers: 32})
+			run := r.Run
+			if name == "decoded" {
+				run = r.RunDecoded
+			}
+			if err := run(); err != nil {
+				t.Fatal(err)
+			}
+			if len(r.registers) != 8+32 {
+				t.Errorf("registers: want=%d, got=%d", 8+32, len(r.registers))
+			}
+			if diff := cmp.Diff(Integer(3), r.Register(R1)); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+
+	r := NewRuntime(program, &Config{StackSize: 2})
+	if err := r.Run(); err == nil || err.Error() != "4: unknown register: r31" {
+		t.Errorf("want=unknown register, got=%v", err)
+	}
+	if err := r.RunDecoded(); err == nil || err.Error() != "4: unknown register: r31" {
+		t.Errorf("want=unknown register, got=%v", err)
+	}
+}
+
+func TestRuntime_SetRegister(t *testing.T) {
+	r := NewRuntime(Program{}, &Config{StackSize: 2})
+	if err := r.SetRegister(SP, Integer(1)); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("sp: want=typemismatch, got=%v", err)
+	}
+	if err := r.SetRegister(HP, HeapAddress(0)); err != nil {
+		t.Errorf("hp: %v", err)
+	}
+}
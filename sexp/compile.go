@@ -0,0 +1,465 @@
+package sexp
+
+import (
+	"fmt"
+
+	"github.com/x0y14/gvm"
+)
+
+var arithmetic = map[string]gvm.Opcode{
+	"+": gvm.ADD,
+	"-": gvm.SUB,
+	"*": gvm.MUL,
+	"/": gvm.DIV,
+}
+
+// comparisons 比較と, 成り立つときに飛ぶ命令
+var comparisons = map[string]gvm.Opcode{
+	"=":  gvm.JE,
+	"!=": gvm.JNE,
+	"<":  gvm.JL,
+	"<=": gvm.JLE,
+	">":  gvm.JG,
+	">=": gvm.JGE,
+}
+
+// forms 特殊形式. 関数の名前にはできない
+var forms = map[string]bool{
+	"define": true,
+	"if":     true,
+	"let":    true,
+	"begin":  true,
+	"and":    true,
+	"or":     true,
+	"not":    true,
+}
+
+type function struct {
+	name   string
+	params []string
+	body   []Value
+}
+
+// scope letと関数の引数の束縛
+type scope struct {
+	vars   map[string]gvm.BpOffset
+	parent *scope
+}
+
+func (s *scope) lookup(name string) (gvm.BpOffset, bool) {
+	for ; s != nil; s = s.parent {
+		if at, ok := s.vars[name]; ok {
+			return at, true
+		}
+	}
+	return 0, false
+}
+
+type label int
+
+type compiler struct {
+	program gvm.Program
+	funcs   map[string]*function
+	defs    []*function // 定義した順
+	entries map[string]int
+	calls   map[int]string // CALLのオペランドの位置と, 呼び出す関数
+	labels  []int
+	fixups  map[int]label
+	depth   int // 実行中のフレームでBPより下に積んでいる数
+}
+
+// Compile S式のプログラムをProgramにする.
+// トップレベルの(define (f x ...) body ...)で関数を定義し, それ以外の式を順に評価して最後の値をR1に残す.
+//
+//	(define (fact n)
+//	  (if (<= n 1) 1 (* n (fact (- n 1)))))
+//	(let ((x 5)) (fact x))
+//
+// 値はすべてInteger. 真偽は0と1で表し, ifは0以外を真とする.
+// 使える形式: define, if, let, begin, and, or, not, + - * / %, = != < <= > >=, 関数呼び出し.
+// 引数は後ろから積んで[bp+2+i]に, letの束縛は評価した順にスタックへ積む.
+func Compile(src string) (gvm.Program, error) {
+	values, err := Read(src)
+	if err != nil {
+		return nil, err
+	}
+	c := &compiler{
+		funcs:   map[string]*function{},
+		entries: map[string]int{},
+		calls:   map[int]string{},
+		fixups:  map[int]label{},
+	}
+	var main []Value
+	for _, v := range values {
+		if !isForm(v, "define") {
+			main = append(main, v)
+			continue
+		}
+		fn, err := c.define(v.(*List))
+		if err != nil {
+			return nil, err
+		}
+		c.funcs[fn.name] = fn
+		c.defs = append(c.defs, fn)
+	}
+
+	// トップレベルの式は引数のない関数として呼び出す
+	end := c.newLabel()
+	c.emit(gvm.CALL, gvm.ProgramAddress(0))
+	c.jump(gvm.JMP, end)
+	c.program[1] = gvm.ProgramAddress(len(c.program))
+	if err := c.body(&function{body: main}); err != nil {
+		return nil, err
+	}
+	for _, fn := range c.defs {
+		c.entries[fn.name] = len(c.program)
+		if err := c.body(fn); err != nil {
+			return nil, err
+		}
+	}
+	c.mark(end)
+
+	for at, name := range c.calls {
+		c.program[at] = gvm.ProgramAddress(c.entries[name])
+	}
+	for at, l := range c.fixups {
+		c.program[at] = gvm.ProgramAddress(c.labels[l])
+	}
+	return c.program, nil
+}
+
+func isForm(v Value, name string) bool {
+	l, ok := v.(*List)
+	if !ok || len(l.Items) == 0 {
+		return false
+	}
+	head, ok := l.Items[0].(*Symbol)
+	return ok && head.Name == name
+}
+
+// define (define (name params ...) body ...)
+func (c *compiler) define(l *List) (*function, error) {
+	if len(l.Items) < 3 {
+		return nil, errorf(l.Line, "define: want (define (name params ...) body ...), got %s", l)
+	}
+	sig, ok := l.Items[1].(*List)
+	if !ok || len(sig.Items) == 0 {
+		return nil, errorf(l.Line, "define: invalid signature: %s", l.Items[1])
+	}
+	var names []string
+	for _, v := range sig.Items {
+		sym, ok := v.(*Symbol)
+		if !ok {
+			return nil, errorf(l.Line, "define: invalid name: %s", v)
+		}
+		names = append(names, sym.Name)
+	}
+	name, params := names[0], names[1:]
+	if forms[name] || arithmetic[name] != 0 || comparisons[name] != 0 || name == "%" {
+		return nil, errorf(l.Line, "define: reserved name: %s", name)
+	}
+	if _, ok := c.funcs[name]; ok {
+		return nil, errorf(l.Line, "define: duplicate function: %s", name)
+	}
+	seen := map[string]bool{}
+	for _, p := range params {
+		if seen[p] {
+			return nil, errorf(l.Line, "define: duplicate param: %s", p)
+		}
+		seen[p] = true
+	}
+	return &function{name: name, params: params, body: l.Items[2:]}, nil
+}
+
+func (c *compiler) emit(words ...gvm.Word) {
+	c.program = append(c.program, words...)
+}
+
+func (c *compiler) newLabel() label {
+	c.labels = append(c.labels, -1)
+	return label(len(c.labels) - 1)
+}
+
+func (c *compiler) mark(l label) {
+	c.labels[l] = len(c.program)
+}
+
+func (c *compiler) jump(op gvm.Opcode, l label) {
+	c.emit(op, nil)
+	c.fixups[len(c.program)-1] = l
+}
+
+func (c *compiler) push() {
+	c.emit(gvm.PUSH, gvm.R1)
+	c.depth++
+}
+
+// pop 積んだ値を捨てる. R1は変えない
+func (c *compiler) pop(n int) {
+	for range n {
+		c.emit(gvm.POP, gvm.R2)
+	}
+	c.depth -= n
+}
+
+func (c *compiler) body(fn *function) error {
+	sc := &scope{vars: map[string]gvm.BpOffset{}}
+	for i, p := range fn.params {
+		sc.vars[p] = gvm.BpOffset(2 + i)
+	}
+	c.depth = 0
+	if err := c.sequence(fn.body, sc); err != nil {
+		return err
+	}
+	c.emit(gvm.RET)
+	return nil
+}
+
+// sequence 式を順に評価し, 最後の値をR1に残す. 空なら0
+func (c *compiler) sequence(body []Value, sc *scope) error {
+	if len(body) == 0 {
+		c.emit(gvm.MOV, gvm.R1, gvm.Integer(0))
+		return nil
+	}
+	for _, v := range body {
+		if err := c.expr(v, sc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expr 式の値をR1に入れる. R2は作業用
+func (c *compiler) expr(v Value, sc *scope) error {
+	switch v := v.(type) {
+	case *Int:
+		c.emit(gvm.MOV, gvm.R1, gvm.Integer(v.Value))
+		return nil
+	case *Symbol:
+		at, ok := sc.lookup(v.Name)
+		if !ok {
+			return errorf(v.Line, "undefined variable: %s", v.Name)
+		}
+		c.emit(gvm.MOV, gvm.R1, at)
+		return nil
+	case *List:
+		return c.list(v, sc)
+	default:
+		return fmt.Errorf("sexp: unsupported value: %T", v)
+	}
+}
+
+func (c *compiler) list(l *List, sc *scope) error {
+	if len(l.Items) == 0 {
+		return errorf(l.Line, "empty list")
+	}
+	head, ok := l.Items[0].(*Symbol)
+	if !ok {
+		return errorf(l.Line, "not a function: %s", l.Items[0])
+	}
+	args := l.Items[1:]
+	switch name := head.Name; {
+	case name == "define":
+		return errorf(l.Line, "define: not at top level")
+	case name == "if":
+		return c.ifForm(l, sc)
+	case name == "let":
+		return c.let(l, sc)
+	case name == "begin":
+		return c.sequence(args, sc)
+	case name == "and" || name == "or":
+		return c.logical(name, args, sc)
+	case name == "not":
+		if len(args) != 1 {
+			return errorf(l.Line, "not: want 1 args, got %d", len(args))
+		}
+		if err := c.expr(args[0], sc); err != nil {
+			return err
+		}
+		c.emit(gvm.CMP, gvm.R1, gvm.Integer(0))
+		c.boolean(gvm.JE)
+		return nil
+	case arithmetic[name] != 0 || name == "%":
+		return c.arith(l, name, args, sc)
+	case comparisons[name] != 0:
+		if len(args) != 2 {
+			return errorf(l.Line, "%s: want 2 args, got %d", name, len(args))
+		}
+		if err := c.operands(args[0], args[1], sc); err != nil {
+			return err
+		}
+		c.emit(gvm.CMP, gvm.R1, gvm.R2)
+		c.boolean(comparisons[name])
+		return nil
+	default:
+		return c.call(l, head, args, sc)
+	}
+}
+
+// operands xをR1に, yをR2に入れる
+func (c *compiler) operands(x, y Value, sc *scope) error {
+	if err := c.expr(x, sc); err != nil {
+		return err
+	}
+	c.push()
+	if err := c.expr(y, sc); err != nil {
+		return err
+	}
+	c.emit(gvm.MOV, gvm.R2, gvm.R1, gvm.POP, gvm.R1)
+	c.depth--
+	return nil
+}
+
+// arith (+ a b c)は左から順に計算する. (- x)は符号反転
+func (c *compiler) arith(l *List, name string, args []Value, sc *scope) error {
+	switch {
+	case name == "-" && len(args) == 1:
+		if err := c.expr(args[0], sc); err != nil {
+			return err
+		}
+		c.emit(gvm.MOV, gvm.R2, gvm.R1, gvm.MOV, gvm.R1, gvm.Integer(0), gvm.SUB, gvm.R1, gvm.R2)
+		return nil
+	case len(args) < 2:
+		return errorf(l.Line, "%s: want 2 or more args, got %d", name, len(args))
+	}
+	if err := c.expr(args[0], sc); err != nil {
+		return err
+	}
+	for _, y := range args[1:] {
+		c.push()
+		if err := c.expr(y, sc); err != nil {
+			return err
+		}
+		c.emit(gvm.MOV, gvm.R2, gvm.R1, gvm.POP, gvm.R1)
+		c.depth--
+		if name != "%" {
+			c.emit(arithmetic[name], gvm.R1, gvm.R2)
+			continue
+		}
+		// x % y = x - x / y * y
+		c.emit(
+			gvm.PUSH, gvm.R1,
+			gvm.DIV, gvm.R1, gvm.R2,
+			gvm.MUL, gvm.R1, gvm.R2,
+			gvm.MOV, gvm.R2, gvm.R1,
+			gvm.POP, gvm.R1,
+			gvm.SUB, gvm.R1, gvm.R2,
+		)
+	}
+	return nil
+}
+
+// boolean 直前のフラグでopが成り立てば1, でなければ0をR1に入れる
+func (c *compiler) boolean(op gvm.Opcode) {
+	end := c.newLabel()
+	c.emit(gvm.MOV, gvm.R1, gvm.Integer(1))
+	c.jump(op, end)
+	c.emit(gvm.MOV, gvm.R1, gvm.Integer(0))
+	c.mark(end)
+}
+
+// ifForm (if cond then [else]). elseがなければ0
+func (c *compiler) ifForm(l *List, sc *scope) error {
+	args := l.Items[1:]
+	if len(args) != 2 && len(args) != 3 {
+		return errorf(l.Line, "if: want 2 or 3 args, got %d", len(args))
+	}
+	els, end := c.newLabel(), c.newLabel()
+	if err := c.expr(args[0], sc); err != nil {
+		return err
+	}
+	c.emit(gvm.CMP, gvm.R1, gvm.Integer(0))
+	c.jump(gvm.JE, els)
+	if err := c.expr(args[1], sc); err != nil {
+		return err
+	}
+	c.jump(gvm.JMP, end)
+	c.mark(els)
+	if len(args) == 3 {
+		if err := c.expr(args[2], sc); err != nil {
+			return err
+		}
+	} else {
+		c.emit(gvm.MOV, gvm.R1, gvm.Integer(0))
+	}
+	c.mark(end)
+	return nil
+}
+
+// let (let ((name value) ...) body ...). 値は外側のスコープで評価してから束縛する
+func (c *compiler) let(l *List, sc *scope) error {
+	if len(l.Items) < 2 {
+		return errorf(l.Line, "let: want (let ((name value) ...) body ...), got %s", l)
+	}
+	bindings, ok := l.Items[1].(*List)
+	if !ok {
+		return errorf(l.Line, "let: invalid bindings: %s", l.Items[1])
+	}
+	inner := &scope{vars: map[string]gvm.BpOffset{}, parent: sc}
+	for _, b := range bindings.Items {
+		pair, ok := b.(*List)
+		if !ok || len(pair.Items) != 2 {
+			return errorf(l.Line, "let: invalid binding: %s", b)
+		}
+		name, ok := pair.Items[0].(*Symbol)
+		if !ok {
+			return errorf(l.Line, "let: invalid name: %s", pair.Items[0])
+		}
+		if _, ok := inner.vars[name.Name]; ok {
+			return errorf(l.Line, "let: duplicate variable: %s", name.Name)
+		}
+		if err := c.expr(pair.Items[1], sc); err != nil {
+			return err
+		}
+		c.push()
+		inner.vars[name.Name] = gvm.BpOffset(-c.depth)
+	}
+	if err := c.sequence(l.Items[2:], inner); err != nil {
+		return err
+	}
+	c.pop(len(bindings.Items))
+	return nil
+}
+
+// logical (and a b ...)は0があればそこで0, (or a b ...)は0以外があればそこで1
+func (c *compiler) logical(name string, args []Value, sc *scope) error {
+	short, end := c.newLabel(), c.newLabel()
+	op, v := gvm.JE, gvm.Integer(0)
+	if name == "or" {
+		op, v = gvm.JNE, gvm.Integer(1)
+	}
+	for _, x := range args {
+		if err := c.expr(x, sc); err != nil {
+			return err
+		}
+		c.emit(gvm.CMP, gvm.R1, gvm.Integer(0))
+		c.jump(op, short)
+	}
+	c.emit(gvm.MOV, gvm.R1, 1-v)
+	c.jump(gvm.JMP, end)
+	c.mark(short)
+	c.emit(gvm.MOV, gvm.R1, v)
+	c.mark(end)
+	return nil
+}
+
+// call 引数を後ろから積んで呼び出し, 戻ったら取り除く
+func (c *compiler) call(l *List, head *Symbol, args []Value, sc *scope) error {
+	fn, ok := c.funcs[head.Name]
+	if !ok {
+		return errorf(l.Line, "undefined function: %s", head.Name)
+	}
+	if len(fn.params) != len(args) {
+		return errorf(l.Line, "%s: want %d args, got %d", head.Name, len(fn.params), len(args))
+	}
+	for i := len(args) - 1; 0 <= i; i-- {
+		if err := c.expr(args[i], sc); err != nil {
+			return err
+		}
+		c.push()
+	}
+	c.emit(gvm.CALL, nil)
+	c.calls[len(c.program)-1] = head.Name
+	c.pop(len(args))
+	return nil
+}
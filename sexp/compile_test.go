@@ -0,0 +1,159 @@
+package sexp
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/x0y14/gvm"
+)
+
+func run(t *testing.T, src string) (gvm.Operand, error) {
+	t.Helper()
+	program, err := Compile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	optimized, err := gvm.Optimize(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 最適化の前後で結果が変わらない
+	var results [2]gvm.Operand
+	var errs [2]error
+	for i, p := range []gvm.Program{program, optimized} {
+		r := gvm.NewRuntime(p, &gvm.Config{StackSize: 1024, HeapSize: 0})
+		errs[i] = r.Run()
+		results[i] = r.Register(gvm.R1)
+	}
+	if (errs[0] == nil) != (errs[1] == nil) {
+		t.Fatalf("optimized: want=%v, got=%v", errs[0], errs[1])
+	}
+	if errs[0] != nil {
+		return nil, errs[0]
+	}
+	if diff := cmp.Diff(results[0], results[1]); diff != "" {
+		t.Fatalf("optimized: diff: %s", diff)
+	}
+	return results[0], nil
+}
+
+func TestRead(t *testing.T) {
+	values, err := Read(`
+(define (f x) (+ x 1)) ; comment
+(f -3 - 4)
+(<= a b)(!= 1 2)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, v := range values {
+		got = append(got, v.String())
+	}
+	expect := []string{"(define (f x) (+ x 1))", "(f -3 - 4)", "(<= a b)", "(!= 1 2)"}
+	if diff := cmp.Diff(expect, got); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect gvm.Operand
+	}{
+		{"empty", "", gvm.Integer(0)},
+		{"integer", "42", gvm.Integer(42)},
+		{"last value", "1 2 3", gvm.Integer(3)},
+		{"arith", "(- (* 2 (+ 1 2 3)) (/ 9 2) (% 7 4))", gvm.Integer(5)},
+		{"neg", "(- -5)", gvm.Integer(5)},
+		{"compare", "(+ (< 1 2) (* 10 (>= 1 2)) (* 100 (= 3 3)) (* 1000 (!= 3 3)) (* 10000 (> 2 1)) (* 100000 (<= 2 2)))", gvm.Integer(110101)},
+		{"if", "(if (< 1 2) 10 20)", gvm.Integer(10)},
+		{"if else", "(if 0 10 20)", gvm.Integer(20)},
+		{"if without else", "(if 0 10)", gvm.Integer(0)},
+		{"let", "(let ((x 2) (y 3)) (* x y))", gvm.Integer(6)},
+		{"let outer scope", "(let ((x 1)) (let ((x 10) (y x)) (+ x y)))", gvm.Integer(11)},
+		{"let in expr", "(+ 1 (let ((x 2)) (* x x)) (let ((y 3)) y))", gvm.Integer(8)},
+		{"begin", "(begin 1 2 (+ 1 2))", gvm.Integer(3)},
+		{"logical", "(+ (and 1 2) (* 10 (and 1 0)) (* 100 (or 0 3)) (* 1000 (or 0 0)) (* 10000 (not 0)))", gvm.Integer(10101)},
+		{"short circuit", "(and 0 (/ 1 0))", gvm.Integer(0)},
+		{"define", "(define (f x) (+ x 1)) (f 41)", gvm.Integer(42)},
+		{"fact", `
+(define (fact n)
+  (if (<= n 1)
+      1
+      (* n (fact (- n 1)))))
+(fact 10)`, gvm.Integer(3628800)},
+		{"params order", "(define (sub a b c) (- a b c)) (sub 100 20 3)", gvm.Integer(77)},
+		{"params and let", `
+(define (hyp2 a b)
+  (let ((aa (* a a))
+        (bb (* b b)))
+    (+ aa bb)))
+(hyp2 3 4)`, gvm.Integer(25)},
+		{"forward call", "(define (f) (g 2)) (define (g x) (* x 10)) (f)", gvm.Integer(20)},
+		{"mutual recursion", `
+(define (even n) (if (= n 0) 1 (odd (- n 1))))
+(define (odd n) (if (= n 0) 0 (even (- n 1))))
+(+ (* 10 (even 10)) (odd 7))`, gvm.Integer(11)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := run(t, tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.expect, got); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestCompile_Error(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect string
+	}{
+		{"unclosed", "(+ 1\n 2", "sexp: 2: unclosed list"},
+		{"rrb", ")", "sexp: 1: unexpected token: )"},
+		{"lexer", "(+ 1 $)", "sexp: unexpected rune: $"},
+		{"empty list", "()", "sexp: 1: empty list"},
+		{"not a function", "(1 2)", "sexp: 1: not a function: 1"},
+		{"undefined variable", "(+ x 1)", "sexp: 1: undefined variable: x"},
+		{"undefined function", "(f 1)", "sexp: 1: undefined function: f"},
+		{"args", "(define (f x) x)\n(f 1 2)", "sexp: 2: f: want 1 args, got 2"},
+		{"nested define", "(let ((x 1)) (define (f) 1))", "sexp: 1: define: not at top level"},
+		{"define signature", "(define f 1)", "sexp: 1: define: invalid signature: f"},
+		{"define body", "(define (f))", "sexp: 1: define: want (define (name params ...) body ...), got (define (f))"},
+		{"reserved", "(define (if x) x)", "sexp: 1: define: reserved name: if"},
+		{"duplicate function", "(define (f) 1) (define (f) 2)", "sexp: 1: define: duplicate function: f"},
+		{"duplicate param", "(define (f x x) 1)", "sexp: 1: define: duplicate param: x"},
+		{"if", "(if 1)", "sexp: 1: if: want 2 or 3 args, got 1"},
+		{"compare", "(< 1 2 3)", "sexp: 1: <: want 2 args, got 3"},
+		{"arith", "(* 1)", "sexp: 1: *: want 2 or more args, got 1"},
+		{"let bindings", "(let x 1)", "sexp: 1: let: invalid bindings: x"},
+		{"let binding", "(let ((x)) x)", "sexp: 1: let: invalid binding: (x)"},
+		{"let duplicate", "(let ((x 1) (x 2)) x)", "sexp: 1: let: duplicate variable: x"},
+		{"let scope", "(+ (let ((x 1)) x) x)", "sexp: 1: undefined variable: x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.src)
+			if err == nil {
+				t.Fatal("want error")
+			}
+			if diff := cmp.Diff(tt.expect, err.Error()); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestCompile_RuntimeError(t *testing.T) {
+	_, err := run(t, "(define (f x) (/ 10 x)) (f 0)")
+	if !errors.Is(err, gvm.ErrDivisionByZero) {
+		t.Errorf("want=division by zero, got=%v", err)
+	}
+}
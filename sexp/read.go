@@ -0,0 +1,138 @@
+package sexp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/x0y14/gvm/internal"
+)
+
+// Value 読み込んだS式
+type Value interface {
+	String() string
+	line() int
+}
+
+type (
+	// Symbol 識別子か演算子
+	Symbol struct {
+		Line int
+		Name string
+	}
+	Int struct {
+		Line  int
+		Value int
+	}
+	List struct {
+		Line  int
+		Items []Value
+	}
+)
+
+func (s *Symbol) String() string { return s.Name }
+func (i *Int) String() string    { return fmt.Sprint(i.Value) }
+func (l *List) String() string {
+	items := make([]string, len(l.Items))
+	for i, v := range l.Items {
+		items[i] = v.String()
+	}
+	return "(" + strings.Join(items, " ") + ")"
+}
+
+func (s *Symbol) line() int { return s.Line }
+func (i *Int) line() int    { return i.Line }
+func (l *List) line() int   { return l.Line }
+
+// operators シンボルとして読む演算子のトークン
+var operators = map[internal.TokenKind]bool{
+	internal.Add:    true,
+	internal.Sub:    true,
+	internal.Mul:    true,
+	internal.Div:    true,
+	internal.Mod:    true,
+	internal.Assign: true,
+	internal.Ne:     true,
+	internal.Lt:     true,
+	internal.Le:     true,
+	internal.Gt:     true,
+	internal.Ge:     true,
+}
+
+type reader struct {
+	tok *internal.Token
+}
+
+func line(tok *internal.Token) int {
+	return tok.Position.Line + 1
+}
+
+func errorf(l int, format string, args ...any) error {
+	return fmt.Errorf("sexp: %d: %s", l, fmt.Sprintf(format, args...))
+}
+
+func (r *reader) next() *internal.Token {
+	tok := r.tok
+	r.tok = r.tok.Next
+	for r.tok.Kind == internal.Comment {
+		r.tok = r.tok.Next
+	}
+	return tok
+}
+
+// Read ソースのS式を順に読む. ;から行末まではコメント
+func Read(src string) ([]Value, error) {
+	head, err := internal.Tokenize([]rune(src))
+	if err != nil {
+		return nil, fmt.Errorf("sexp: %w", err)
+	}
+	r := &reader{tok: &internal.Token{Next: head}}
+	r.next()
+	var values []Value
+	for r.tok.Kind != internal.Eof {
+		v, err := r.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (r *reader) value() (Value, error) {
+	tok := r.next()
+	switch kind := tok.Kind; {
+	case kind == internal.Lrb:
+		l := &List{Line: line(tok)}
+		for r.tok.Kind != internal.Rrb {
+			if r.tok.Kind == internal.Eof {
+				return nil, errorf(line(r.tok), "unclosed list")
+			}
+			v, err := r.value()
+			if err != nil {
+				return nil, err
+			}
+			l.Items = append(l.Items, v)
+		}
+		r.next()
+		return l, nil
+	case kind == internal.Integer:
+		v, err := tok.GetValueAsInteger()
+		if err != nil {
+			return nil, errorf(line(tok), "invalid integer: %s", string(tok.Raw))
+		}
+		return &Int{Line: line(tok), Value: v}, nil
+	case kind == internal.Identifier:
+		return &Symbol{Line: line(tok), Name: string(tok.Raw)}, nil
+	case kind == internal.Sub && r.tok.Kind == internal.Integer && r.tok.Position.StartedAt == tok.Position.StartedAt+1:
+		// 間を空けずに数字が続けば負の数
+		v, err := r.next().GetValueAsInteger()
+		if err != nil {
+			return nil, errorf(line(tok), "invalid integer: -%s", string(tok.Next.Raw))
+		}
+		return &Int{Line: line(tok), Value: -v}, nil
+	case operators[kind]:
+		return &Symbol{Line: line(tok), Name: kind.String()}, nil
+	default:
+		return nil, errorf(line(tok), "unexpected token: %s", kind)
+	}
+}
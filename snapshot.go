@@ -0,0 +1,558 @@
+package gvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	snapshotMagic   = "GVMS"
+	snapshotVersion = 4 // 2: ハンドラ, 3: スレッド, 4: チャネル. 命令やレジスタの番号も変わってきたので, 古いものは読まない
+)
+
+// Wordの種類を表すタグ. 値を変えるとスナップショットの互換性が失われる
+const (
+	tagNil byte = iota
+	tagOpcode
+	tagSpecialRegister
+	tagGeneralPurposeRegister
+	tagFlagRegister
+	tagInteger
+	tagChar
+	tagBool
+	tagBpOffset
+	tagSpOffset
+	tagProgramOffset
+	tagProgramAddress
+	tagHeapAddress
+	tagBasePointer
+	tagStackPointer
+	tagFloat
+	tagField
+	tagElement
+	tagStackAddress
+	tagChannel
+)
+
+func appendWord(buf []byte, w Word) ([]byte, error) {
+	var tag byte
+	var v int
+	switch w := w.(type) {
+	case nil:
+		return append(buf, tagNil), nil
+	case Float:
+		buf = append(buf, tagFloat)
+		return binary.AppendUvarint(buf, math.Float64bits(float64(w))), nil
+	case Field:
+		buf = append(buf, tagField)
+		buf = binary.AppendVarint(buf, int64(w.Offset))
+		return binary.AppendVarint(buf, int64(w.Type)), nil
+	case Element:
+		buf = append(buf, tagElement)
+		buf = binary.AppendVarint(buf, int64(w.Type))
+		return binary.AppendVarint(buf, int64(w.Len)), nil
+	case Opcode:
+		tag, v = tagOpcode, int(w)
+	case SpecialRegister:
+		tag, v = tagSpecialRegister, w.Value()
+	case GeneralPurposeRegister:
+		tag, v = tagGeneralPurposeRegister, w.Value()
+	case FlagRegister:
+		tag, v = tagFlagRegister, w.Value()
+	case Integer:
+		tag, v = tagInteger, w.Value()
+	case Char:
+		tag, v = tagChar, w.Value()
+	case Bool:
+		tag, v = tagBool, w.Value()
+	case BpOffset:
+		tag, v = tagBpOffset, w.Value()
+	case SpOffset:
+		tag, v = tagSpOffset, w.Value()
+	case ProgramOffset:
+		tag, v = tagProgramOffset, w.Value()
+	case ProgramAddress:
+		tag, v = tagProgramAddress, w.Value()
+	case HeapAddress:
+		tag, v = tagHeapAddress, w.Value()
+	case BasePointer:
+		tag, v = tagBasePointer, w.Value()
+	case StackPointer:
+		tag, v = tagStackPointer, w.Value()
+	case StackAddress:
+		tag, v = tagStackAddress, w.Value()
+	case Channel:
+		tag, v = tagChannel, w.Value()
+	default:
+		return nil, fmt.Errorf("snapshot: unsupported word: %s", w.String())
+	}
+	buf = append(buf, tag)
+	return binary.AppendVarint(buf, int64(v)), nil
+}
+
+func readWord(rd *bytes.Reader) (Word, error) {
+	tag, err := rd.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if tag == tagNil {
+		return nil, nil
+	}
+	if tag == tagFloat {
+		bits, err := binary.ReadUvarint(rd)
+		if err != nil {
+			return nil, err
+		}
+		return Float(math.Float64frombits(bits)), nil
+	}
+	i64, err := binary.ReadVarint(rd)
+	if err != nil {
+		return nil, err
+	}
+	v := int(i64)
+	switch tag {
+	case tagField, tagElement:
+		i64, err := binary.ReadVarint(rd)
+		if err != nil {
+			return nil, err
+		}
+		if tag == tagField {
+			return Field{Offset: v, Type: PrimitiveType(i64)}, nil
+		}
+		return Element{Type: PrimitiveType(v), Len: int(i64)}, nil
+	case tagOpcode:
+		return Opcode(v), nil
+	case tagSpecialRegister:
+		return SpecialRegister(v), nil
+	case tagGeneralPurposeRegister:
+		return GeneralPurposeRegister(v), nil
+	case tagFlagRegister:
+		return FlagRegister(v), nil
+	case tagInteger:
+		return Integer(v), nil
+	case tagChar:
+		return Char(v), nil
+	case tagBool:
+		return Bool(v != 0), nil
+	case tagBpOffset:
+		return BpOffset(v), nil
+	case tagSpOffset:
+		return SpOffset(v), nil
+	case tagProgramOffset:
+		return ProgramOffset(v), nil
+	case tagProgramAddress:
+		return ProgramAddress(v), nil
+	case tagHeapAddress:
+		return HeapAddress(v), nil
+	case tagBasePointer:
+		return BasePointer(v), nil
+	case tagStackPointer:
+		return StackPointer(v), nil
+	case tagStackAddress:
+		return StackAddress(v), nil
+	case tagChannel:
+		return Channel(v), nil
+	default:
+		return nil, fmt.Errorf("snapshot: unknown tag: %d", tag)
+	}
+}
+
+func appendWords[W Word](buf []byte, words []W) ([]byte, error) {
+	buf = binary.AppendUvarint(buf, uint64(len(words)))
+	for _, w := range words {
+		var err error
+		if buf, err = appendWord(buf, w); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func readLength(rd *bytes.Reader) (int, error) {
+	n, err := binary.ReadUvarint(rd)
+	if err == io.EOF {
+		return 0, errors.New("snapshot: invalid length")
+	}
+	if err != nil {
+		return 0, err
+	}
+	// 各要素は最低1バイトなので, 残りより長いものは壊れている
+	if uint64(rd.Len()) < n {
+		return 0, errors.New("snapshot: invalid length")
+	}
+	return int(n), nil
+}
+
+func readStockables(rd *bytes.Reader) ([]Stockable, error) {
+	n, err := readLength(rd)
+	if err != nil {
+		return nil, err
+	}
+	stockables := make([]Stockable, n)
+	for i := range stockables {
+		w, err := readWord(rd)
+		if err != nil {
+			return nil, err
+		}
+		if w == nil {
+			continue
+		}
+		s, ok := w.(Stockable)
+		if !ok {
+			return nil, fmt.Errorf("snapshot: not stockable: %s", w.String())
+		}
+		stockables[i] = s
+	}
+	return stockables, nil
+}
+
+func appendRegisters(buf []byte, registers []value) ([]byte, error) {
+	buf = binary.AppendUvarint(buf, uint64(len(registers)))
+	var err error
+	for i, v := range registers {
+		if buf, err = appendWord(buf, registerAt(i)); err != nil {
+			return nil, err
+		}
+		if buf, err = appendWord(buf, v.operand()); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func readRegisters(rd *bytes.Reader) ([]value, error) {
+	n, err := readLength(rd)
+	if err != nil {
+		return nil, err
+	}
+	regs := make([]value, max(n, iR0))
+	for i := 0; i < n; i++ {
+		w, err := readWord(rd)
+		if err != nil {
+			return nil, err
+		}
+		reg, ok := w.(Register)
+		if !ok {
+			return nil, fmt.Errorf("snapshot: not register: %v", w)
+		}
+		at, ok := registerIndex(regs, reg)
+		if !ok {
+			return nil, fmt.Errorf("snapshot: unknown register: %s", reg.String())
+		}
+		w, err = readWord(rd)
+		if err != nil {
+			return nil, err
+		}
+		if w == nil {
+			continue
+		}
+		v, ok := w.(Operand)
+		if !ok {
+			return nil, fmt.Errorf("snapshot: not operand: %s", w.String())
+		}
+		regs[at] = valueOf(v)
+	}
+	return regs, nil
+}
+
+func appendHandlers(buf []byte, handlers []handler) ([]byte, error) {
+	buf = binary.AppendUvarint(buf, uint64(len(handlers)))
+	var err error
+	for _, h := range handlers {
+		for _, w := range []Word{h.catch, h.sp, h.bp} {
+			if buf, err = appendWord(buf, w); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf, nil
+}
+
+func readHandlers(rd *bytes.Reader) ([]handler, error) {
+	n, err := readLength(rd)
+	if err != nil {
+		return nil, err
+	}
+	var handlers []handler
+	for i := 0; i < n; i++ {
+		var ws [3]Word
+		for j := range ws {
+			if ws[j], err = readWord(rd); err != nil {
+				return nil, err
+			}
+		}
+		catch, ok1 := ws[0].(ProgramAddress)
+		sp, ok2 := ws[1].(StackPointer)
+		bp, ok3 := ws[2].(BasePointer)
+		if !ok1 || !ok2 || !ok3 {
+			return nil, errors.New("snapshot: invalid handler")
+		}
+		handlers = append(handlers, handler{catch: catch, sp: sp, bp: bp})
+	}
+	return handlers, nil
+}
+
+// Snapshot Program, レジスタ, スタック, ヒープ, ハンドラ, スレッドを含むマシンの状態全体を直列化する
+func (r *Runtime) Snapshot() ([]byte, error) {
+	buf := append([]byte(snapshotMagic), snapshotVersion)
+	var err error
+	if buf, err = appendWords(buf, r.program); err != nil {
+		return nil, err
+	}
+	if buf, err = appendRegisters(buf, r.registers); err != nil {
+		return nil, err
+	}
+	if buf, err = appendWords(buf, stockables(r.stack)); err != nil {
+		return nil, err
+	}
+	if buf, err = appendWords(buf, stockables(r.heap)); err != nil {
+		return nil, err
+	}
+	if buf, err = appendHandlers(buf, r.handlers); err != nil {
+		return nil, err
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(r.channels)))
+	for _, c := range r.channels {
+		buf = binary.AppendUvarint(buf, uint64(c.at))
+		buf = binary.AppendUvarint(buf, uint64(c.cap))
+		closed := byte(0)
+		if c.closed {
+			closed = 1
+		}
+		buf = append(buf, closed)
+		if buf, err = appendWords(buf, c.buf); err != nil {
+			return nil, err
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(c.sendq)))
+		for _, p := range c.sendq {
+			buf = binary.AppendUvarint(buf, uint64(p.thread))
+			if buf, err = appendWord(buf, p.value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// 実行中のスレッドの状態は上に書いたので, 待ち状態だけを書く
+	buf = binary.AppendUvarint(buf, uint64(len(r.threads)))
+	if len(r.threads) > 0 {
+		buf = binary.AppendUvarint(buf, uint64(r.current))
+	}
+	for i, t := range r.threads {
+		buf = binary.AppendUvarint(buf, uint64(t.wait.op))
+		buf = binary.AppendUvarint(buf, uint64(t.wait.target))
+		if i == r.current {
+			continue
+		}
+		if buf, err = appendRegisters(buf, t.registers); err != nil {
+			return nil, err
+		}
+		if buf, err = appendWords(buf, stockables(t.stack)); err != nil {
+			return nil, err
+		}
+		if buf, err = appendHandlers(buf, t.handlers); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// Restore Snapshotで直列化した状態からRuntimeを復元する. 読めるのは同じバージョンのものだけ
+func Restore(data []byte) (*Runtime, error) {
+	if !bytes.HasPrefix(data, []byte(snapshotMagic)) || len(data) <= len(snapshotMagic) {
+		return nil, errors.New("snapshot: invalid header")
+	}
+	version := data[len(snapshotMagic)]
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("snapshot: unsupported version: %d", version)
+	}
+	rd := bytes.NewReader(data[len(snapshotMagic)+1:])
+
+	n, err := readLength(rd)
+	if err != nil {
+		return nil, err
+	}
+	program := make(Program, n)
+	for i := range program {
+		w, err := readWord(rd)
+		if err != nil {
+			return nil, err
+		}
+		if w == nil {
+			return nil, errors.New("snapshot: nil in program")
+		}
+		program[i] = w
+	}
+
+	regs, err := readRegisters(rd)
+	if err != nil {
+		return nil, err
+	}
+	stack, err := readStockables(rd)
+	if err != nil {
+		return nil, err
+	}
+	heap, err := readStockables(rd)
+	if err != nil {
+		return nil, err
+	}
+	r := &Runtime{
+		program:   program,
+		registers: regs,
+		stack:     values(stack),
+		heap:      values(heap),
+	}
+	if err := checkPointers(regs, len(stack), len(heap)); err != nil {
+		return nil, err
+	}
+	if r.handlers, err = readHandlers(rd); err != nil {
+		return nil, err
+	}
+	if err := r.restoreChannels(rd); err != nil {
+		return nil, err
+	}
+	if err := r.restoreThreads(rd); err != nil {
+		return nil, err
+	}
+	if rd.Len() != 0 {
+		return nil, errors.New("snapshot: trailing data")
+	}
+	return r, nil
+}
+
+func (r *Runtime) restoreChannels(rd *bytes.Reader) error {
+	n, err := readLength(rd)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		c := &channel{id: i}
+		at, err := binary.ReadUvarint(rd)
+		if err != nil {
+			return err
+		}
+		capacity, err := binary.ReadUvarint(rd)
+		if err != nil {
+			return err
+		}
+		closed, err := rd.ReadByte()
+		if err != nil {
+			return err
+		}
+		c.at, c.cap, c.closed = HeapAddress(at), int(capacity), closed != 0
+		if c.buf, err = readStockables(rd); err != nil {
+			return err
+		}
+		if len(c.buf) == 0 {
+			c.buf = nil
+		}
+		m, err := readLength(rd)
+		if err != nil {
+			return err
+		}
+		for j := 0; j < m; j++ {
+			thread, err := binary.ReadUvarint(rd)
+			if err != nil {
+				return err
+			}
+			w, err := readWord(rd)
+			if err != nil {
+				return err
+			}
+			v, ok := w.(Stockable)
+			if w != nil && !ok {
+				return fmt.Errorf("snapshot: not stockable: %s", w.String())
+			}
+			c.sendq = append(c.sendq, pending{thread: int(thread), value: v})
+		}
+		r.channels = append(r.channels, c)
+	}
+	return nil
+}
+
+func (r *Runtime) restoreThreads(rd *bytes.Reader) error {
+	n, err := readLength(rd)
+	if err != nil || n == 0 {
+		return err
+	}
+	current, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return err
+	}
+	if uint64(n) <= current {
+		return errors.New("snapshot: invalid thread")
+	}
+	r.current = int(current)
+	r.threads = make([]*thread, n)
+	for i := range r.threads {
+		t := &thread{}
+		if t.wait, err = readWait(rd); err != nil {
+			return err
+		}
+		limit := n
+		if t.wait.op != JOIN {
+			limit = len(r.channels)
+		}
+		if t.wait.op != NOP && (t.wait.target < 0 || limit <= t.wait.target) {
+			return errors.New("snapshot: invalid thread")
+		}
+		r.threads[i] = t
+		if i == r.current {
+			continue
+		}
+		if t.registers, err = readRegisters(rd); err != nil {
+			return err
+		}
+		stack, err := readStockables(rd)
+		if err != nil {
+			return err
+		}
+		t.stack = values(stack)
+		if err := checkPointers(t.registers, len(t.stack), len(r.heap)); err != nil {
+			return err
+		}
+		if t.handlers, err = readHandlers(rd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPointers PC, BP, SP, HPが正しい種類で, スタック, ヒープの範囲を指しているか.
+// SPは空のスタックでは-1, 全て取り出すとスタックの長さになる
+func checkPointers(regs []value, stack, heap int) error {
+	bounds := [...]struct{ lo, hi int }{
+		iPC: {0, math.MaxInt},
+		iBP: {0, stack},
+		iSP: {min(0, stack-1), stack},
+		iHP: {0, heap},
+	}
+	for i, b := range bounds {
+		v := regs[i]
+		if v.kind != pointerKinds[i] || int(v.bits) < b.lo || b.hi < int(v.bits) {
+			return fmt.Errorf("snapshot: invalid register: %s", registerAt(i))
+		}
+	}
+	return nil
+}
+
+func readWait(rd *bytes.Reader) (wait, error) {
+	op, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return wait{}, err
+	}
+	target, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return wait{}, err
+	}
+	switch Opcode(op) {
+	case NOP, JOIN, SEND, RECV:
+		return wait{op: Opcode(op), target: int(target)}, nil
+	default:
+		return wait{}, errors.New("snapshot: invalid thread")
+	}
+}
@@ -0,0 +1,145 @@
+package gvm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRuntime_Snapshot(t *testing.T) {
+	tests := []struct {
+		name   string
+		prog   Program
+		config *Config
+	}{
+		{"call", callProgram(3), &Config{StackSize: 8}},
+		{
+			"alloc, store, load",
+			Program{
+				ALLOC, Integer(2),
+				POP, R1,
+				STORE, R1, Char('x'),
+				STORE, HeapAddress(1), Bool(true),
+				LOAD, R2, HeapAddress(0),
+				PUSH, R2,
+				MOV, BpOffset(1), Integer(-7),
+				EQ, R2, Char('x'),
+			},
+			&Config{StackSize: 4, HeapSize: 4},
+		},
+		{
+			"try",
+			Program{
+				TRY, ProgramAddress(15),
+				PUSH, Integer(1),
+				MOV, R2, Integer(0),
+				DIV, R2, R2,
+				POP, R3,
+				ENDTRY,
+				JMP, ProgramAddress(18),
+				ADD, R1, Integer(10), // 15
+			},
+			&Config{StackSize: 4},
+		},
+		{"threads", assemble(t, actorsProgram), &Config{StackSize: 8, HeapSize: 8}},
+		{"channels", assemble(t, pipeWith(1)), &Config{StackSize: 8, HeapSize: 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expect := NewRuntime(tt.prog, tt.config)
+			if err := expect.Run(); err != nil {
+				t.Fatal(err)
+			}
+
+			// 全ての命令境界で中断, 復元して最後まで実行する
+			for steps := 0; ; steps++ {
+				r := NewRuntime(tt.prog, tt.config)
+				for i := 0; i < steps && !r.Halted(); i++ {
+					if err := r.Step(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				halted := r.Halted()
+				data, err := r.Snapshot()
+				if err != nil {
+					t.Fatal(err)
+				}
+				restored, err := Restore(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if diff := cmp.Diff(r, restored, cmp.AllowUnexported(Runtime{}, value{}, handler{}, thread{}, wait{}, channel{}, pending{})); diff != "" {
+					t.Fatalf("steps=%d, diff: %s", steps, diff)
+				}
+				if err := restored.Run(); err != nil {
+					t.Fatal(err)
+				}
+				if diff := cmp.Diff(expect, restored, cmp.AllowUnexported(Runtime{}, value{}, handler{}, thread{}, wait{}, channel{}, pending{})); diff != "" {
+					t.Fatalf("steps=%d, diff: %s", steps, diff)
+				}
+				if halted {
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestRestore_Error(t *testing.T) {
+	data, err := NewRuntime(Program{NOP}, &Config{StackSize: 1, HeapSize: 1}).Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 特殊レジスタを壊したスナップショット
+	corrupt := func(f func(r *Runtime)) []byte {
+		r := NewRuntime(Program{NOP}, &Config{StackSize: 1, HeapSize: 1})
+		f(r)
+		data, err := r.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	tests := []struct {
+		name   string
+		data   []byte
+		expect string
+	}{
+		{"empty", nil, "snapshot: invalid header"},
+		{"magic", []byte("GVMX\x01"), "snapshot: invalid header"},
+		{"version", append([]byte("GVMS"), 99), "snapshot: unsupported version: 99"},
+		{"old version", append([]byte("GVMS\x01"), data[5:]...), "snapshot: unsupported version: 1"},
+		{"previous version", append([]byte("GVMS\x03"), data[5:]...), "snapshot: unsupported version: 3"},
+		{"trailing", append(append([]byte{}, data...), 0), "snapshot: trailing data"},
+		{"truncated", data[:len(data)-1], "snapshot: invalid length"},
+		{"handler", append(append([]byte{}, data[:len(data)-3]...), 1, tagInteger, 0, tagInteger, 0, tagInteger, 0, 0, 0), "snapshot: invalid handler"},
+		{"thread", append(append([]byte{}, data[:len(data)-1]...), 1, 1), "snapshot: invalid thread"},
+		{"sp kind", corrupt(func(r *Runtime) { r.registers[iSP] = makeValue(kindInteger, 0) }), "snapshot: invalid register: sp"},
+		{"sp out of stack", corrupt(func(r *Runtime) { r.setSP(2) }), "snapshot: invalid register: sp"},
+		{"pc negative", corrupt(func(r *Runtime) { r.setPC(-1) }), "snapshot: invalid register: pc"},
+		{"hp out of heap", corrupt(func(r *Runtime) { r.setHP(2) }), "snapshot: invalid register: hp"},
+		{"bp nil", corrupt(func(r *Runtime) { r.registers[iBP] = value{} }), "snapshot: invalid register: bp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Restore(tt.data)
+			if err == nil || err.Error() != tt.expect {
+				t.Errorf("want=%q, got=%v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestSnapshot_OpcodeNumbers(t *testing.T) {
+	// スナップショットには命令の番号が入るので, 番号が変わったら気付けるように並びを固定する
+	names := strings.Fields(`nop mov push pop alloc store load call ret add sub mul div jmp je jne eq ne lt le
+		ncall in itof ftoi ctoi itoc itob btoi ctob btoc ftob btof ctof ftoc typeof ldf stf ldx stx
+		cmp jl jle jg jge jb jbe ja jae jo jno js jns lea try endtry throw spawn yield join chan send recv close`)
+	for i, name := range names {
+		if got := Opcode(i).String(); got != name {
+			t.Errorf("%d: want=%s, got=%s", i, name, got)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package gvm
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRuntime_Lea(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		reg    Register
+		expect Operand
+		err    string
+	}{
+		{
+			"out parameter",
+			`
+    push 0
+    lea r1, [sp+0]
+    push r1
+    call set
+    pop r2
+    pop r3
+    jmp end
+set:
+    mov r1, [bp+2]
+    store r1, 42
+    load r2, r1
+    add r2, 1
+    store r1, r2
+    ret
+end:`,
+			R3, Integer(43), "",
+		},
+		{
+			"address",
+			"push 1\npush 2\nlea r1, [sp+1]",
+			R1, StackAddress(6), "",
+		},
+		{
+			"bp relative",
+			"push 5\ncall f\npop r2\njmp end\nf:\nlea r3, [bp+2]\nload r3, r3\nret\nend:",
+			R3, Integer(5), "",
+		},
+		{
+			"dead slot",
+			"push 1\nlea r1, [sp+0]\npop r2\nload r3, r1",
+			R3, nil, "stack: memory access out of bounds: stack@6, sp=7",
+		},
+		{
+			"out of stack",
+			"lea r1, [sp+1]\nstore r1, 1",
+			R1, StackAddress(8), "stack: memory access out of bounds: stack@8, sp=7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := Assemble(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"run", "decoded"} {
+				r := NewRuntime(program, &Config{StackSize: 8})
+				run := r.Run
+				if name == "decoded" {
+					run = r.RunDecoded
+				}
+				err := run()
+				got := ""
+				if err != nil {
+					got = err.Error()
+				}
+				if got != tt.err {
+					t.Errorf("%s: want=%q, got=%q", name, tt.err, got)
+				}
+				if diff := cmp.Diff(tt.expect, r.Register(tt.reg)); diff != "" {
+					t.Errorf("%s: diff: %s", name, diff)
+				}
+			}
+		})
+	}
+}
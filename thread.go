@@ -0,0 +1,185 @@
+package gvm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrDeadlock 終わっていないスレッドがすべて待ち状態になった
+var ErrDeadlock = errors.New("deadlock")
+
+// Blocked デッドロックしたスレッドと, 待っているもの
+type Blocked struct {
+	Thread int
+	Op     Opcode  // JOIN, SEND, RECV
+	Target Operand // JOIN: スレッドの番号, SEND, RECV: チャネルのHeapAddress
+}
+
+func (b Blocked) String() string {
+	return fmt.Sprintf("%d: %s %v", b.Thread, b.Op, b.Target)
+}
+
+// DeadlockError errors.Is(err, ErrDeadlock)で判定できる
+type DeadlockError struct {
+	Blocked []Blocked
+}
+
+func (e *DeadlockError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "deadlock: %d threads blocked", len(e.Blocked))
+	for i, b := range e.Blocked {
+		if i == 0 {
+			sb.WriteString(": ")
+		} else {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(b.String())
+	}
+	return sb.String()
+}
+
+func (e *DeadlockError) Is(target error) bool { return target == ErrDeadlock }
+
+// wait スレッドが待っているもの. opがNOPなら実行できる
+type wait struct {
+	op     Opcode // JOIN, SEND, RECV
+	target int    // JOIN: スレッド, SEND, RECV: チャネル
+}
+
+// thread SPAWNで作ったスレッド. ヒープとHPは全スレッドで共有する.
+// 実行中のスレッドのレジスタ, スタック, ハンドラはRuntimeのフィールドにあり, 切り替えるときに入れ替える.
+type thread struct {
+	registers []value
+	stack     []value
+	handlers  []handler
+	wait      wait
+}
+
+// spawn entryを呼び出したのと同じフレームを持つスレッドを作る.
+// [bp+0]: BasePointer(0), [bp+1]: Programの終わり, [bp+2]: 引数. RETするとスレッドが終わる
+func (r *Runtime) spawn(entry ProgramAddress, arg Stockable) (Integer, error) {
+	if len(r.stack) < 4 {
+		return 0, fmt.Errorf("spawn: %w: stack overflow", ErrOutOfBounds)
+	}
+	r.ensureThreads()
+	regs := make([]value, len(r.registers))
+	for i := iZF; i <= iOF; i++ {
+		regs[i] = boolValue(false)
+	}
+	stack := make([]value, len(r.stack))
+	sp := len(stack) - 1 - 3
+	stack[sp], stack[sp+1], stack[sp+2] = makeValue(kindBasePointer, 0), makeValue(kindProgramAddress, len(r.program)), valueOf(arg)
+	regs[iPC] = makeValue(kindProgramAddress, int(entry))
+	regs[iBP] = makeValue(kindBasePointer, sp)
+	regs[iSP] = makeValue(kindStackPointer, sp)
+	regs[iHP] = makeValue(kindHeapAddress, int(r.hp()))
+	r.threads = append(r.threads, &thread{registers: regs, stack: stack})
+	return Integer(len(r.threads) - 1), nil
+}
+
+// ensureThreads 実行中のProgramを最初のスレッドにする
+func (r *Runtime) ensureThreads() {
+	if r.threads == nil {
+		r.threads = []*thread{{}}
+	}
+}
+
+// switchTo 実行中のスレッドを入れ替える
+func (r *Runtime) switchTo(i int) {
+	if i == r.current {
+		return
+	}
+	cur := r.threads[r.current]
+	cur.registers, cur.stack, cur.handlers = r.registers, r.stack, r.handlers
+	next := r.threads[i]
+	next.registers[iHP] = makeValue(kindHeapAddress, int(r.hp()))
+	r.registers, r.stack, r.handlers = next.registers, next.stack, next.handlers
+	next.registers, next.stack, next.handlers = nil, nil, nil
+	r.current = i
+}
+
+func (r *Runtime) registersOf(i int) []value {
+	if i == r.current {
+		return r.registers
+	}
+	return r.threads[i].registers
+}
+
+// finished スレッドのPCがProgramの終わりに達したかどうか. 最後のSENDで止まっているものは終わっていない
+func (r *Runtime) finished(i int) bool {
+	if i < len(r.threads) && r.threads[i].wait.op != NOP {
+		return false
+	}
+	pc := r.registersOf(i)[iPC]
+	return pc.kind == kindProgramAddress && len(r.program) <= int(pc.bits)
+}
+
+func (r *Runtime) runnable(i int) bool {
+	if r.finished(i) {
+		return false
+	}
+	switch w := r.threads[i].wait; w.op {
+	case JOIN:
+		return r.finished(w.target)
+	case RECV:
+		return r.channels[w.target].ready()
+	case SEND:
+		return false // 受け取った側が待ちを解く
+	default:
+		return true
+	}
+}
+
+// schedule 実行中のスレッドの次から順に, 実行できるスレッドへ切り替える(ラウンドロビン).
+// 全スレッドが終わっていれば結果が見えるように最初のスレッドへ戻す.
+func (r *Runtime) schedule() error {
+	n := len(r.threads)
+	for k := 1; k <= n; k++ {
+		if i := (r.current + k) % n; r.runnable(i) {
+			r.switchTo(i)
+			return nil
+		}
+	}
+	var blocked []Blocked
+	for i, t := range r.threads {
+		if r.finished(i) {
+			continue
+		}
+		b := Blocked{Thread: i, Op: t.wait.op, Target: Integer(t.wait.target)}
+		if t.wait.op != JOIN {
+			b.Target = r.channels[t.wait.target].at
+		}
+		blocked = append(blocked, b)
+	}
+	if blocked != nil {
+		return &DeadlockError{Blocked: blocked}
+	}
+	r.switchTo(0)
+	return nil
+}
+
+// reschedule 実行中のスレッドが終わっていれば他のスレッドへ切り替える
+func (r *Runtime) reschedule() error {
+	if r.threads == nil || !r.finished(r.current) {
+		return nil
+	}
+	return r.schedule()
+}
+
+// join スレッドidの終了を待つ. 終わっていればそのR1を返す
+func (r *Runtime) join(v Operand) (Operand, bool, error) {
+	id, ok := v.(Integer)
+	if !ok || id < 0 || len(r.threads) <= int(id) {
+		return nil, false, fmt.Errorf("join: invalid thread: %v", v)
+	}
+	if int(id) == r.current {
+		return nil, false, fmt.Errorf("join: self: %d", id)
+	}
+	if r.finished(int(id)) {
+		r.threads[r.current].wait = wait{}
+		return r.registersOf(int(id))[regIndex(R1)].operand(), true, nil
+	}
+	r.threads[r.current].wait = wait{op: JOIN, target: int(id)}
+	return nil, false, nil
+}
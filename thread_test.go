@@ -0,0 +1,116 @@
+package gvm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func assemble(t *testing.T, src string) Program {
+	t.Helper()
+	program, err := Assemble(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return program
+}
+
+// actorsProgram 2つのスレッドがヒープ上のログに交互に自分の番号を書き, mainが結果を合計する
+const actorsProgram = `
+    store @0, 0
+    spawn worker, 1
+    mov r2, r1
+    spawn worker, 2
+    mov r3, r1
+    join r2
+    mov r4, r1
+    join r3
+    add r1, r4
+    jmp end
+worker:
+    mov r2, 0
+loop:
+    load r3, @0
+    add r3, 1
+    store @0, r3
+    mov r1, [bp+2]
+    store r3, r1
+    yield
+    add r2, 1
+    lt r2, 2
+    je loop
+    mov r1, [bp+2]
+    mul r1, 10
+    ret
+end:`
+
+func TestRuntime_Threads(t *testing.T) {
+	program := assemble(t, actorsProgram)
+	expect := NewRuntime(program, &Config{StackSize: 8, HeapSize: 8})
+	if err := expect.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Integer(30), expect.Register(R1)); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if diff := cmp.Diff([]Stockable{Integer(4), Integer(1), Integer(2), Integer(1), Integer(2), nil, nil, nil}, stockables(expect.heap)); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if expect.current != 0 || len(expect.threads) != 3 {
+		t.Errorf("current=%d, threads=%d", expect.current, len(expect.threads))
+	}
+
+	r := NewRuntime(program, &Config{StackSize: 8, HeapSize: 8})
+	if err := r.RunDecoded(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(expect, r, cmp.AllowUnexported(Runtime{}, value{}, thread{}, wait{})); diff != "" {
+		t.Errorf("decoded: %s", diff)
+	}
+}
+
+func TestRuntime_Threads_Error(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		check func(err error) bool
+	}{
+		{"deadlock", "spawn w, 0\njoin r1\njmp end\nw:\njoin 0\nret\nend:", func(err error) bool {
+			return errors.Is(err, ErrDeadlock) && err.Error() == "deadlock: 2 threads blocked: 0: join 1, 1: join 0"
+		}},
+		{"invalid", "join 5", func(err error) bool {
+			return err.Error() == "join: invalid thread: 5"
+		}},
+		{"self", "spawn w, 0\njoin 0\nw:", func(err error) bool {
+			return err.Error() == "join: self: 0"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := assemble(t, tt.src)
+			for _, name := range []string{"run", "decoded"} {
+				r := NewRuntime(program, &Config{StackSize: 8})
+				run := r.Run
+				if name == "decoded" {
+					run = r.RunDecoded
+				}
+				if err := run(); err == nil || !tt.check(err) {
+					t.Errorf("%s: unexpected error: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRuntime_Spawn_Overflow(t *testing.T) {
+	// 新しいスレッドのフレームが入らないスタックではcatchへ飛ぶ
+	program := assemble(t, "try catch\nspawn w, 0\nendtry\ncatch:\njmp end\nw:\nret\nend:")
+	r := NewRuntime(program, &Config{StackSize: 3})
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Operand(FaultOutOfBounds), r.Register(R1)); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
@@ -7,4 +7,14 @@ const (
 	TInteger
 	TChar
 	TBool
+	TFloat
 )
+
+func (t PrimitiveType) String() string {
+	return []string{
+		TInteger: "integer",
+		TChar:    "char",
+		TBool:    "bool",
+		TFloat:   "float",
+	}[t]
+}
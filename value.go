@@ -0,0 +1,195 @@
+package gvm
+
+import (
+	"fmt"
+	"math"
+)
+
+// kind valueが表すOperandの型
+type kind uint8
+
+const (
+	kindNil kind = iota
+	kindInteger
+	kindFloat
+	kindChar
+	kindBool
+	kindProgramAddress
+	kindHeapAddress
+	kindBasePointer
+	kindStackPointer
+	kindStackAddress
+	kindChannel
+	kindSpecialRegister
+	kindGeneralPurposeRegister
+	kindFlagRegister
+	kindBpOffset
+	kindSpOffset
+	kindProgramOffset
+	kindField
+	kindElement
+)
+
+// value レジスタ, スタック, ヒープの1セル. 型の種類と64ビットの中身からなり, インターフェースのように箱に入れない.
+// Floatはビット列を, それ以外はValue()をbitsに持つ. Field, ElementのPrimitiveTypeはtypに入れる
+type value struct {
+	bits uint64
+	kind kind
+	typ  uint8
+}
+
+func makeValue(k kind, n int) value {
+	return value{bits: uint64(n), kind: k}
+}
+
+func floatValue(f float64) value {
+	return value{bits: math.Float64bits(f), kind: kindFloat}
+}
+
+func boolValue(b bool) value {
+	if b {
+		return value{bits: 1, kind: kindBool}
+	}
+	return value{kind: kindBool}
+}
+
+// valueOf Operandをvalueにする. nilはkindNil
+func valueOf(o Operand) value {
+	switch o := o.(type) {
+	case nil:
+		return value{}
+	case Integer:
+		return makeValue(kindInteger, int(o))
+	case Float:
+		return floatValue(float64(o))
+	case Char:
+		return makeValue(kindChar, int(o))
+	case Bool:
+		return boolValue(bool(o))
+	case ProgramAddress:
+		return makeValue(kindProgramAddress, int(o))
+	case HeapAddress:
+		return makeValue(kindHeapAddress, int(o))
+	case BasePointer:
+		return makeValue(kindBasePointer, int(o))
+	case StackPointer:
+		return makeValue(kindStackPointer, int(o))
+	case StackAddress:
+		return makeValue(kindStackAddress, int(o))
+	case Channel:
+		return makeValue(kindChannel, int(o))
+	case SpecialRegister:
+		return makeValue(kindSpecialRegister, int(o))
+	case GeneralPurposeRegister:
+		return makeValue(kindGeneralPurposeRegister, int(o))
+	case FlagRegister:
+		return makeValue(kindFlagRegister, int(o))
+	case BpOffset:
+		return makeValue(kindBpOffset, int(o))
+	case SpOffset:
+		return makeValue(kindSpOffset, int(o))
+	case ProgramOffset:
+		return makeValue(kindProgramOffset, int(o))
+	case Field:
+		return value{bits: uint64(o.Offset), kind: kindField, typ: uint8(o.Type)}
+	case Element:
+		return value{bits: uint64(o.Len), kind: kindElement, typ: uint8(o.Type)}
+	default:
+		panic(fmt.Sprintf("unsupported operand: %T", o))
+	}
+}
+
+// operand valueOfの逆. 外へ返すときと, 頻繁には通らない命令で使う
+func (v value) operand() Operand {
+	n := int(v.bits)
+	switch v.kind {
+	case kindInteger:
+		return Integer(n)
+	case kindFloat:
+		return Float(v.float())
+	case kindChar:
+		return Char(n)
+	case kindBool:
+		return Bool(v.bits != 0)
+	case kindProgramAddress:
+		return ProgramAddress(n)
+	case kindHeapAddress:
+		return HeapAddress(n)
+	case kindBasePointer:
+		return BasePointer(n)
+	case kindStackPointer:
+		return StackPointer(n)
+	case kindStackAddress:
+		return StackAddress(n)
+	case kindChannel:
+		return Channel(n)
+	case kindSpecialRegister:
+		return SpecialRegister(n)
+	case kindGeneralPurposeRegister:
+		return GeneralPurposeRegister(n)
+	case kindFlagRegister:
+		return FlagRegister(n)
+	case kindBpOffset:
+		return BpOffset(n)
+	case kindSpOffset:
+		return SpOffset(n)
+	case kindProgramOffset:
+		return ProgramOffset(n)
+	case kindField:
+		return Field{Offset: n, Type: PrimitiveType(v.typ)}
+	case kindElement:
+		return Element{Type: PrimitiveType(v.typ), Len: n}
+	default:
+		return nil
+	}
+}
+
+// stock Stockableとして取り出す. Stockableでなければnil
+func (v value) stock() Stockable {
+	s, _ := v.operand().(Stockable)
+	return s
+}
+
+func (v value) float() float64 {
+	return math.Float64frombits(v.bits)
+}
+
+// int Operand.Value()と同じ. nilは呼び出し側で弾く
+func (v value) int() int {
+	switch v.kind {
+	case kindNil:
+		panic("value: int of nil")
+	case kindFloat:
+		return int(v.float())
+	default:
+		return int(v.bits)
+	}
+}
+
+// immediate Integer, Float, Char, Bool
+func (v value) immediate() bool {
+	return kindInteger <= v.kind && v.kind <= kindBool
+}
+
+// stockable スタック, ヒープに置ける値か. nilは置けない
+func (v value) stockable() bool {
+	return kindInteger <= v.kind && v.kind <= kindChannel && v.kind != kindStackPointer
+}
+
+// stockables スナップショットやテストのためにStockableの列にする
+func stockables(vs []value) []Stockable {
+	ss := make([]Stockable, len(vs))
+	for i, v := range vs {
+		ss[i] = v.stock()
+	}
+	return ss
+}
+
+// values stockablesの逆
+func values(ss []Stockable) []value {
+	vs := make([]value, len(ss))
+	for i, s := range ss {
+		vs[i] = valueOf(s)
+	}
+	return vs
+}
@@ -0,0 +1,156 @@
+package gvm
+
+import (
+	"math"
+	"testing"
+	"unsafe"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// memoryProgram i*iをn回ヒープに書いてスタック経由で読み戻し, 2倍した合計をR3に入れる
+func memoryProgram(n int) Program {
+	return Program{
+		MOV, R1, Integer(0),
+		MOV, R3, Integer(0),
+		MOV, R2, R1, // 6
+		MUL, R2, R1,
+		STORE, R1, R2,
+		LOAD, ACM1, R1,
+		PUSH, ACM1,
+		MOV, ACM1, SpOffset(0),
+		POP, R2,
+		ADD, R3, R2,
+		ADD, R3, ACM1,
+		ADD, R1, Integer(1),
+		LT, R1, Integer(n),
+		JE, ProgramAddress(6),
+	}
+}
+
+func TestValueOf(t *testing.T) {
+	tests := []Operand{
+		nil,
+		Integer(math.MinInt),
+		Integer(-1),
+		Float(-0.5),
+		Float(math.Inf(-1)),
+		Char('あ'),
+		Bool(true),
+		Bool(false),
+		ProgramAddress(3),
+		HeapAddress(4),
+		BasePointer(5),
+		StackPointer(6),
+		StackAddress(7),
+		Channel(8),
+		PC,
+		OF,
+		ACM2,
+		BpOffset(-2),
+		SpOffset(1),
+		ProgramOffset(-9),
+		Field{Offset: 2, Type: TChar},
+		Element{Type: TFloat, Len: 10},
+	}
+	for _, o := range tests {
+		if diff := cmp.Diff(o, valueOf(o).operand()); diff != "" {
+			t.Errorf("%v: diff: %s", o, diff)
+		}
+	}
+	nan := valueOf(Float(math.NaN())).operand().(Float)
+	if !math.IsNaN(float64(nan)) {
+		t.Errorf("want=NaN, got=%v", nan)
+	}
+}
+
+func TestValue_Stockable(t *testing.T) {
+	for _, o := range []Operand{nil, Integer(0), Float(0), Char(0), Bool(false), ProgramAddress(0), HeapAddress(0),
+		BasePointer(0), StackPointer(0), StackAddress(0), Channel(0), R1, BpOffset(0), Field{}, Element{}} {
+		_, expect := o.(Stockable)
+		if got := valueOf(o).stockable(); got != expect {
+			t.Errorf("%v: want=%t, got=%t", o, expect, got)
+		}
+	}
+}
+
+func TestValue_Size(t *testing.T) {
+	// インターフェースと同じ2ワードで, 箱の分の確保がない
+	if size := unsafe.Sizeof(value{}); size != 16 {
+		t.Errorf("want=16, got=%d", size)
+	}
+}
+
+func TestRuntime_Allocs(t *testing.T) {
+	program := memoryProgram(100)
+	config := &Config{StackSize: 4, HeapSize: 128}
+	code, err := decode(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRuntime(program, config)
+	// Verifyとデコードは除き, 実行中の確保だけを数える
+	runs := map[string]func() error{
+		"run":     r.loop,
+		"decoded": func() error { return r.runDecoded(code) },
+	}
+	for name, run := range runs {
+		t.Run(name, func(t *testing.T) {
+			allocs := testing.AllocsPerRun(100, func() {
+				r.Reset(program)
+				if err := run(); err != nil {
+					t.Fatal(err)
+				}
+			})
+			if allocs != 0 {
+				t.Errorf("allocs: want=0, got=%v", allocs)
+			}
+			if diff := cmp.Diff(Integer(2*328350), r.Register(R3)); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func BenchmarkMemory(b *testing.B) {
+	program := memoryProgram(1000)
+	config := &Config{StackSize: 4, HeapSize: 1024}
+	b.Run("run", func(b *testing.B) {
+		b.ReportAllocs()
+		r := NewRuntime(program, config)
+		for i := 0; i < b.N; i++ {
+			r.Reset(program)
+			if err := r.Run(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("decoded", func(b *testing.B) {
+		b.ReportAllocs()
+		r := NewRuntime(program, config)
+		for i := 0; i < b.N; i++ {
+			r.Reset(program)
+			if err := r.RunDecoded(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkSlot スタックの1セルに整数を置く. boxedは以前の[]Stockableでの表現
+func BenchmarkSlot(b *testing.B) {
+	b.Run("boxed", func(b *testing.B) {
+		b.ReportAllocs()
+		stack := make([]Stockable, 1024)
+		for i := 0; i < b.N; i++ {
+			stack[i%len(stack)] = Integer(i)
+		}
+	})
+	b.Run("value", func(b *testing.B) {
+		b.ReportAllocs()
+		stack := make([]value, 1024)
+		for i := 0; i < b.N; i++ {
+			stack[i%len(stack)] = makeValue(kindInteger, i)
+		}
+	})
+}
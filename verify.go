@@ -0,0 +1,308 @@
+package gvm
+
+import (
+	"fmt"
+)
+
+type operandKind int
+
+const (
+	kRegister operandKind = 1 << iota
+	kStackOffset
+	kProgramOffset
+	kInteger
+	kFloat
+	kImmediate // Integer, Float以外のImmediate
+	kProgramAddress
+	kHeapAddress
+	kField
+	kElement
+)
+
+func kindOf(w Word) operandKind {
+	switch w.(type) {
+	case Register:
+		return kRegister
+	case BpOffset, SpOffset:
+		return kStackOffset
+	case ProgramOffset:
+		return kProgramOffset
+	case Integer:
+		return kInteger
+	case Float:
+		return kFloat
+	case Immediate:
+		return kImmediate
+	case ProgramAddress:
+		return kProgramAddress
+	case HeapAddress:
+		return kHeapAddress
+	case Field:
+		return kField
+	case Element:
+		return kElement
+	default:
+		return 0
+	}
+}
+
+const (
+	kNumber = kInteger | kFloat
+	kValue  = kNumber | kImmediate
+	kTarget = kProgramAddress | kProgramOffset
+)
+
+// operandKinds 各opcodeのオペランドとして許される種類
+var operandKinds = [][]operandKind{
+	NOP:   {},
+	MOV:   {kRegister | kStackOffset, kRegister | kStackOffset | kValue | kProgramAddress | kHeapAddress},
+	PUSH:  {kRegister | kStackOffset | kValue},
+	POP:   {kRegister},
+	ALLOC: {kRegister | kInteger | kElement},
+	STORE: {kRegister | kHeapAddress, kRegister | kValue},
+	LOAD:  {kRegister, kRegister | kHeapAddress | kInteger},
+	CALL:  {kTarget},
+	RET:   {},
+	ADD:   {kRegister, kRegister | kNumber},
+	SUB:   {kRegister, kRegister | kNumber},
+	MUL:   {kRegister, kRegister | kNumber},
+	DIV:   {kRegister, kRegister | kNumber},
+	JMP:   {kTarget},
+	JE:    {kTarget},
+	JNE:   {kTarget},
+	EQ:    {kRegister | kValue, kRegister | kValue},
+	NE:    {kRegister | kValue, kRegister | kValue},
+	LT:    {kRegister | kValue, kRegister | kValue},
+	LE:    {kRegister | kValue, kRegister | kValue},
+	NCALL: {kInteger},
+	IN:    {kRegister},
+	ITOF:  {kRegister, kRegister | kValue},
+	FTOI:  {kRegister, kRegister | kValue},
+	CTOI:  {kRegister, kRegister | kValue},
+	ITOC:  {kRegister, kRegister | kValue},
+	ITOB:  {kRegister, kRegister | kValue},
+	BTOI:  {kRegister, kRegister | kValue},
+	CTOB:  {kRegister, kRegister | kValue},
+	BTOC:  {kRegister, kRegister | kValue},
+	FTOB:  {kRegister, kRegister | kValue},
+	BTOF:  {kRegister, kRegister | kValue},
+	CTOF:  {kRegister, kRegister | kValue},
+	FTOC:  {kRegister, kRegister | kValue},
+
+	TYPEOF: {kRegister, kRegister | kValue},
+
+	LDF: {kRegister, kRegister | kHeapAddress, kField},
+	STF: {kRegister | kHeapAddress, kField, kRegister | kValue},
+	LDX: {kRegister, kRegister | kHeapAddress, kRegister | kInteger, kElement},
+	STX: {kRegister | kHeapAddress, kRegister | kInteger, kElement, kRegister | kValue},
+
+	CMP: {kRegister | kValue, kRegister | kValue},
+	JL:  {kTarget},
+	JLE: {kTarget},
+	JG:  {kTarget},
+	JGE: {kTarget},
+	JB:  {kTarget},
+	JBE: {kTarget},
+	JA:  {kTarget},
+	JAE: {kTarget},
+	JO:  {kTarget},
+	JNO: {kTarget},
+	JS:  {kTarget},
+	JNS: {kTarget},
+
+	LEA: {kRegister, kStackOffset},
+
+	TRY:    {kTarget},
+	ENDTRY: {},
+	THROW:  {kRegister | kStackOffset | kValue},
+
+	SPAWN: {kTarget, kRegister | kStackOffset | kValue},
+	YIELD: {},
+	JOIN:  {kRegister | kInteger},
+
+	CHAN:  {kRegister, kRegister | kInteger},
+	SEND:  {kRegister | kHeapAddress, kRegister | kValue},
+	RECV:  {kRegister, kRegister | kHeapAddress},
+	CLOSE: {kRegister | kHeapAddress},
+}
+
+// isJump ジャンプ先を持つ命令か. TRYのcatchもフォールスルーと同じ深さで合流する
+func isJump(op Opcode) bool {
+	_, ok := conditions[op]
+	return op == JMP || op == TRY || ok
+}
+
+// isCall ジャンプ先を関数の入口として呼び出す命令か
+func isCall(op Opcode) bool {
+	return op == CALL || op == SPAWN
+}
+
+// writesFirst 1つ目のオペランドに結果を書く命令. MOV, POP以外はPC, BP, SP, HPに書けない
+var writesFirst = map[Opcode]bool{
+	LOAD: true, ADD: true, SUB: true, MUL: true, DIV: true, IN: true, TYPEOF: true, LDF: true, LDX: true, LEA: true, CHAN: true, RECV: true,
+	ITOF: true, FTOI: true, CTOI: true, ITOC: true, ITOB: true, BTOI: true,
+	CTOB: true, BTOC: true, FTOB: true, BTOF: true, CTOF: true, FTOC: true,
+}
+
+// pointerSources MOVでPC, HPに直接入れられる値の種類. BP, SPにはレジスタからしか入れられない
+var pointerSources = map[SpecialRegister]operandKind{
+	PC: kRegister | kProgramAddress,
+	BP: kRegister,
+	SP: kRegister,
+	HP: kRegister | kHeapAddress,
+}
+
+// verifyPointer PC, BP, SP, HPに別の種類の値を書く命令を弾く. レジスタから入れるときは実行時に確かめる
+func verifyPointer(at int, op Opcode, operands []Word) error {
+	if len(operands) == 0 {
+		return nil
+	}
+	reg, ok := operands[0].(SpecialRegister)
+	if !ok {
+		return nil
+	}
+	switch {
+	case op == MOV && kindOf(operands[1])&pointerSources[reg] == 0:
+		return fmt.Errorf("verify: %d: %s: invalid operand: %s", at, op, operands[1].String())
+	case writesFirst[op]:
+		return fmt.Errorf("verify: %d: %s: invalid operand: %s", at, op, reg.String())
+	}
+	return nil
+}
+
+// Verify 実行前にProgramの構造を検査する.
+// オペランドの数と種類, PC, BP, SP, HPに書く値の種類, ジャンプ先が命令の先頭であることを確かめる.
+func Verify(program Program) error {
+	_, err := verify(program)
+	return err
+}
+
+// VerifyStack Verifyに加えて, 制御フローの合流点でスタックの深さが一致することを確かめる.
+// ループで積み続けるような正しいProgramも弾くので, Runは行わない
+func VerifyStack(program Program) error {
+	entries, err := verify(program)
+	if err != nil {
+		return err
+	}
+	return verifyStack(program, entries)
+}
+
+// verify Verifyの本体. 関数の入口の位置を返す
+func verify(program Program) ([]int, error) {
+	boundaries := map[int]bool{len(program): true}
+	for at := 0; at < len(program); {
+		op, ok := program[at].(Opcode)
+		if !ok {
+			return nil, fmt.Errorf("verify: %d: want=opcode, got=%s", at, program[at].String())
+		}
+		if op < 0 || len(operandKinds) <= int(op) {
+			return nil, fmt.Errorf("verify: %d: unknown opcode: %d", at, int(op))
+		}
+		if len(program) < at+1+op.NumOperands() {
+			return nil, fmt.Errorf("verify: %d: %s: want %d operands, got %d", at, op, op.NumOperands(), len(program)-at-1)
+		}
+		for i, kinds := range operandKinds[op] {
+			w := program[at+1+i]
+			if _, ok := w.(Operand); !ok {
+				return nil, fmt.Errorf("verify: %d: %s: want=operand, got=%s", at, op, w.String())
+			}
+			if kindOf(w)&kinds == 0 {
+				return nil, fmt.Errorf("verify: %d: %s: invalid operand: %s", at, op, w.String())
+			}
+		}
+		if err := verifyPointer(at, op, program[at+1:at+1+op.NumOperands()]); err != nil {
+			return nil, err
+		}
+		if op == MOV {
+			if _, ok := program[at+1].(Offset); ok {
+				if _, ok := program[at+2].(Address); ok {
+					return nil, fmt.Errorf("verify: %d: %s: invalid operand: %s", at, op, program[at+2].String())
+				}
+			}
+		}
+		boundaries[at] = true
+		at += 1 + op.NumOperands()
+	}
+
+	var entries []int
+	if len(program) > 0 {
+		entries = append(entries, 0)
+	}
+	for _, inst := range Instructions(program) {
+		if !isJump(inst.Op) && !isCall(inst.Op) {
+			continue
+		}
+		dst := Target(inst.At, inst.Operands[0])
+		if !boundaries[dst] {
+			return nil, fmt.Errorf("verify: %d: %s: invalid jump target: %d", inst.At, inst.Op, dst)
+		}
+		if isCall(inst.Op) && dst < len(program) {
+			entries = append(entries, dst)
+		}
+	}
+
+	return entries, nil
+}
+
+// writesSP スタックポインタを直接書き換える命令の後は深さを追跡しない
+func writesSP(op Opcode, operands []Word) bool {
+	if op != MOV && op != POP {
+		return false
+	}
+	reg, ok := operands[0].(SpecialRegister)
+	return ok && (reg == SP || reg == BP)
+}
+
+// verifyStack 関数(エントリ)ごとに, 各命令でのスタックの深さが経路によらず一致することを確かめる.
+// CALLは呼び出し先でスタックが釣り合うものとして扱う.
+func verifyStack(program Program, entries []int) error {
+	for _, entry := range entries {
+		depths := map[int]int{entry: 0}
+		work := []int{entry}
+		for len(work) > 0 {
+			at := work[len(work)-1]
+			work = work[:len(work)-1]
+			if at == len(program) {
+				continue
+			}
+			op := program[at].(Opcode)
+			operands := program[at+1 : at+1+op.NumOperands()]
+			depth := depths[at]
+			switch op {
+			case PUSH, ALLOC:
+				depth++
+			case POP:
+				depth--
+			}
+			if depth < 0 {
+				return fmt.Errorf("verify: %d: %s: stack underflow", at, op)
+			}
+			if writesSP(op, operands) {
+				continue
+			}
+
+			var next []int
+			switch op {
+			case RET, THROW:
+			case JMP:
+				next = []int{Target(at, operands[0])}
+			default:
+				next = []int{at + 1 + op.NumOperands()}
+				if isJump(op) {
+					next = append(next, Target(at, operands[0]))
+				}
+			}
+			for _, n := range next {
+				if d, ok := depths[n]; ok {
+					if d != depth {
+						return fmt.Errorf("verify: %d: inconsistent stack depth: %d, %d", n, d, depth)
+					}
+					continue
+				}
+				depths[n] = depth
+				work = append(work, n)
+			}
+		}
+	}
+	return nil
+}
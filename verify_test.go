@@ -0,0 +1,227 @@
+package gvm
+
+import (
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		prog   Program
+		expect string
+	}{
+		{
+			"empty",
+			Program{},
+			"",
+		},
+		{
+			"valid",
+			Program{
+				PUSH, Integer(1),
+				POP, R1,
+				MOV, BpOffset(-1), R1,
+				STORE, HeapAddress(0), R1,
+				JMP, ProgramAddress(12),
+			},
+			"",
+		},
+		{
+			"call",
+			Program{
+				PUSH, Integer(1),
+				CALL, ProgramAddress(7),
+				POP, R1,
+				RET,
+				MOV, R1, BpOffset(2),
+				PUSH, R1,
+				RET,
+			},
+			"",
+		},
+		{
+			"loop",
+			Program{
+				MOV, R1, Integer(0),
+				ADD, R1, Integer(1),
+				LT, R1, Integer(10),
+				JE, ProgramOffset(-6),
+			},
+			"",
+		},
+		{
+			"not opcode",
+			Program{
+				R1,
+			},
+			"verify: 0: want=opcode, got=r1",
+		},
+		{
+			"operand is opcode",
+			Program{
+				MOV, R1, NOP,
+			},
+			"verify: 0: mov: want=operand, got=nop",
+		},
+		{
+			"truncated",
+			Program{
+				NOP,
+				MOV, R1,
+			},
+			"verify: 1: mov: want 2 operands, got 1",
+		},
+		{
+			"pop immediate",
+			Program{
+				POP, Integer(1),
+			},
+			"verify: 0: pop: invalid operand: 1",
+		},
+		{
+			"store to offset",
+			Program{
+				STORE, BpOffset(0), Integer(1),
+			},
+			"verify: 0: store: invalid operand: [bp+0]",
+		},
+		{
+			"jump into operand",
+			Program{
+				MOV, R1, Integer(0),
+				JMP, ProgramAddress(1),
+			},
+			"verify: 3: jmp: invalid jump target: 1",
+		},
+		{
+			"jump out of program",
+			Program{
+				JMP, ProgramOffset(3),
+			},
+			"verify: 0: jmp: invalid jump target: 3",
+		},
+		{
+			"mov sp immediate",
+			Program{
+				MOV, SP, Integer(3),
+			},
+			"verify: 0: mov: invalid operand: 3",
+		},
+		{
+			"mov pc heap address",
+			Program{
+				MOV, PC, HeapAddress(0),
+			},
+			"verify: 0: mov: invalid operand: @0",
+		},
+		{
+			"add to sp",
+			Program{
+				ADD, SP, Integer(1),
+			},
+			"verify: 0: add: invalid operand: sp",
+		},
+		{
+			"mov pointers",
+			Program{
+				MOV, R1, SP,
+				MOV, SP, R1,
+				MOV, HP, HeapAddress(0),
+				MOV, PC, ProgramAddress(12),
+			},
+			"",
+		},
+		{
+			// スタックの深さはVerifyStackだけが見る
+			"push in loop",
+			pushLoop,
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.prog)
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.expect {
+				t.Errorf("want=%q, got=%q", tt.expect, got)
+			}
+		})
+	}
+}
+
+// pushLoop ループで積み続ける. 合流点で深さが違うが正しく動く
+var pushLoop = Program{
+	MOV, R1, Integer(0),
+	PUSH, R1, // 3
+	ADD, R1, Integer(1),
+	LT, R1, Integer(3),
+	JE, ProgramAddress(3),
+}
+
+func TestVerifyStack(t *testing.T) {
+	tests := []struct {
+		name   string
+		prog   Program
+		expect string
+	}{
+		{
+			"call",
+			Program{
+				PUSH, Integer(1),
+				CALL, ProgramAddress(7),
+				POP, R1,
+				RET,
+				MOV, R1, BpOffset(2),
+				PUSH, R1,
+				RET,
+			},
+			"",
+		},
+		{
+			"structure",
+			Program{
+				POP, Integer(1),
+			},
+			"verify: 0: pop: invalid operand: 1",
+		},
+		{
+			"underflow",
+			Program{
+				POP, R1,
+			},
+			"verify: 0: pop: stack underflow",
+		},
+		{
+			"inconsistent depth",
+			Program{
+				EQ, R1, Integer(0),
+				JE, ProgramAddress(7),
+				PUSH, Integer(1),
+				NOP,
+			},
+			"verify: 7: inconsistent stack depth: 0, 1",
+		},
+		{
+			"push in loop",
+			pushLoop,
+			"verify: 3: inconsistent stack depth: 0, 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyStack(tt.prog)
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.expect {
+				t.Errorf("want=%q, got=%q", tt.expect, got)
+			}
+		})
+	}
+}
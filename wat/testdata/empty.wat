@@ -0,0 +1,89 @@
+(module
+  (memory (export "memory") 1)
+  (global $sp (mut i32) (i32.const 63))
+  (global $bp (mut i32) (i32.const 0))
+  (global $hp (mut i32) (i32.const 0))
+  (global $zf (mut i32) (i32.const 0))
+  (global $sf (mut i32) (i32.const 0))
+  (global $cf (mut i32) (i32.const 0))
+  (global $of (mut i32) (i32.const 0))
+  (func $slot (param $i i32) (result i32)
+    (if (i32.ge_u (local.get $i) (i32.const 64)) (then (unreachable)))
+    (i32.mul (local.get $i) (i32.const 8)))
+  (func $heap (param $a i64) (result i32)
+    (if (i64.ge_u (local.get $a) (i64.const 16)) (then (unreachable)))
+    (i32.add (i32.const 512) (i32.mul (i32.wrap_i64 (local.get $a)) (i32.const 8))))
+  (func $push (param $v i64)
+    (global.set $sp (i32.sub (global.get $sp) (i32.const 1)))
+    (if (i32.lt_s (global.get $sp) (i32.const 0)) (then (unreachable)))
+    (i64.store (call $slot (global.get $sp)) (local.get $v)))
+  (func $pop (result i64)
+    (local $v i64)
+    (local.set $v (i64.load (call $slot (global.get $sp))))
+    (i64.store (call $slot (global.get $sp)) (i64.const 0))
+    (global.set $sp (i32.add (global.get $sp) (i32.const 1)))
+    (local.get $v))
+  (func $alloc (param $n i64)
+    (if (i64.le_s (i64.const 16) (i64.add (i64.extend_i32_s (global.get $hp)) (local.get $n))) (then (unreachable)))
+    (call $push (i64.extend_i32_s (global.get $hp)))
+    (global.set $hp (i32.add (global.get $hp) (i32.wrap_i64 (local.get $n)))))
+  (func $unordered (result i32)
+    (i32.and (global.get $zf) (global.get $sf)))
+  (func $compare (param $zf i32)
+    (global.set $zf (local.get $zf))
+    (global.set $sf (i32.const 0))
+    (global.set $cf (i32.const 0))
+    (global.set $of (i32.const 0)))
+  (func $flags (param $z i64)
+    (global.set $zf (i64.eqz (local.get $z)))
+    (global.set $sf (i64.lt_s (local.get $z) (i64.const 0))))
+  (func $add (param $x i64) (param $y i64) (result i64)
+    (local $z i64)
+    (local.set $z (i64.add (local.get $x) (local.get $y)))
+    (call $flags (local.get $z))
+    (global.set $cf (i64.lt_u (local.get $z) (local.get $x)))
+    (global.set $of (i64.lt_s (i64.and (i64.xor (local.get $x) (local.get $z)) (i64.xor (local.get $y) (local.get $z))) (i64.const 0)))
+    (local.get $z))
+  (func $sub (param $x i64) (param $y i64) (result i64)
+    (local $z i64)
+    (local.set $z (i64.sub (local.get $x) (local.get $y)))
+    (call $flags (local.get $z))
+    (global.set $cf (i64.lt_u (local.get $x) (local.get $y)))
+    (global.set $of (i64.lt_s (i64.and (i64.xor (local.get $x) (local.get $y)) (i64.xor (local.get $x) (local.get $z))) (i64.const 0)))
+    (local.get $z))
+  (func $mul (param $x i64) (param $y i64) (result i64)
+    (local $z i64)
+    (local.set $z (i64.mul (local.get $x) (local.get $y)))
+    (call $flags (local.get $z))
+    (global.set $cf
+      (if (result i32) (i64.eqz (local.get $x))
+        (then (i32.const 0))
+        (else
+          (if (result i32) (i64.eq (local.get $x) (i64.const -1))
+            (then (i64.eq (local.get $y) (i64.const -9223372036854775808)))
+            (else (i64.ne (i64.div_s (local.get $z) (local.get $x)) (local.get $y)))))))
+    (global.set $of (global.get $cf))
+    (local.get $z))
+  (func $div (param $x i64) (param $y i64) (result i64)
+    (local $z i64)
+    (global.set $of (i32.and (i64.eq (local.get $x) (i64.const -9223372036854775808)) (i64.eq (local.get $y) (i64.const -1))))
+    (if (global.get $of)
+      (then (local.set $z (local.get $x)))
+      (else (local.set $z (i64.div_s (local.get $x) (local.get $y)))))
+    (call $flags (local.get $z))
+    (global.set $cf (i32.const 0))
+    (local.get $z))
+  (func (export "run") (result i64)
+    (local $pc i32)
+    (local $r1 i64)
+    (loop $dispatch
+      (block $end
+        (block $trap
+          (br_table $end $trap (local.get $pc))
+        )
+        (unreachable)
+      )
+    )
+    (local.get $r1)
+  )
+)
@@ -0,0 +1,266 @@
+(module
+  (memory (export "memory") 1)
+  (global $sp (mut i32) (i32.const 63))
+  (global $bp (mut i32) (i32.const 0))
+  (global $hp (mut i32) (i32.const 0))
+  (global $zf (mut i32) (i32.const 0))
+  (global $sf (mut i32) (i32.const 0))
+  (global $cf (mut i32) (i32.const 0))
+  (global $of (mut i32) (i32.const 0))
+  (func $slot (param $i i32) (result i32)
+    (if (i32.ge_u (local.get $i) (i32.const 64)) (then (unreachable)))
+    (i32.mul (local.get $i) (i32.const 8)))
+  (func $heap (param $a i64) (result i32)
+    (if (i64.ge_u (local.get $a) (i64.const 16)) (then (unreachable)))
+    (i32.add (i32.const 512) (i32.mul (i32.wrap_i64 (local.get $a)) (i32.const 8))))
+  (func $push (param $v i64)
+    (global.set $sp (i32.sub (global.get $sp) (i32.const 1)))
+    (if (i32.lt_s (global.get $sp) (i32.const 0)) (then (unreachable)))
+    (i64.store (call $slot (global.get $sp)) (local.get $v)))
+  (func $pop (result i64)
+    (local $v i64)
+    (local.set $v (i64.load (call $slot (global.get $sp))))
+    (i64.store (call $slot (global.get $sp)) (i64.const 0))
+    (global.set $sp (i32.add (global.get $sp) (i32.const 1)))
+    (local.get $v))
+  (func $alloc (param $n i64)
+    (if (i64.le_s (i64.const 16) (i64.add (i64.extend_i32_s (global.get $hp)) (local.get $n))) (then (unreachable)))
+    (call $push (i64.extend_i32_s (global.get $hp)))
+    (global.set $hp (i32.add (global.get $hp) (i32.wrap_i64 (local.get $n)))))
+  (func $unordered (result i32)
+    (i32.and (global.get $zf) (global.get $sf)))
+  (func $compare (param $zf i32)
+    (global.set $zf (local.get $zf))
+    (global.set $sf (i32.const 0))
+    (global.set $cf (i32.const 0))
+    (global.set $of (i32.const 0)))
+  (func $flags (param $z i64)
+    (global.set $zf (i64.eqz (local.get $z)))
+    (global.set $sf (i64.lt_s (local.get $z) (i64.const 0))))
+  (func $add (param $x i64) (param $y i64) (result i64)
+    (local $z i64)
+    (local.set $z (i64.add (local.get $x) (local.get $y)))
+    (call $flags (local.get $z))
+    (global.set $cf (i64.lt_u (local.get $z) (local.get $x)))
+    (global.set $of (i64.lt_s (i64.and (i64.xor (local.get $x) (local.get $z)) (i64.xor (local.get $y) (local.get $z))) (i64.const 0)))
+    (local.get $z))
+  (func $sub (param $x i64) (param $y i64) (result i64)
+    (local $z i64)
+    (local.set $z (i64.sub (local.get $x) (local.get $y)))
+    (call $flags (local.get $z))
+    (global.set $cf (i64.lt_u (local.get $x) (local.get $y)))
+    (global.set $of (i64.lt_s (i64.and (i64.xor (local.get $x) (local.get $y)) (i64.xor (local.get $x) (local.get $z))) (i64.const 0)))
+    (local.get $z))
+  (func $mul (param $x i64) (param $y i64) (result i64)
+    (local $z i64)
+    (local.set $z (i64.mul (local.get $x) (local.get $y)))
+    (call $flags (local.get $z))
+    (global.set $cf
+      (if (result i32) (i64.eqz (local.get $x))
+        (then (i32.const 0))
+        (else
+          (if (result i32) (i64.eq (local.get $x) (i64.const -1))
+            (then (i64.eq (local.get $y) (i64.const -9223372036854775808)))
+            (else (i64.ne (i64.div_s (local.get $z) (local.get $x)) (local.get $y)))))))
+    (global.set $of (global.get $cf))
+    (local.get $z))
+  (func $div (param $x i64) (param $y i64) (result i64)
+    (local $z i64)
+    (global.set $of (i32.and (i64.eq (local.get $x) (i64.const -9223372036854775808)) (i64.eq (local.get $y) (i64.const -1))))
+    (if (global.get $of)
+      (then (local.set $z (local.get $x)))
+      (else (local.set $z (i64.div_s (local.get $x) (local.get $y)))))
+    (call $flags (local.get $z))
+    (global.set $cf (i32.const 0))
+    (local.get $z))
+  (func (export "run") (result i64)
+    (local $pc i32)
+    (local $r1 i64)
+    (local $r2 i64)
+    (loop $dispatch
+      (block $end
+        (block $trap
+          (block $L11
+            (block $L10
+              (block $L9
+                (block $L8
+                  (block $L7
+                    (block $L6
+                      (block $L5
+                        (block $L4
+                          (block $L3
+                            (block $L2
+                              (block $L1
+                                (block $L0
+                                  (br_table $L0 $L1 $L2 $L3 $L4 $L5 $L6 $L7 $L8 $L9 $L10 $L11 $end $trap (local.get $pc))
+                                )
+                                ;; L0: @0
+                                ;; call @98
+                                (call $push (i64.const 1))
+                                (call $push (i64.extend_i32_s (global.get $bp)))
+                                (global.set $bp (global.get $sp))
+                                (local.set $pc (i32.const 9))
+                                (br $dispatch)
+                              )
+                              ;; L1: @2
+                              ;; jmp @112
+                              (local.set $pc (i32.const 12))
+                              (br $dispatch)
+                            )
+                            ;; L2: @4
+                            ;; mov r1, [bp+2]
+                            (local.set $r1 (i64.load (call $slot (i32.add (global.get $bp) (i32.const 2)))))
+                            ;; push r1
+                            (call $push (local.get $r1))
+                            ;; mov r1, 2
+                            (local.set $r1 (i64.const 2))
+                            ;; mov r2, r1
+                            (local.set $r2 (local.get $r1))
+                            ;; pop r1
+                            (local.set $r1 (call $pop))
+                            ;; cmp r1, r2
+                            (drop (call $sub (local.get $r1) (local.get $r2)))
+                            ;; mov r1, 1
+                            (local.set $r1 (i64.const 1))
+                            ;; jl @28
+                            (if (i32.and (i32.eqz (call $unordered)) (i32.ne (global.get $sf) (global.get $of)))
+                              (then
+                                (local.set $pc (i32.const 3))
+                                (br $dispatch)
+                              )
+                            )
+                            ;; mov r1, 0
+                            (local.set $r1 (i64.const 0))
+                          )
+                          ;; L3: @28
+                          ;; cmp r1, 0
+                          (drop (call $sub (local.get $r1) (i64.const 0)))
+                          ;; je @39
+                          (if (i32.and (i32.eqz (call $unordered)) (global.get $zf))
+                            (then
+                              (local.set $pc (i32.const 5))
+                              (br $dispatch)
+                            )
+                          )
+                          ;; mov r1, [bp+2]
+                          (local.set $r1 (i64.load (call $slot (i32.add (global.get $bp) (i32.const 2)))))
+                          ;; ret
+                          (global.set $sp (global.get $bp))
+                          (global.set $bp (i32.wrap_i64 (call $pop)))
+                          (local.set $pc (i32.wrap_i64 (call $pop)))
+                          (br $dispatch)
+                        )
+                        ;; L4: @37
+                        ;; jmp @39
+                        (local.set $pc (i32.const 5))
+                        (br $dispatch)
+                      )
+                      ;; L5: @39
+                      ;; mov r1, [bp+2]
+                      (local.set $r1 (i64.load (call $slot (i32.add (global.get $bp) (i32.const 2)))))
+                      ;; push r1
+                      (call $push (local.get $r1))
+                      ;; mov r1, 1
+                      (local.set $r1 (i64.const 1))
+                      ;; mov r2, r1
+                      (local.set $r2 (local.get $r1))
+                      ;; pop r1
+                      (local.set $r1 (call $pop))
+                      ;; sub r1, r2
+                      (local.set $r1 (call $sub (local.get $r1) (local.get $r2)))
+                      ;; push r1
+                      (call $push (local.get $r1))
+                      ;; call @4
+                      (call $push (i64.const 6))
+                      (call $push (i64.extend_i32_s (global.get $bp)))
+                      (global.set $bp (global.get $sp))
+                      (local.set $pc (i32.const 2))
+                      (br $dispatch)
+                    )
+                    ;; L6: @59
+                    ;; pop r2
+                    (local.set $r2 (call $pop))
+                    ;; push r1
+                    (call $push (local.get $r1))
+                    ;; mov r1, [bp+2]
+                    (local.set $r1 (i64.load (call $slot (i32.add (global.get $bp) (i32.const 2)))))
+                    ;; push r1
+                    (call $push (local.get $r1))
+                    ;; mov r1, 2
+                    (local.set $r1 (i64.const 2))
+                    ;; mov r2, r1
+                    (local.set $r2 (local.get $r1))
+                    ;; pop r1
+                    (local.set $r1 (call $pop))
+                    ;; sub r1, r2
+                    (local.set $r1 (call $sub (local.get $r1) (local.get $r2)))
+                    ;; push r1
+                    (call $push (local.get $r1))
+                    ;; call @4
+                    (call $push (i64.const 7))
+                    (call $push (i64.extend_i32_s (global.get $bp)))
+                    (global.set $bp (global.get $sp))
+                    (local.set $pc (i32.const 2))
+                    (br $dispatch)
+                  )
+                  ;; L7: @83
+                  ;; pop r2
+                  (local.set $r2 (call $pop))
+                  ;; mov r2, r1
+                  (local.set $r2 (local.get $r1))
+                  ;; pop r1
+                  (local.set $r1 (call $pop))
+                  ;; add r1, r2
+                  (local.set $r1 (call $add (local.get $r1) (local.get $r2)))
+                  ;; ret
+                  (global.set $sp (global.get $bp))
+                  (global.set $bp (i32.wrap_i64 (call $pop)))
+                  (local.set $pc (i32.wrap_i64 (call $pop)))
+                  (br $dispatch)
+                )
+                ;; L8: @94
+                ;; mov r1, 0
+                (local.set $r1 (i64.const 0))
+                ;; ret
+                (global.set $sp (global.get $bp))
+                (global.set $bp (i32.wrap_i64 (call $pop)))
+                (local.set $pc (i32.wrap_i64 (call $pop)))
+                (br $dispatch)
+              )
+              ;; L9: @98
+              ;; mov r1, 12
+              (local.set $r1 (i64.const 12))
+              ;; push r1
+              (call $push (local.get $r1))
+              ;; call @4
+              (call $push (i64.const 10))
+              (call $push (i64.extend_i32_s (global.get $bp)))
+              (global.set $bp (global.get $sp))
+              (local.set $pc (i32.const 2))
+              (br $dispatch)
+            )
+            ;; L10: @105
+            ;; pop r2
+            (local.set $r2 (call $pop))
+            ;; ret
+            (global.set $sp (global.get $bp))
+            (global.set $bp (i32.wrap_i64 (call $pop)))
+            (local.set $pc (i32.wrap_i64 (call $pop)))
+            (br $dispatch)
+          )
+          ;; L11: @108
+          ;; mov r1, 0
+          (local.set $r1 (i64.const 0))
+          ;; ret
+          (global.set $sp (global.get $bp))
+          (global.set $bp (i32.wrap_i64 (call $pop)))
+          (local.set $pc (i32.wrap_i64 (call $pop)))
+          (br $dispatch)
+          (br $end)
+        )
+        (unreachable)
+      )
+    )
+    (local.get $r1)
+  )
+)
@@ -0,0 +1,363 @@
+package wat
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/x0y14/gvm"
+)
+
+// cell メモリ上の1セルのバイト数. 値はすべてi64
+const cell = 8
+
+// conditions 条件付きジャンプの条件. フラグはi32のグローバル変数.
+// gvmと同じくZFとSFが同時に立っていれば順序なし($unordered)で, 大小を見る条件は飛ばない
+var conditions = map[gvm.Opcode]string{
+	gvm.JE:  ordered("(global.get $zf)"),
+	gvm.JNE: "(i32.or (i32.eqz (global.get $zf)) (call $unordered))",
+	gvm.JL:  ordered("(i32.ne (global.get $sf) (global.get $of))"),
+	gvm.JLE: ordered("(i32.or (global.get $zf) (i32.ne (global.get $sf) (global.get $of)))"),
+	gvm.JG:  ordered("(i32.and (i32.eqz (global.get $zf)) (i32.eq (global.get $sf) (global.get $of)))"),
+	gvm.JGE: ordered("(i32.eq (global.get $sf) (global.get $of))"),
+	gvm.JB:  ordered("(global.get $cf)"),
+	gvm.JBE: ordered("(i32.or (global.get $cf) (global.get $zf))"),
+	gvm.JA:  ordered("(i32.and (i32.eqz (global.get $cf)) (i32.eqz (global.get $zf)))"),
+	gvm.JAE: ordered("(i32.eqz (global.get $cf))"),
+	gvm.JO:  "(global.get $of)",
+	gvm.JNO: "(i32.eqz (global.get $of))",
+	gvm.JS:  "(global.get $sf)",
+	gvm.JNS: "(i32.eqz (global.get $sf))",
+}
+
+// ordered 大小, 等しいかを見る条件. 順序なしでは0にする
+func ordered(cond string) string {
+	return "(i32.and (i32.eqz (call $unordered)) " + cond + ")"
+}
+
+// comparisons EQ, NE, LT, LEの結果はZFに入る. 前のSFで順序なしに見えないよう, $compareは他のフラグを下ろす
+var comparisons = map[gvm.Opcode]string{
+	gvm.EQ: "i64.eq",
+	gvm.NE: "i64.ne",
+	gvm.LT: "i64.lt_s",
+	gvm.LE: "i64.le_s",
+}
+
+var arithmetic = map[gvm.Opcode]string{
+	gvm.ADD: "$add",
+	gvm.SUB: "$sub",
+	gvm.MUL: "$mul",
+	gvm.DIV: "$div",
+}
+
+type exporter struct {
+	sb     strings.Builder
+	indent int
+	labels map[int]int // Program上の位置と, br_tableでの番号. Programの終わりは最後の番号
+}
+
+func (e *exporter) line(format string, args ...any) {
+	e.sb.WriteString(strings.Repeat("  ", e.indent))
+	fmt.Fprintf(&e.sb, format, args...)
+	e.sb.WriteString("\n")
+}
+
+// Export ProgramをWebAssemblyのテキスト形式(WAT)のモジュールにする.
+// 値はすべてi64のIntegerとして扱い, メモリは先頭からスタック, ヒープの順にConfigの大きさで8バイトずつ並べる.
+// 汎用レジスタはローカル変数, SP, BP, HPとフラグはグローバル変数になる.
+// エクスポートするのはメモリ"memory"と, 実行してR1を返す関数"run".
+// 0除算, ヒープの範囲外, スタックあふれはトラップになる. CALLの戻り先は位置ではなくラベルの番号で積む.
+func Export(program gvm.Program, config *gvm.Config) (string, error) {
+	if err := gvm.Verify(program); err != nil {
+		return "", err
+	}
+	insts := gvm.Instructions(program)
+
+	starts := map[int]bool{}
+	if len(insts) > 0 {
+		starts[0] = true
+	}
+	registers := map[int]bool{1: true}
+	for _, inst := range insts {
+		if err := check(inst); err != nil {
+			return "", err
+		}
+		switch {
+		case inst.Op == gvm.CALL:
+			starts[gvm.Target(inst.At, inst.Operands[0])] = true
+			starts[inst.Next()] = true
+		case inst.Op == gvm.JMP || conditions[inst.Op] != "":
+			starts[gvm.Target(inst.At, inst.Operands[0])] = true
+		}
+		if inst.Op == gvm.JMP || inst.Op == gvm.RET {
+			starts[inst.Next()] = true
+		}
+		for _, w := range inst.Operands {
+			if reg, ok := w.(gvm.GeneralPurposeRegister); ok {
+				registers[int(reg)] = true
+			}
+		}
+	}
+	delete(starts, len(program))
+	var positions []int
+	for at := range starts {
+		positions = append(positions, at)
+	}
+	sort.Ints(positions)
+	e := &exporter{labels: map[int]int{len(program): len(positions)}}
+	for i, at := range positions {
+		e.labels[at] = i
+	}
+
+	stack, heap := config.StackSize, config.HeapSize
+	pages := max(1, ((stack+heap)*cell+65535)/65536)
+	e.line("(module")
+	e.indent++
+	e.line("(memory (export \"memory\") %d)", pages)
+	e.line("(global $sp (mut i32) (i32.const %d))", stack-1)
+	e.line("(global $bp (mut i32) (i32.const 0))")
+	e.line("(global $hp (mut i32) (i32.const 0))")
+	for _, f := range []string{"zf", "sf", "cf", "of"} {
+		e.line("(global $%s (mut i32) (i32.const 0))", f)
+	}
+	e.helpers(stack, heap)
+
+	e.line("(func (export \"run\") (result i64)")
+	e.indent++
+	e.line("(local $pc i32)")
+	var regs []int
+	for n := range registers {
+		regs = append(regs, n)
+	}
+	sort.Ints(regs)
+	for _, n := range regs {
+		e.line("(local $r%d i64)", n)
+	}
+	e.line("(loop $dispatch")
+	e.indent++
+	e.line("(block $end")
+	e.indent++
+	e.line("(block $trap")
+	e.indent++
+	for i := len(positions) - 1; 0 <= i; i-- {
+		e.line("(block $L%d", i)
+		e.indent++
+	}
+	table := make([]string, 0, len(positions)+1)
+	for i := range positions {
+		table = append(table, fmt.Sprintf("$L%d", i))
+	}
+	table = append(table, "$end")
+	e.line("(br_table %s $trap (local.get $pc))", strings.Join(table, " "))
+	for i, inst := range insts {
+		if id, ok := e.labels[inst.At]; ok {
+			e.indent--
+			e.line(")")
+			e.line(";; L%d: @%d", id, inst.At)
+		}
+		e.insn(inst)
+		if i == len(insts)-1 {
+			e.line("(br $end)")
+		}
+	}
+	e.indent--
+	e.line(")")
+	e.line("(unreachable)")
+	e.indent--
+	e.line(")")
+	e.indent--
+	e.line(")")
+	e.line("(local.get $r1)")
+	e.indent--
+	e.line(")")
+	e.indent--
+	e.line(")")
+	return e.sb.String(), nil
+}
+
+// check WATにできる命令とオペランドか
+func check(inst gvm.Instruction) error {
+	switch inst.Op {
+	case gvm.NOP, gvm.MOV, gvm.PUSH, gvm.POP, gvm.ALLOC, gvm.STORE, gvm.LOAD,
+		gvm.ADD, gvm.SUB, gvm.MUL, gvm.DIV, gvm.CMP, gvm.EQ, gvm.NE, gvm.LT, gvm.LE,
+		gvm.JMP, gvm.CALL, gvm.RET:
+	default:
+		if _, ok := conditions[inst.Op]; !ok {
+			return fmt.Errorf("wat: %w", inst.Errorf("unsupported opcode: %s", inst.Op))
+		}
+	}
+	for _, w := range inst.Operands {
+		switch w.(type) {
+		case gvm.GeneralPurposeRegister, gvm.Integer, gvm.HeapAddress, gvm.BpOffset, gvm.SpOffset, gvm.Element:
+		case gvm.ProgramAddress, gvm.ProgramOffset:
+			if inst.Op == gvm.MOV {
+				return fmt.Errorf("wat: %w", inst.Errorf("unsupported operand: %s", w))
+			}
+		default:
+			return fmt.Errorf("wat: %w", inst.Errorf("unsupported operand: %s", w))
+		}
+	}
+	return nil
+}
+
+// slot スタック上の位置のバイトアドレスの式
+func slot(w gvm.Word) string {
+	switch w := w.(type) {
+	case gvm.BpOffset:
+		return fmt.Sprintf("(call $slot (i32.add (global.get $bp) (i32.const %d)))", w)
+	default:
+		return fmt.Sprintf("(call $slot (i32.add (global.get $sp) (i32.const %d)))", w.(gvm.SpOffset))
+	}
+}
+
+// local 汎用レジスタのローカル変数名. acm1などの別名ではなく$r<番号>にする
+func local(w gvm.Word) string {
+	return fmt.Sprintf("$r%d", w.(gvm.GeneralPurposeRegister))
+}
+
+// value オペランドの値(i64)の式
+func value(w gvm.Word) string {
+	switch w := w.(type) {
+	case gvm.GeneralPurposeRegister:
+		return fmt.Sprintf("(local.get %s)", local(w))
+	case gvm.Offset:
+		return fmt.Sprintf("(i64.load %s)", slot(w))
+	default:
+		return fmt.Sprintf("(i64.const %d)", w.(gvm.Operand).Value())
+	}
+}
+
+// jump pcをラベルの番号にしてdispatchへ戻る
+func (e *exporter) jump(at int) {
+	e.line("(local.set $pc (i32.const %d))", e.labels[at])
+	e.line("(br $dispatch)")
+}
+
+func (e *exporter) insn(inst gvm.Instruction) {
+	ops := inst.Operands
+	e.line(";; %s", inst.String())
+	switch op := inst.Op; op {
+	case gvm.NOP:
+	case gvm.MOV:
+		if reg, ok := ops[0].(gvm.GeneralPurposeRegister); ok {
+			e.line("(local.set %s %s)", local(reg), value(ops[1]))
+		} else {
+			e.line("(i64.store %s %s)", slot(ops[0]), value(ops[1]))
+		}
+	case gvm.PUSH:
+		e.line("(call $push %s)", value(ops[0]))
+	case gvm.POP:
+		e.line("(local.set %s (call $pop))", local(ops[0]))
+	case gvm.ALLOC:
+		e.line("(call $alloc %s)", value(ops[0]))
+	case gvm.LOAD:
+		e.line("(local.set %s (i64.load (call $heap %s)))", local(ops[0]), value(ops[1]))
+	case gvm.STORE:
+		e.line("(i64.store (call $heap %s) %s)", value(ops[0]), value(ops[1]))
+	case gvm.ADD, gvm.SUB, gvm.MUL, gvm.DIV:
+		e.line("(local.set %s (call %s (local.get %s) %s))", local(ops[0]), arithmetic[op], local(ops[0]), value(ops[1]))
+	case gvm.CMP:
+		e.line("(drop (call $sub %s %s))", value(ops[0]), value(ops[1]))
+	case gvm.EQ, gvm.NE, gvm.LT, gvm.LE:
+		e.line("(call $compare (%s %s %s))", comparisons[op], value(ops[0]), value(ops[1]))
+	case gvm.JMP:
+		e.jump(gvm.Target(inst.At, ops[0]))
+	case gvm.CALL:
+		e.line("(call $push (i64.const %d))", e.labels[inst.Next()])
+		e.line("(call $push (i64.extend_i32_s (global.get $bp)))")
+		e.line("(global.set $bp (global.get $sp))")
+		e.jump(gvm.Target(inst.At, ops[0]))
+	case gvm.RET:
+		e.line("(global.set $sp (global.get $bp))")
+		e.line("(global.set $bp (i32.wrap_i64 (call $pop)))")
+		e.line("(local.set $pc (i32.wrap_i64 (call $pop)))")
+		e.line("(br $dispatch)")
+	default:
+		e.line("(if %s", conditions[op])
+		e.indent++
+		e.line("(then")
+		e.indent++
+		e.jump(gvm.Target(inst.At, ops[0]))
+		e.indent--
+		e.line(")")
+		e.indent--
+		e.line(")")
+	}
+}
+
+// helpers スタック, ヒープの読み書きと, フラグを立てる算術の関数
+func (e *exporter) helpers(stack, heap int) {
+	for _, l := range strings.Split(fmt.Sprintf(helpers, stack, cell, heap, stack*cell, cell, heap), "\n") {
+		if l != "" {
+			e.line("%s", l)
+		}
+	}
+}
+
+const helpers = `(func $slot (param $i i32) (result i32)
+  (if (i32.ge_u (local.get $i) (i32.const %d)) (then (unreachable)))
+  (i32.mul (local.get $i) (i32.const %d)))
+(func $heap (param $a i64) (result i32)
+  (if (i64.ge_u (local.get $a) (i64.const %d)) (then (unreachable)))
+  (i32.add (i32.const %d) (i32.mul (i32.wrap_i64 (local.get $a)) (i32.const %d))))
+(func $push (param $v i64)
+  (global.set $sp (i32.sub (global.get $sp) (i32.const 1)))
+  (if (i32.lt_s (global.get $sp) (i32.const 0)) (then (unreachable)))
+  (i64.store (call $slot (global.get $sp)) (local.get $v)))
+(func $pop (result i64)
+  (local $v i64)
+  (local.set $v (i64.load (call $slot (global.get $sp))))
+  (i64.store (call $slot (global.get $sp)) (i64.const 0))
+  (global.set $sp (i32.add (global.get $sp) (i32.const 1)))
+  (local.get $v))
+(func $alloc (param $n i64)
+  (if (i64.le_s (i64.const %d) (i64.add (i64.extend_i32_s (global.get $hp)) (local.get $n))) (then (unreachable)))
+  (call $push (i64.extend_i32_s (global.get $hp)))
+  (global.set $hp (i32.add (global.get $hp) (i32.wrap_i64 (local.get $n)))))
+(func $unordered (result i32)
+  (i32.and (global.get $zf) (global.get $sf)))
+(func $compare (param $zf i32)
+  (global.set $zf (local.get $zf))
+  (global.set $sf (i32.const 0))
+  (global.set $cf (i32.const 0))
+  (global.set $of (i32.const 0)))
+(func $flags (param $z i64)
+  (global.set $zf (i64.eqz (local.get $z)))
+  (global.set $sf (i64.lt_s (local.get $z) (i64.const 0))))
+(func $add (param $x i64) (param $y i64) (result i64)
+  (local $z i64)
+  (local.set $z (i64.add (local.get $x) (local.get $y)))
+  (call $flags (local.get $z))
+  (global.set $cf (i64.lt_u (local.get $z) (local.get $x)))
+  (global.set $of (i64.lt_s (i64.and (i64.xor (local.get $x) (local.get $z)) (i64.xor (local.get $y) (local.get $z))) (i64.const 0)))
+  (local.get $z))
+(func $sub (param $x i64) (param $y i64) (result i64)
+  (local $z i64)
+  (local.set $z (i64.sub (local.get $x) (local.get $y)))
+  (call $flags (local.get $z))
+  (global.set $cf (i64.lt_u (local.get $x) (local.get $y)))
+  (global.set $of (i64.lt_s (i64.and (i64.xor (local.get $x) (local.get $y)) (i64.xor (local.get $x) (local.get $z))) (i64.const 0)))
+  (local.get $z))
+(func $mul (param $x i64) (param $y i64) (result i64)
+  (local $z i64)
+  (local.set $z (i64.mul (local.get $x) (local.get $y)))
+  (call $flags (local.get $z))
+  (global.set $cf
+    (if (result i32) (i64.eqz (local.get $x))
+      (then (i32.const 0))
+      (else
+        (if (result i32) (i64.eq (local.get $x) (i64.const -1))
+          (then (i64.eq (local.get $y) (i64.const -9223372036854775808)))
+          (else (i64.ne (i64.div_s (local.get $z) (local.get $x)) (local.get $y)))))))
+  (global.set $of (global.get $cf))
+  (local.get $z))
+(func $div (param $x i64) (param $y i64) (result i64)
+  (local $z i64)
+  (global.set $of (i32.and (i64.eq (local.get $x) (i64.const -9223372036854775808)) (i64.eq (local.get $y) (i64.const -1))))
+  (if (global.get $of)
+    (then (local.set $z (local.get $x)))
+    (else (local.set $z (i64.div_s (local.get $x) (local.get $y)))))
+  (call $flags (local.get $z))
+  (global.set $cf (i32.const 0))
+  (local.get $z))
+`
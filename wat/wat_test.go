@@ -0,0 +1,278 @@
+package wat
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/x0y14/gvm"
+	"github.com/x0y14/gvm/internal/sample"
+)
+
+// programs testdata/<name>.watと比べるProgram
+var programs = sample.Integers
+
+func TestExport(t *testing.T) {
+	for _, tt := range programs {
+		t.Run(tt.Name, func(t *testing.T) {
+			src, err := Export(tt.Compile(t), sample.Config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := validate(src); err != nil {
+				t.Fatalf("%v\n%s", err, src)
+			}
+			golden := filepath.Join("testdata", tt.Name+".wat")
+			if *sample.Update {
+				if err := os.WriteFile(golden, []byte(src), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expect, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(expect), src); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+// TestExport_Wat2wasm wat2wasmがあれば, バイナリにできることも確かめる
+func TestExport_Wat2wasm(t *testing.T) {
+	wat2wasm, err := exec.LookPath("wat2wasm")
+	if err != nil {
+		t.Skip("wat2wasm not found")
+	}
+	for _, tt := range programs {
+		t.Run(tt.Name, func(t *testing.T) {
+			src, err := Export(tt.Compile(t), sample.Config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			path := filepath.Join(dir, "a.wat")
+			if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			out, err := exec.Command(wat2wasm, path, "-o", filepath.Join(dir, "a.wasm")).CombinedOutput()
+			if err != nil {
+				t.Fatalf("%v: %s", err, out)
+			}
+		})
+	}
+}
+
+func TestExport_Error(t *testing.T) {
+	tests := []struct {
+		name    string
+		program gvm.Program
+		expect  string
+	}{
+		{"opcode", gvm.Program{gvm.NOP, gvm.YIELD}, "wat: 1: unsupported opcode: yield"},
+		{"float", gvm.Program{gvm.MOV, gvm.R1, gvm.Float(1.5)}, "wat: 0: unsupported operand: 1.5"},
+		{"special register", gvm.Program{gvm.MOV, gvm.R1, gvm.SP}, "wat: 0: unsupported operand: sp"},
+		{"program address", gvm.Program{gvm.MOV, gvm.R1, gvm.ProgramAddress(0)}, "wat: 0: unsupported operand: @0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Export(tt.program, sample.Config)
+			if err == nil || err.Error() != tt.expect {
+				t.Errorf("want=%s, got=%v", tt.expect, err)
+			}
+		})
+	}
+	if _, err := Export(gvm.Program{gvm.MOV, gvm.R1}, sample.Config); err == nil {
+		t.Error("want verify error")
+	}
+}
+
+// sexp WATのS式
+type sexp struct {
+	atom string
+	list []*sexp
+}
+
+func (s *sexp) head() string {
+	if len(s.list) == 0 {
+		return ""
+	}
+	return s.list[0].atom
+}
+
+func parse(src string) ([]*sexp, error) {
+	var stack [][]*sexp
+	var top []*sexp
+	for i := 0; i < len(src); {
+		switch c := src[i]; {
+		case c == ';' && strings.HasPrefix(src[i:], ";;"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '(':
+			stack = append(stack, top)
+			top = nil
+			i++
+		case c == ')':
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("%d: unbalanced ')'", i)
+			}
+			list := &sexp{list: top}
+			top = append(stack[len(stack)-1], list)
+			stack = stack[:len(stack)-1]
+			i++
+		case c == ' ' || c == '\n' || c == '\t':
+			i++
+		case c == '"':
+			j := strings.IndexByte(src[i+1:], '"')
+			if j < 0 {
+				return nil, fmt.Errorf("%d: unterminated string", i)
+			}
+			top = append(top, &sexp{atom: src[i : i+j+2]})
+			i += j + 2
+		default:
+			j := i
+			for j < len(src) && !strings.ContainsRune(" \n\t()", rune(src[j])) {
+				j++
+			}
+			top = append(top, &sexp{atom: src[i:j]})
+			i = j
+		}
+	}
+	if len(stack) != 0 {
+		return nil, fmt.Errorf("unbalanced '('")
+	}
+	return top, nil
+}
+
+// validate 括弧の釣り合いと, 参照している関数, グローバル変数, ローカル変数, ラベルが宣言されていることを確かめる
+func validate(src string) error {
+	top, err := parse(src)
+	if err != nil {
+		return err
+	}
+	if len(top) != 1 || top[0].head() != "module" {
+		return fmt.Errorf("want one module")
+	}
+	funcs, globals := map[string]bool{}, map[string]bool{}
+	for _, field := range top[0].list[1:] {
+		if len(field.list) > 1 && strings.HasPrefix(field.list[1].atom, "$") {
+			switch field.head() {
+			case "func":
+				funcs[field.list[1].atom] = true
+			case "global":
+				globals[field.list[1].atom] = true
+			}
+		}
+	}
+	for _, field := range top[0].list[1:] {
+		if field.head() != "func" {
+			continue
+		}
+		locals := map[string]bool{}
+		for _, s := range field.list[1:] {
+			if h := s.head(); (h == "param" || h == "local") && len(s.list) > 1 {
+				locals[s.list[1].atom] = true
+			}
+		}
+		v := &validator{funcs: funcs, globals: globals, locals: locals}
+		for _, s := range field.list[1:] {
+			if err := v.walk(s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type validator struct {
+	funcs, globals, locals map[string]bool
+	labels                 []string // 外側のblock, loopのラベル
+}
+
+func (v *validator) label(name string) bool {
+	for _, l := range v.labels {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *validator) walk(s *sexp) error {
+	if s.list == nil {
+		return nil
+	}
+	args := s.list[1:]
+	ref := func(kind string, names map[string]bool) error {
+		if len(args) == 0 || !names[args[0].atom] {
+			return fmt.Errorf("%s: undefined %s", s.head(), kind)
+		}
+		return nil
+	}
+	var err error
+	switch h := s.head(); h {
+	case "call":
+		err = ref("func", v.funcs)
+	case "global.get", "global.set":
+		err = ref("global", v.globals)
+	case "local.get", "local.set":
+		err = ref("local", v.locals)
+	case "br", "br_if":
+		if len(args) == 0 || !v.label(args[0].atom) {
+			return fmt.Errorf("%s: label out of scope", h)
+		}
+	case "br_table":
+		for _, a := range args {
+			if a.list == nil && !v.label(a.atom) {
+				return fmt.Errorf("br_table: label out of scope: %s", a.atom)
+			}
+		}
+	case "block", "loop":
+		if len(args) == 0 || !strings.HasPrefix(args[0].atom, "$") {
+			return fmt.Errorf("%s: want label", h)
+		}
+		v.labels = append(v.labels, args[0].atom)
+		defer func() { v.labels = v.labels[:len(v.labels)-1] }()
+	}
+	if err != nil {
+		return err
+	}
+	for _, a := range args {
+		if err := v.walk(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		expect string
+	}{
+		{"ok", `(module (func $f (param $x i64) (block $b (br $b)) (call $f (local.get $x))))`, ""},
+		{"unbalanced", `(module (func $f)`, "unbalanced '('"},
+		{"func", `(module (func $f (call $g)))`, "call: undefined func"},
+		{"local", `(module (func $f (local.get $x)))`, "local.get: undefined local"},
+		{"label", `(module (func $f (block $b) (br $b)))`, "br: label out of scope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validate(tt.src)
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.expect {
+				t.Errorf("want=%q, got=%q", tt.expect, got)
+			}
+		})
+	}
+}